@@ -2,31 +2,95 @@ package auth
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/javor454/balancer/lifecycle"
+)
+
+// SessionTimeout is how long a registered client stays registered
+const SessionTimeout = 5 * time.Minute
+
+// TombstoneTTL is how long a removed client is remembered, repeated deletes and lookups within it report why it was removed
+const TombstoneTTL = 10 * time.Minute
+
+// ClientHistorySize is the number of status changes kept per client, the oldest are dropped so clients registering again
+// and again do not grow without bound
+const ClientHistorySize = 32
+
+// EvictionReason tells why a client is no longer registered
+type EvictionReason string
+
+const (
+	EvictionTimeout  EvictionReason = "timeout"
+	EvictionExplicit EvictionReason = "explicit"
 )
 
+// Tombstone records a removed client
+type Tombstone struct {
+	Name      string         `json:"name"`
+	Reason    EvictionReason `json:"reason"`
+	EvictedAt time.Time      `json:"evictedAt"`
+	History   []StatusChange `json:"history"`
+}
+
+// ClientStatus is a state in the lifecycle of a client
+type ClientStatus string
+
+const (
+	ClientRegistered ClientStatus = "registered"
+	ClientEvicted    ClientStatus = "evicted"
+)
+
+// StatusChange is a timestamped transition of a client, consumers compute how long it spent in each status from it
+type StatusChange struct {
+	Status ClientStatus   `json:"status"`
+	Reason EvictionReason `json:"reason,omitempty"`
+	At     time.Time      `json:"at"`
+}
+
 type Client struct {
-	Name         string
-	Weight       int
-	RegisteredAt time.Time
+	Name         string         `json:"name"`
+	Weight       int            `json:"weight"`
+	RegisteredAt time.Time      `json:"registeredAt"`
+	History      []StatusChange `json:"history"` // oldest first, kept across re-registrations while the tombstone lives
+	Scopes       []string       `json:"scopes"`  // route groups the client may access, nil allows all
+}
+
+// HasScope reports whether the client may access routes requiring scope
+func (c Client) HasScope(scope string) bool {
+	return c.Scopes == nil || slices.Contains(c.Scopes, scope)
 }
 
 type AuthHandler struct {
-	clients map[string]Client
-	mu      sync.RWMutex
+	clients    map[string]Client
+	tombstones map[string]Tombstone
+	mu         sync.RWMutex
+	background lifecycle.Group
 }
 
 func NewAuthHandler(ctx context.Context) *AuthHandler {
 	h := &AuthHandler{
-		clients: make(map[string]Client),
+		clients:    make(map[string]Client),
+		tombstones: make(map[string]Tombstone),
 	}
-	go h.cleanupClients(ctx)
+	h.background.Go(func() { h.cleanupClients(ctx) })
 
 	return h
 }
 
+// Shutdown waits for the client cleanup to stop, it stops once the context passed to NewAuthHandler is cancelled
+func (h *AuthHandler) Shutdown(ctx context.Context) error {
+	if err := h.background.Wait(ctx); err != nil {
+		return fmt.Errorf("auth handler shutdown failed: %w", err)
+	}
+
+	return nil
+}
+
 // VerifyRegistered validates if the client is registered
 func (h *AuthHandler) VerifyRegistered(name string) bool {
 	h.mu.RLock()
@@ -36,6 +100,28 @@ func (h *AuthHandler) VerifyRegistered(name string) bool {
 	return ok
 }
 
+// GetClient returns the registered client of the given name
+func (h *AuthHandler) GetClient(name string) (Client, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	client, ok := h.clients[name]
+	return client, ok
+}
+
+// SessionExpiresIn returns the remaining session time of a registered client
+func (h *AuthHandler) SessionExpiresIn(name string) (time.Duration, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	client, ok := h.clients[name]
+	if !ok {
+		return 0, false
+	}
+
+	return max(SessionTimeout-time.Since(client.RegisteredAt), 0), true
+}
+
 // ListRegisteredClients returns a list of registered clients
 func (h *AuthHandler) ListRegisteredClients() map[string]Client {
 	h.mu.RLock()
@@ -45,35 +131,93 @@ func (h *AuthHandler) ListRegisteredClients() map[string]Client {
 }
 
 // RegisterClient dummy implementation of registering a client TODO improve?
-func (h *AuthHandler) RegisterClient(name string, weight int) {
+// Scopes restrict the routes the client may access, nil allows all.
+func (h *AuthHandler) RegisterClient(name string, weight int, scopes []string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	now := time.Now()
+	history := h.clients[name].History
+	if tombstone, ok := h.tombstones[name]; ok {
+		history = tombstone.History
+	}
+
 	h.clients[name] = Client{
 		Name:         name,
 		Weight:       weight,
-		RegisteredAt: time.Now(),
+		RegisteredAt: now,
+		History:      appendHistory(history, StatusChange{Status: ClientRegistered, At: now}),
+		Scopes:       scopes,
 	}
-	log.Printf("Registered client \"%s\" with weight %d", name, weight)
+	delete(h.tombstones, name)
+	slog.Info("Registered client", "client", name, "weight", weight)
 }
 
-// cleanupClients cleans up clients that have been registered for more than 5 minutes every 5 seconds
+// GetTombstone returns the tombstone of a recently removed client
+func (h *AuthHandler) GetTombstone(name string) (Tombstone, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	tombstone, ok := h.tombstones[name]
+	return tombstone, ok
+}
+
+// DeregisterClient removes a registered client and returns its tombstone, false if it was not registered.
+// A client removed before is not registered, its tombstone is returned by GetTombstone.
+func (h *AuthHandler) DeregisterClient(name string) (Tombstone, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[name]; !ok {
+		return Tombstone{}, false
+	}
+	tombstone := h.evict(name, EvictionExplicit)
+	slog.Info("Deregistered client", "client", name)
+
+	return tombstone, true
+}
+
+// evict removes a client and leaves a tombstone, the caller holds the lock
+func (h *AuthHandler) evict(name string, reason EvictionReason) Tombstone {
+	now := time.Now()
+	history := appendHistory(h.clients[name].History, StatusChange{Status: ClientEvicted, Reason: reason, At: now})
+	tombstone := Tombstone{Name: name, Reason: reason, EvictedAt: now, History: history}
+	delete(h.clients, name)
+	h.tombstones[name] = tombstone
+
+	return tombstone
+}
+
+// appendHistory returns a copy of history ending with change and holding at most ClientHistorySize changes, the
+// history is shared with snapshots and tombstones so it is never appended to in place
+func appendHistory(history []StatusChange, change StatusChange) []StatusChange {
+	history = history[max(len(history)+1-ClientHistorySize, 0):]
+
+	return append(slices.Clone(history), change)
+}
+
+// cleanupClients cleans up clients that have been registered for more than SessionTimeout and expired tombstones every 5 seconds
 func (h *AuthHandler) cleanupClients(ctx context.Context) {
-	log.Println("Starting cleanup of clients")
+	slog.Info("Starting cleanup of clients")
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Stopping cleanup of clients")
+			slog.Info("Stopping cleanup of clients")
 			return
 		case <-ticker.C:
 			h.mu.Lock()
 			for name, client := range h.clients {
-				if time.Since(client.RegisteredAt) > 5*time.Minute {
-					log.Printf("Cleaning up client %s", name)
-					delete(h.clients, name)
+				if time.Since(client.RegisteredAt) > SessionTimeout {
+					slog.Info("Cleaning up client", "client", name)
+					h.evict(name, EvictionTimeout)
+				}
+			}
+			for name, tombstone := range h.tombstones {
+				if time.Since(tombstone.EvictedAt) > TombstoneTTL {
+					delete(h.tombstones, name)
 				}
 			}
 			h.mu.Unlock()
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this release.
+// Adding fields does not change it, older releases ignore fields they do not know. Renaming, removing or changing
+// the meaning of a field bumps it and registers a migration from the previous version in snapshotMigrations.
+const SnapshotVersion = 2
+
+// Snapshot is the persisted state of the registered clients
+type Snapshot struct {
+	Version    int         `json:"version"`
+	Clients    []Client    `json:"clients"`
+	Tombstones []Tombstone `json:"tombstones"`
+}
+
+// SnapshotCodec serializes snapshots, Decode accepts snapshots written by older releases
+type SnapshotCodec interface {
+	Encode(snapshot Snapshot) ([]byte, error)
+	Decode(data []byte) (Snapshot, error)
+}
+
+// snapshotMigrations rewrite a decoded snapshot of the version they are keyed by to the next version
+var snapshotMigrations = map[int]func(raw map[string]any) error{
+	1: migrateSnapshotV1,
+}
+
+// clientFieldsV1 maps the field names of clients in version 1 snapshots, written before clients had JSON tags
+var clientFieldsV1 = map[string]string{
+	"Name":         "name",
+	"Weight":       "weight",
+	"RegisteredAt": "registeredAt",
+	"History":      "history",
+	"Scopes":       "scopes",
+}
+
+// migrateSnapshotV1 renames the fields of clients to the names of their JSON tags
+func migrateSnapshotV1(raw map[string]any) error {
+	clients, ok := raw["clients"].([]any)
+	if !ok && raw["clients"] != nil {
+		return errors.New("clients are not a list")
+	}
+
+	for i, c := range clients {
+		client, ok := c.(map[string]any)
+		if !ok {
+			return fmt.Errorf("client %d is not an object", i)
+		}
+		for old, renamed := range clientFieldsV1 {
+			if value, ok := client[old]; ok {
+				delete(client, old)
+				client[renamed] = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// JSONSnapshotCodec encodes snapshots as JSON objects carrying their version
+type JSONSnapshotCodec struct{}
+
+func (JSONSnapshotCodec) Encode(snapshot Snapshot) ([]byte, error) {
+	snapshot.Version = SnapshotVersion
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding snapshot: %w", err)
+	}
+
+	return data, nil
+}
+
+// Decode migrates snapshots of older versions step by step to the current one before decoding them
+func (JSONSnapshotCodec) Decode(data []byte) (Snapshot, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Snapshot{}, fmt.Errorf("error parsing snapshot: %w", err)
+	}
+
+	version, ok := raw["version"].(float64)
+	if !ok || version < 1 || version != float64(int(version)) {
+		return Snapshot{}, errors.New("snapshot has no valid version")
+	}
+	if int(version) > SnapshotVersion {
+		return Snapshot{}, fmt.Errorf("snapshot version %d is newer than the supported version %d", int(version), SnapshotVersion)
+	}
+
+	for v := int(version); v < SnapshotVersion; v++ {
+		migrate, ok := snapshotMigrations[v]
+		if !ok {
+			return Snapshot{}, fmt.Errorf("no migration of snapshot version %d", v)
+		}
+		if err := migrate(raw); err != nil {
+			return Snapshot{}, fmt.Errorf("error migrating snapshot version %d: %w", v, err)
+		}
+		raw["version"] = v + 1
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("error encoding migrated snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(migrated, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("error decoding snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// Snapshot returns the registered clients and tombstones
+func (h *AuthHandler) Snapshot() Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	snapshot := Snapshot{
+		Version:    SnapshotVersion,
+		Clients:    make([]Client, 0, len(h.clients)),
+		Tombstones: make([]Tombstone, 0, len(h.tombstones)),
+	}
+	for _, client := range h.clients {
+		snapshot.Clients = append(snapshot.Clients, client)
+	}
+	for _, tombstone := range h.tombstones {
+		snapshot.Tombstones = append(snapshot.Tombstones, tombstone)
+	}
+
+	return snapshot
+}
+
+// Restore replaces the registered clients and tombstones with those of the snapshot, expired sessions are cleaned up as usual
+func (h *AuthHandler) Restore(snapshot Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients = make(map[string]Client, len(snapshot.Clients))
+	for _, client := range snapshot.Clients {
+		h.clients[client.Name] = client
+	}
+	h.tombstones = make(map[string]Tombstone, len(snapshot.Tombstones))
+	for _, tombstone := range snapshot.Tombstones {
+		h.tombstones[tombstone.Name] = tombstone
+	}
+}
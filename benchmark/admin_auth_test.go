@@ -0,0 +1,121 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestAdminAuth asserts admin endpoints require the admin token, are refused while none is configured and that the
+// dashboard token is kept in a cookie which authenticates its event stream
+func TestAdminAuth(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(server.AdminTokenHeader) != "" {
+			t.Errorf("Admin token forwarded to handler")
+		}
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		header     string
+		cookie     string
+		wantStatus int
+	}{
+		{name: "no token configured", path: "/admin/diagnostics", header: "", wantStatus: http.StatusForbidden},
+		{name: "no token configured with any sent", path: "/admin/diagnostics", header: "guess", wantStatus: http.StatusForbidden},
+		{name: "missing token", token: "secret", path: "/admin/diagnostics", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", path: "/admin/read-only", header: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", path: "/admin/read-only", header: "secret", wantStatus: http.StatusOK},
+		{name: "event stream without cookie", token: "secret", path: "/admin/ui/events", wantStatus: http.StatusUnauthorized},
+		{name: "event stream with cookie", token: "secret", path: "/admin/ui/events", cookie: "secret", wantStatus: http.StatusOK},
+		{name: "query token only on dashboard", token: "secret", path: "/admin/ui/events?token=secret", wantStatus: http.StatusUnauthorized},
+		{name: "backend registration", path: "/admin/backends/register", wantStatus: http.StatusOK},
+		{name: "not admin", path: "/health", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(server.AdminTokenHeader, tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "balancer_admin", Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+
+			server.WithAdminAuth(tt.token)(handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+		})
+	}
+
+	t.Run("dashboard sets cookie", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		server.WithAdminAuth("secret")(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/ui?token=secret", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		cookie := rec.Header().Get("Set-Cookie")
+		if !strings.Contains(cookie, "balancer_admin=secret") || !strings.Contains(cookie, "HttpOnly") {
+			t.Errorf("Expected HttpOnly admin cookie, got %q", cookie)
+		}
+	})
+}
+
+// TestAdminReadOnly asserts the read-only switch requires the admin token and can leave read-only mode it entered
+func TestAdminReadOnly(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+	defer server.SetReadOnly(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backends, urls := NewTestBackendPool(1, 0)
+	defer CleanupBackends(backends)
+
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: urls})
+
+	httpConfig := NewTestHttpConfig([]string{"/admin/read-only"}, []string{"/admin/*"})
+	httpConfig.AdminToken = "secret"
+	ts := NewTestBalancer(t, ctx, httpConfig, proxyServerPool)
+
+	setReadOnly := func(token string, enabled string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, ts.URL+"/admin/read-only", strings.NewReader(`{"enabled":`+enabled+`}`))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set(server.AdminTokenHeader, token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := setReadOnly("guess", "true"); status != http.StatusUnauthorized || server.ReadOnly() {
+		t.Fatalf("Expected status %d and read-only disabled, got %d and %v", http.StatusUnauthorized, status, server.ReadOnly())
+	}
+	if status := setReadOnly("secret", "true"); status != http.StatusOK || !server.ReadOnly() {
+		t.Fatalf("Expected status %d and read-only enabled, got %d and %v", http.StatusOK, status, server.ReadOnly())
+	}
+	if status := setReadOnly("secret", "false"); status != http.StatusOK || server.ReadOnly() {
+		t.Fatalf("Expected status %d and read-only disabled, got %d and %v", http.StatusOK, status, server.ReadOnly())
+	}
+}
@@ -0,0 +1,104 @@
+package benchmark
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestAffinityCookie asserts clients stay on the backend named by their cookie, the cookie does not reveal the backend,
+// tampered cookies are ignored and clients of a disabled backend are moved to another one
+func TestAffinityCookie(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var backendURLs []string
+	backendNames := make(map[string]string)
+	for _, name := range []string{"a", "b"} {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer backend.Close()
+		backendURLs = append(backendURLs, backend.URL)
+		backendNames[backend.URL] = name
+	}
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{
+		URLs:        backendURLs,
+		Balancing:   server.BalancingConfig{Affinity: server.AffinityConfig{CookieName: "lb", Secret: "secret"}},
+		MaxCapacity: 2,
+	})
+	ts := NewTestBalancer(t, ctx, NewTestHttpConfig([]string{"/data"}, []string{"/data"}), proxyServerPool)
+
+	get := func(cookie *http.Cookie) (string, *http.Cookie) {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/data", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		for _, c := range resp.Cookies() {
+			if c.Name == "lb" {
+				return string(body), c
+			}
+		}
+
+		return string(body), nil
+	}
+
+	first, cookie := get(nil)
+	if cookie == nil {
+		t.Fatal("Expected an affinity cookie on the first response")
+	}
+	for _, backendURL := range backendURLs {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(backendURL))
+		if hostPort := strings.TrimPrefix(backendURL, "http://"); strings.Contains(cookie.Value, hostPort) || strings.Contains(cookie.Value, encoded[:len(encoded)-4]) {
+			t.Errorf("Expected the cookie not to reveal backend %s, got %q", backendURL, cookie.Value)
+		}
+	}
+
+	for range 4 {
+		if got, reissued := get(cookie); got != first || reissued != nil {
+			t.Fatalf("Expected pinned requests to reach backend %s without a new cookie, got %s and cookie %v", first, got, reissued)
+		}
+	}
+
+	if _, reissued := get(&http.Cookie{Name: "lb", Value: "x" + cookie.Value}); reissued == nil {
+		t.Error("Expected a tampered cookie to be ignored and replaced")
+	}
+
+	for _, backend := range proxyServerPool.Backends() {
+		if backendNames[backend.URL] == first {
+			if _, err := proxyServerPool.SetBackendEnabled(backend.ID, false); err != nil {
+				t.Fatalf("Failed to disable backend: %v", err)
+			}
+		}
+	}
+	got, reissued := get(cookie)
+	if got == first {
+		t.Fatalf("Expected requests pinned to the disabled backend %s to move to the other one", first)
+	}
+	if reissued == nil || reissued.Value == cookie.Value {
+		t.Fatal("Expected a cookie naming the new backend")
+	}
+	if again, _ := get(reissued); again != got {
+		t.Errorf("Expected the new cookie to pin requests to backend %s, got %s", got, again)
+	}
+}
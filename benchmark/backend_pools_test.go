@@ -0,0 +1,47 @@
+package benchmark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestBackendPoolAuth asserts pools load credentials of their own while pools without them keep using the default ones
+func TestBackendPoolAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+		"BackendAuth": {"Type": "bearer", "Token": "default-token"},
+		"BackendPools": {
+			"billing": {"Servers": ["http://billing:8080"], "BackendAuth": {"Type": "basic", "Username": "billing", "Password": "secret"}},
+			"search": {"Servers": ["http://search:8080"]},
+			"public": {"Servers": ["http://public:8080"], "BackendAuth": null}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	httpConfig, err := server.LoadHttpConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	billing := httpConfig.BackendPools["billing"].BackendAuth
+	if billing == nil || billing.Type != server.BackendAuthBasic || billing.Username != "billing" {
+		t.Fatalf("Expected basic auth of the billing pool, got %+v", billing)
+	}
+	for _, name := range []string{"search", "public"} {
+		if poolAuth := httpConfig.BackendPools[name].BackendAuth; poolAuth != nil {
+			t.Fatalf("Expected pool %s to use the default credentials, got %+v", name, poolAuth)
+		}
+	}
+
+	httpConfig.BackendProtocol = server.BackendProtocolH2C
+	httpConfig.BackendAuth = server.BackendAuthConfig{}
+	billing.Type = server.BackendAuthMTLS
+	if err := httpConfig.Validate(); err == nil {
+		t.Fatalf("Expected mTLS credentials of a pool to be rejected with h2c backends")
+	}
+}
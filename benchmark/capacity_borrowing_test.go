@@ -0,0 +1,64 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestCapacityBorrowing asserts a pool whose requests wait borrows idle capacity of another pool up to the limit and
+// the lender takes it back once its own requests wait
+func TestCapacityBorrowing(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	borrower := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{Name: "borrower", MaxCapacity: 1})
+	lender := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{Name: "lender", MaxCapacity: 3})
+	server.ShareCapacity(ctx, server.CapacityBorrowingConfig{Interval: 10 * time.Millisecond, MaxBorrowed: 1, MaxLent: 2}, []server.ServerPool{borrower, lender})
+
+	acquire := func(pool server.ServerPool, timeout time.Duration) <-chan error {
+		acquired := make(chan error, 1)
+		go func() { acquired <- pool.AcquireCapacityWithTimeout(ctx, timeout) }()
+		return acquired
+	}
+
+	if err := <-acquire(borrower, time.Second); err != nil {
+		t.Fatalf("Failed to acquire capacity of the borrower: %v", err)
+	}
+	if err := <-acquire(borrower, time.Second); err != nil {
+		t.Fatalf("Expected a waiting request to be granted borrowed capacity, got %v", err)
+	}
+	if got := borrower.GetMaxCapacity(); got != 2 {
+		t.Errorf("Expected the borrower to hold capacity 2, got %d", got)
+	}
+	if got := lender.GetMaxCapacity(); got != 2 {
+		t.Errorf("Expected the lender to be left with capacity 2, got %d", got)
+	}
+	if err := <-acquire(borrower, 100*time.Millisecond); err == nil {
+		t.Error("Expected the borrower not to borrow beyond its limit")
+	}
+
+	for range 2 {
+		if err := <-acquire(lender, time.Second); err != nil {
+			t.Fatalf("Failed to acquire capacity of the lender: %v", err)
+		}
+	}
+	if err := <-acquire(lender, time.Second); err != nil {
+		t.Fatalf("Expected the lender to take its capacity back for a waiting request, got %v", err)
+	}
+	if got := lender.GetMaxCapacity(); got != 3 {
+		t.Errorf("Expected the lender to hold its capacity 3 again, got %d", got)
+	}
+	if got := borrower.GetMaxCapacity(); got != 1 {
+		t.Errorf("Expected the borrower to be back at capacity 1, got %d", got)
+	}
+}
@@ -0,0 +1,57 @@
+package benchmark
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/auth"
+	"github.com/javor454/balancer/server"
+)
+
+// TestClientStoreRestoresClients asserts clients saved on shutdown are restored by the next start and that the periodic
+// snapshots stop before the final one is written
+func TestClientStoreRestoresClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.db")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	authHandler := auth.NewAuthHandler(ctx)
+	store, err := server.NewClientStore(server.ClientStoreConfig{Path: path, Interval: 10 * time.Millisecond}, authHandler)
+	if err != nil {
+		t.Fatalf("Failed to open client store: %v", err)
+	}
+	store.Run(ctx)
+	authHandler.RegisterClient("persisted", 3, []string{"reports"})
+	time.Sleep(30 * time.Millisecond)
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	if err := store.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Periodic snapshots did not stop: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close client store: %v", err)
+	}
+
+	restartCtx, restartCancel := context.WithCancel(context.Background())
+	defer restartCancel()
+	restarted := auth.NewAuthHandler(restartCtx)
+	reopened, err := server.NewClientStore(server.ClientStoreConfig{Path: path}, restarted)
+	if err != nil {
+		t.Fatalf("Failed to reopen client store: %v", err)
+	}
+	defer reopened.Close()
+	if err := reopened.Restore(); err != nil {
+		t.Fatalf("Failed to restore clients: %v", err)
+	}
+
+	client, ok := restarted.GetClient("persisted")
+	if !ok {
+		t.Fatalf("Client not restored")
+	}
+	if client.Weight != 3 || !client.HasScope("reports") || client.HasScope("admin") {
+		t.Fatalf("Restored client differs: %+v", client)
+	}
+}
@@ -0,0 +1,100 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/javor454/balancer/auth"
+	"github.com/javor454/balancer/server"
+)
+
+// TestClientDeregistrationRequiresAuth asserts anyone may look a client up but only authorized clients may deregister it
+func TestClientDeregistrationRequiresAuth(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backends, urls := NewTestBackendPool(1, 0)
+	defer CleanupBackends(backends)
+
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: urls})
+
+	defaults := server.NewDefaultHttpConfig()
+	httpConfig := NewTestHttpConfig(defaults.WhitelistedPaths, defaults.AuthBlacklistedPaths)
+	ts := NewTestBalancer(t, ctx, httpConfig, proxyServerPool)
+	ts.AuthHandler.RegisterClient("victim", 1, nil)
+	ts.AuthHandler.RegisterClient("caller", 1, nil)
+
+	send := func(method string, authorization string) int {
+		req, err := http.NewRequestWithContext(ctx, method, ts.URL+"/clients/victim", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := send(http.MethodGet, ""); status != http.StatusOK {
+		t.Fatalf("Expected unauthenticated lookup to return %d, got %d", http.StatusOK, status)
+	}
+	if status := send(http.MethodDelete, ""); status != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthenticated deregistration to return %d, got %d", http.StatusUnauthorized, status)
+	}
+	if _, ok := ts.AuthHandler.GetClient("victim"); !ok {
+		t.Fatalf("Client deregistered by an unauthenticated request")
+	}
+	if status := send(http.MethodDelete, "caller"); status != http.StatusOK {
+		t.Fatalf("Expected authenticated deregistration to return %d, got %d", http.StatusOK, status)
+	}
+}
+
+// TestClientHistoryIsCapped asserts clients registering again and again keep only their newest status changes
+func TestClientHistoryIsCapped(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	authHandler := auth.NewAuthHandler(ctx)
+	for range auth.ClientHistorySize {
+		authHandler.RegisterClient("flapping", 1, nil)
+		authHandler.DeregisterClient("flapping")
+	}
+	tombstone, ok := authHandler.GetTombstone("flapping")
+	if !ok {
+		t.Fatalf("Expected a tombstone of the deregistered client")
+	}
+	if len(tombstone.History) != auth.ClientHistorySize {
+		t.Fatalf("Expected tombstone history of %d changes, got %d", auth.ClientHistorySize, len(tombstone.History))
+	}
+
+	authHandler.RegisterClient("flapping", 1, nil)
+	client, _ := authHandler.GetClient("flapping")
+	if len(client.History) != auth.ClientHistorySize {
+		t.Fatalf("Expected client history of %d changes, got %d", auth.ClientHistorySize, len(client.History))
+	}
+	if last := client.History[len(client.History)-1]; last.Status != auth.ClientRegistered {
+		t.Fatalf("Expected the newest change to be kept, got %+v", last)
+	}
+	if first := client.History[0]; first.Status != auth.ClientEvicted {
+		t.Fatalf("Expected the oldest changes to be dropped, got %+v first", first)
+	}
+}
@@ -0,0 +1,127 @@
+package benchmark
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestCompressionPassthrough proxies responses of gzip and plain backends with and without the compression middleware and
+// asserts clients get each body encoded at most once with a Content-Length matching what was sent
+func TestCompressionPassthrough(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	payload := strings.Repeat(`{"status":"ok","message":"compressible"}`, 200)
+
+	tests := []struct {
+		name             string
+		gzipBackend      bool
+		compression      bool
+		upstreamEncoding string
+		wantEncoding     string
+		wantUpstream     string
+	}{
+		{name: "plain backend", wantUpstream: "gzip"},
+		{name: "plain backend, compression", compression: true, wantEncoding: "gzip", wantUpstream: "gzip"},
+		{name: "gzip backend forwarded", gzipBackend: true, wantEncoding: "gzip", wantUpstream: "gzip"},
+		{name: "gzip backend forwarded, compression", gzipBackend: true, compression: true, wantEncoding: "gzip", wantUpstream: "gzip"},
+		{name: "gzip backend stripped", gzipBackend: true, upstreamEncoding: server.UpstreamEncodingStrip, wantUpstream: "gzip"},
+		{name: "gzip backend stripped, compression", gzipBackend: true, compression: true, upstreamEncoding: server.UpstreamEncodingStrip, wantEncoding: "gzip", wantUpstream: "gzip"},
+		{name: "gzip backend identity", gzipBackend: true, upstreamEncoding: server.UpstreamEncodingIdentity, wantUpstream: "identity"},
+		{name: "gzip backend identity, compression", gzipBackend: true, compression: true, upstreamEncoding: server.UpstreamEncodingIdentity, wantEncoding: "gzip", wantUpstream: "identity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var upstreamEncoding atomic.Value
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/health" {
+					return
+				}
+				upstreamEncoding.Store(r.Header.Get("Accept-Encoding"))
+
+				body := []byte(payload)
+				w.Header().Set("Content-Type", "application/json")
+				if tt.gzipBackend && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+					var compressed bytes.Buffer
+					gz := gzip.NewWriter(&compressed)
+					gz.Write(body)
+					gz.Close()
+					body = compressed.Bytes()
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write(body)
+			}))
+			defer backend.Close()
+
+			proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: []string{backend.URL}})
+
+			httpConfig := NewTestHttpConfig([]string{"/data"}, []string{"/data"})
+			httpConfig.Compression = server.CompressionConfig{Enabled: tt.compression, UpstreamEncoding: tt.upstreamEncoding}
+			ts := NewTestBalancer(t, ctx, httpConfig, proxyServerPool)
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/data", nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			// the client must see the encoding as sent, the default transport would decompress transparently
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			raw, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+
+			if got := upstreamEncoding.Load(); got != tt.wantUpstream {
+				t.Errorf("Backend got Accept-Encoding %q, want %q", got, tt.wantUpstream)
+			}
+			if encodings := resp.Header.Values("Content-Encoding"); len(encodings) > 1 {
+				t.Fatalf("Response encoded more than once: %v", encodings)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if length := resp.Header.Get("Content-Length"); length != "" && length != strconv.Itoa(len(raw)) {
+				t.Fatalf("Content-Length %s does not match the %d bytes sent", length, len(raw))
+			}
+
+			body := raw
+			if tt.wantEncoding == "gzip" {
+				gz, err := gzip.NewReader(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("Response is not gzip: %v", err)
+				}
+				if body, err = io.ReadAll(gz); err != nil {
+					t.Fatalf("Failed to decompress response: %v", err)
+				}
+			}
+			if string(body) != payload {
+				t.Fatalf("Unexpected body of %d bytes", len(body))
+			}
+		})
+	}
+}
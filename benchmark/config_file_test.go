@@ -0,0 +1,193 @@
+package benchmark
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// writeConfigFile writes a config file named name into a temporary directory and returns its path
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	return path
+}
+
+// TestLoadHttpConfigYAML asserts YAML files are decoded like JSON files, fields missing in the file keep their default
+func TestLoadHttpConfigYAML(t *testing.T) {
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, name, `
+# comments are allowed
+port: 9090
+"//": keys starting with // are comments too
+requestTimeout: 30s
+proxyServers:
+  - http://backend1:8080
+  - http://backend2:8080
+retry:
+  maxBufferedBodySize: 2MiB
+balancing:
+  mode: p2c
+backendPools:
+  billing:
+    servers: [http://billing:8080]
+logSampleRate: 0.5
+`)
+
+			config, err := server.LoadHttpConfig(path)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			if config.Port != 9090 {
+				t.Errorf("Expected port 9090, got %d", config.Port)
+			}
+			if config.RequestTimeout != 30*time.Second {
+				t.Errorf("Expected request timeout 30s, got %s", config.RequestTimeout)
+			}
+			if want := []string{"http://backend1:8080", "http://backend2:8080"}; !reflect.DeepEqual(config.ProxyServers, want) {
+				t.Errorf("Expected proxy servers %v, got %v", want, config.ProxyServers)
+			}
+			if config.Retry.MaxBufferedBodySize != 2<<20 {
+				t.Errorf("Expected max buffered body size %d, got %d", 2<<20, config.Retry.MaxBufferedBodySize)
+			}
+			if config.Balancing.Mode != "p2c" {
+				t.Errorf("Expected balancing mode p2c, got %q", config.Balancing.Mode)
+			}
+			if want := []string{"http://billing:8080"}; !reflect.DeepEqual(config.BackendPools["billing"].Servers, want) {
+				t.Errorf("Expected billing servers %v, got %v", want, config.BackendPools["billing"].Servers)
+			}
+			if config.LogSampleRate != 0.5 {
+				t.Errorf("Expected log sample rate 0.5, got %v", config.LogSampleRate)
+			}
+			if defaults := server.NewDefaultHttpConfig(); config.MaxCapacity != defaults.MaxCapacity {
+				t.Errorf("Expected default max capacity %d, got %d", defaults.MaxCapacity, config.MaxCapacity)
+			}
+		})
+	}
+}
+
+// TestLoadHttpConfigErrors asserts invalid files are refused with every invalid field named
+func TestLoadHttpConfigErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		content    string
+		wantErrors []string
+	}{
+		{
+			name:       "invalid duration",
+			file:       "config.yaml",
+			content:    "requestTimeout: 10 seconds\n",
+			wantErrors: []string{`requestTimeout: invalid duration "10 seconds"`},
+		},
+		{
+			name:       "duration without unit",
+			file:       "config.json",
+			content:    `{"drainTimeout": 30}`,
+			wantErrors: []string{"drainTimeout: expected a duration"},
+		},
+		{
+			name:       "invalid size",
+			file:       "config.json",
+			content:    `{"retry": {"maxBufferedBodySize": "10 parsecs"}}`,
+			wantErrors: []string{`retry.maxBufferedBodySize: invalid size "10 parsecs"`},
+		},
+		{
+			name:       "unknown fields",
+			file:       "config.yaml",
+			content:    "prot: 9090\nbalancing:\n  strategy: p2c\n",
+			wantErrors: []string{"prot: unknown field", "balancing.strategy: unknown field"},
+		},
+		{
+			name: "several errors reported together",
+			file: "config.yaml",
+			content: `
+port: eighty
+verboseLogging: maybe
+healthCheckInterval: 5 minutes
+proxyServers:
+  - http://backend1:8080
+  - 8080
+backendPools:
+  billing:
+    servers: http://billing:8080
+    typo: true
+`,
+			wantErrors: []string{
+				"port: expected an integer",
+				"verboseLogging: expected true or false",
+				`healthCheckInterval: invalid duration "5 minutes"`,
+				"proxyServers[1]: expected a string",
+				"backendPools.billing.servers: expected an array",
+				"backendPools.billing.typo: unknown field",
+			},
+		},
+		{
+			name:       "invalid YAML",
+			file:       "config.yml",
+			content:    "port: [9090\n",
+			wantErrors: []string{"error parsing config"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := server.LoadHttpConfig(writeConfigFile(t, tt.file, tt.content))
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+// TestHttpConfigValidateReportsAll asserts a file which decodes but cannot work is refused with every problem reported,
+// including the backend URLs and cross-field checks
+func TestHttpConfigValidateReportsAll(t *testing.T) {
+	config, err := server.LoadHttpConfig(writeConfigFile(t, "config.yaml", `
+requestTimeout: 5s
+acquireCapacityTimeout: 10s
+proxyServers:
+  - backend1:8080
+  - http://backend2:8080
+backendPools:
+  billing:
+    servers: [ftp://billing]
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	err = config.Validate()
+	if err == nil {
+		t.Fatal("Expected the config to be invalid")
+	}
+	for _, want := range []string{
+		"acquire capacity timeout 10s exceeds the request timeout 5s",
+		`ProxyServers[0]: invalid backend URL "backend1:8080"`,
+		`BackendPools.billing.Servers[0]: invalid backend URL "ftp://billing"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "ProxyServers[1]") {
+		t.Errorf("Expected the valid backend URL to be accepted, got:\n%v", err)
+	}
+}
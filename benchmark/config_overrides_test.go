@@ -0,0 +1,197 @@
+package benchmark
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestEnvConfigOverrides asserts environment variables are mapped to the config fields they name
+func TestEnvConfigOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "top-level field",
+			environ: []string{"BALANCER_PORT=9090"},
+			want:    map[string]string{"Port": "9090"},
+		},
+		{
+			name:    "nested field",
+			environ: []string{"BALANCER_BALANCING_MODE=p2c", "BALANCER_RETRY_MAX_BUFFERED_BODY_SIZE=2MB"},
+			want:    map[string]string{"Balancing.Mode": "p2c", "Retry.MaxBufferedBodySize": "2MB"},
+		},
+		{
+			name:    "strategy alias",
+			environ: []string{"BALANCER_STRATEGY=consistent-hash"},
+			want:    map[string]string{"Balancing.Mode": "consistent-hash"},
+		},
+		{
+			name:    "list and value containing equals signs",
+			environ: []string{"BALANCER_PROXY_SERVERS=http://a:8080,http://b:8080", "BALANCER_ADMIN_TOKEN=a=b"},
+			want:    map[string]string{"ProxyServers": "http://a:8080,http://b:8080", "AdminToken": "a=b"},
+		},
+		{
+			name:    "other variables ignored",
+			environ: []string{"PATH=/usr/bin", "HOME=/root", "BALANCERPORT=1"},
+			want:    map[string]string{},
+		},
+		{
+			name:    "unknown variable",
+			environ: []string{"BALANCER_PORTS=9090"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.EnvConfigOverrides(tt.environ)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got overrides %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected overrides %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestApplyConfigOverrides asserts the text of overrides is parsed by the type of the field it sets
+func TestApplyConfigOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		check     func(t *testing.T, config *server.HttpConfig)
+		wantErr   bool
+	}{
+		{
+			name:      "comma-separated list",
+			overrides: map[string]string{"ProxyServers": "http://a:8080, http://b:8080,"},
+			check: func(t *testing.T, config *server.HttpConfig) {
+				if want := []string{"http://a:8080", "http://b:8080"}; !reflect.DeepEqual(config.ProxyServers, want) {
+					t.Errorf("Expected proxy servers %v, got %v", want, config.ProxyServers)
+				}
+			},
+		},
+		{
+			name:      "JSON list",
+			overrides: map[string]string{"ProxyServers": `["http://a:8080,1", "http://b:8080"]`},
+			check: func(t *testing.T, config *server.HttpConfig) {
+				if want := []string{"http://a:8080,1", "http://b:8080"}; !reflect.DeepEqual(config.ProxyServers, want) {
+					t.Errorf("Expected proxy servers %v, got %v", want, config.ProxyServers)
+				}
+			},
+		},
+		{
+			name: "scalars",
+			overrides: map[string]string{
+				"Port":                      "9090",
+				"VerboseLogging":            "false",
+				"HealthCheckInterval":       "1m30s",
+				"Retry.MaxBufferedBodySize": "64KiB",
+				"Balancing.Mode":            "p2c",
+			},
+			check: func(t *testing.T, config *server.HttpConfig) {
+				if config.Port != 9090 {
+					t.Errorf("Expected port 9090, got %d", config.Port)
+				}
+				if config.VerboseLogging {
+					t.Error("Expected verbose logging to be disabled")
+				}
+				if config.HealthCheckInterval != 90*time.Second {
+					t.Errorf("Expected health check interval 1m30s, got %s", config.HealthCheckInterval)
+				}
+				if config.Retry.MaxBufferedBodySize != 64<<10 {
+					t.Errorf("Expected max buffered body size %d, got %d", 64<<10, config.Retry.MaxBufferedBodySize)
+				}
+				if config.Balancing.Mode != "p2c" {
+					t.Errorf("Expected balancing mode p2c, got %q", config.Balancing.Mode)
+				}
+			},
+		},
+		{
+			name:      "invalid bool",
+			overrides: map[string]string{"VerboseLogging": "yes please"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid integer",
+			overrides: map[string]string{"Port": "eighty"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid duration",
+			overrides: map[string]string{"HealthCheckInterval": "5 minutes"},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown field",
+			overrides: map[string]string{"Balancing.Strategy": "p2c"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := server.NewDefaultHttpConfig()
+			err := server.ApplyConfigOverrides(config, tt.overrides)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+// TestLoadConfigPrecedence asserts flags override environment variables, which override the config file, which overrides
+// the defaults
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"port": 8000, "maxCapacity": 7, "drainTimeout": "1m", "adminToken": "file"}`)
+	environ := []string{"BALANCER_MAX_CAPACITY=8", "BALANCER_ADMIN_TOKEN=env", "BALANCER_STRATEGY=p2c"}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagOverrides := server.RegisterConfigFlags(flags)
+	if err := flags.Parse([]string{"-admin-token=flag", "-balancing.mode", "consistent-hash"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	config, err := server.LoadConfig(path, environ, flagOverrides)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	defaults := server.NewDefaultHttpConfig()
+	if config.ShutdownTimeout != defaults.ShutdownTimeout {
+		t.Errorf("Expected default shutdown timeout %s, got %s", defaults.ShutdownTimeout, config.ShutdownTimeout)
+	}
+	if config.Port != 8000 || config.DrainTimeout != time.Minute {
+		t.Errorf("Expected port 8000 and drain timeout 1m from the file, got %d and %s", config.Port, config.DrainTimeout)
+	}
+	if config.MaxCapacity != 8 {
+		t.Errorf("Expected max capacity 8 from the environment, got %d", config.MaxCapacity)
+	}
+	if config.AdminToken != "flag" || config.Balancing.Mode != "consistent-hash" {
+		t.Errorf("Expected admin token and balancing mode from flags, got %q and %q", config.AdminToken, config.Balancing.Mode)
+	}
+
+	if _, err := server.LoadConfig(path, []string{"BALANCER_MAX_CAPACITY=many"}, nil); err == nil {
+		t.Error("Expected an invalid environment variable to be refused")
+	}
+}
@@ -0,0 +1,155 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// slowBackend holds requests other than health checks until released, started receives one value per request
+type slowBackend struct {
+	*httptest.Server
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowBackend(t *testing.T) *slowBackend {
+	t.Helper()
+
+	b := &slowBackend{started: make(chan struct{}, 10), release: make(chan struct{})}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		b.started <- struct{}{}
+		select {
+		case <-b.release:
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(b.Close)
+
+	return b
+}
+
+// sendSlow sends a request through the balancer once the backend has started it, its status arrives on the returned channel
+func sendSlow(t *testing.T, url string, backend *slowBackend) <-chan int {
+	t.Helper()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	select {
+	case <-backend.started:
+	case <-time.After(time.Second):
+		t.Fatal("Request did not reach the backend")
+	}
+
+	return status
+}
+
+// TestDrainStopsOnceEnabled asserts a backend enabled again before the drain timeout keeps the requests it accepted,
+// the deadline of the earlier drain must not cancel them
+func TestDrainStopsOnceEnabled(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const drainTimeout = 200 * time.Millisecond
+	backend := newSlowBackend(t)
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{
+		URLs:         []string{backend.URL},
+		DrainTimeout: drainTimeout,
+		MaxCapacity:  2,
+	})
+	ts := NewTestBalancer(t, ctx, NewTestHttpConfig([]string{"/slow"}, []string{"/slow"}), proxyServerPool)
+
+	before := sendSlow(t, ts.URL+"/slow", backend)
+	id := proxyServerPool.Backends()[0].ID
+	if _, err := proxyServerPool.SetBackendEnabled(id, false); err != nil {
+		t.Fatalf("Failed to drain backend: %v", err)
+	}
+	if _, err := proxyServerPool.SetBackendEnabled(id, true); err != nil {
+		t.Fatalf("Failed to enable backend: %v", err)
+	}
+	after := sendSlow(t, ts.URL+"/slow", backend)
+
+	time.Sleep(2 * drainTimeout)
+	close(backend.release)
+
+	if status := <-before; status != http.StatusOK {
+		t.Errorf("Expected request accepted before the drain to finish with %d, got %d", http.StatusOK, status)
+	}
+	if status := <-after; status != http.StatusOK {
+		t.Errorf("Expected request accepted after enabling to finish with %d, got %d", http.StatusOK, status)
+	}
+}
+
+// TestSwitchBackStopsDrain asserts switching traffic back to a pool still draining keeps the requests it accepted since,
+// the deadline of the first switchover must not cancel them
+func TestSwitchBackStopsDrain(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const drainTimeout = 200 * time.Millisecond
+	blueBackend, greenBackend := newSlowBackend(t), newSlowBackend(t)
+	blue := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{Name: "blue", URLs: []string{blueBackend.URL}, DrainTimeout: drainTimeout, MaxCapacity: 2})
+	green := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{Name: "green", URLs: []string{greenBackend.URL}, DrainTimeout: drainTimeout, MaxCapacity: 2})
+	poolRouter, err := server.NewPoolRouter(blue, map[string]server.ServerPool{"green": green}, server.DarkLaunchConfig{}, nil, nil, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create pool router: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lease, err := poolRouter.Route(r).NextServer(r)
+		if err != nil {
+			http.Error(w, "No available backend servers", http.StatusServiceUnavailable)
+			return
+		}
+		lease.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	before := sendSlow(t, ts.URL+"/slow", blueBackend)
+	if _, err := poolRouter.Switch("blue", "green"); err != nil {
+		t.Fatalf("Failed to switch to green: %v", err)
+	}
+	if _, err := poolRouter.Switch("green", "blue"); err != nil {
+		t.Fatalf("Failed to switch back to blue: %v", err)
+	}
+	after := sendSlow(t, ts.URL+"/slow", blueBackend)
+
+	time.Sleep(2 * drainTimeout)
+	close(blueBackend.release)
+
+	if status := <-before; status != http.StatusOK {
+		t.Errorf("Expected request accepted before the switchover to finish with %d, got %d", http.StatusOK, status)
+	}
+	if status := <-after; status != http.StatusOK {
+		t.Errorf("Expected request accepted after switching back to finish with %d, got %d", http.StatusOK, status)
+	}
+}
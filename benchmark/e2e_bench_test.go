@@ -37,22 +37,32 @@ func BenchmarkE2EThroughput(b *testing.B) {
 	defer CleanupBackends(backends)
 
 	httpClient := &http.Client{Timeout: clientRequestTimeout}
-	proxyServerPool, err := server.NewProxyServerPool(ctx, urls, healthCheckInterval, httpClient, capacityLimit, acquireCapacityTimeout)
+	healthProbe, err := server.NewHealthProbe(server.HealthProbeHttp, httpClient, clientRequestTimeout)
+	if err != nil {
+		b.Fatalf("Failed to create health probe: %v", err)
+	}
+
+	proxyServerPool, err := server.NewProxyServerPool(ctx, server.ProxyServerPoolOptions{
+		Name:                   "default",
+		URLs:                   urls,
+		HealthCheckInterval:    healthCheckInterval,
+		HealthProbe:            healthProbe,
+		MaxCapacity:            capacityLimit,
+		AcquireCapacityTimeout: acquireCapacityTimeout,
+	})
 	if err != nil {
 		b.Fatalf("Failed to create proxy server pool: %v", err)
 	}
 
 	ts := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			handler, err := proxyServerPool.NextServer(r.Context())
+			lease, err := proxyServerPool.NextServer(r)
 			if err != nil {
 				http.Error(w, "No available backend servers", http.StatusServiceUnavailable)
 				return
 			}
 
-			handler.ServeHTTP(w, r)
-
-			proxyServerPool.ReleaseCapacity()
+			lease.ServeHTTP(w, r)
 		}))
 
 	defer ts.Close()
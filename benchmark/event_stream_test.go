@@ -0,0 +1,109 @@
+package benchmark
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestEventStreamPassthrough asserts server-sent events reach the client one by one through access logging and
+// compression while the stream holds pool capacity until it ends
+func TestEventStreamPassthrough(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	const events = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		rc := http.NewResponseController(w)
+		for i := range events {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			rc.Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: []string{backend.URL}})
+
+	httpConfig := NewTestHttpConfig([]string{"/events"}, []string{"/events"})
+	httpConfig.LogSampleRate = 1
+	httpConfig.Compression = server.CompressionConfig{Enabled: true}
+	ts := NewTestBalancer(t, ctx, httpConfig, proxyServerPool)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("Event stream encoded as %s", encoding)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for i := range events {
+		// the backend sends the next event only after this one arrived, a buffered stream would time out here
+		line, err := readLineWithin(reader, 2*time.Second)
+		if err != nil {
+			t.Fatalf("Event %d did not arrive: %v", i, err)
+		}
+		if want := fmt.Sprintf("data: event %d", i); strings.TrimSpace(line) != want {
+			t.Fatalf("Got %q, want %q", line, want)
+		}
+		reader.ReadString('\n')
+
+		if available := proxyServerPool.GetAvailableCapacity(); available != 0 {
+			t.Fatalf("Stream released its capacity, %d available", available)
+		}
+		next <- struct{}{}
+	}
+}
+
+func readLineWithin(reader *bufio.Reader, timeout time.Duration) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := reader.ReadString('\n')
+		done <- result{line, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.line, r.err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("no line within %s", timeout)
+	}
+}
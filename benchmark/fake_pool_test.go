@@ -0,0 +1,139 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/javor454/balancer/auth"
+	"github.com/javor454/balancer/server"
+	"github.com/javor454/balancer/server/servertest"
+)
+
+// TestProxyHandlerPoolErrors asserts the proxy handler answers every outcome of the pool with the matching status,
+// the fake pool makes the refusals deterministic
+func TestProxyHandlerPoolErrors(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name          string
+		pool          func() *servertest.FakeServerPool
+		wantStatus    int
+		wantBalancer  string
+		wantRequests  uint64
+		wantAvailable int
+	}{
+		{
+			name:          "served",
+			pool:          func() *servertest.FakeServerPool { return servertest.NewFakeServerPool(backend, 1) },
+			wantStatus:    http.StatusOK,
+			wantRequests:  1,
+			wantAvailable: 1,
+		},
+		{
+			name:         "no capacity",
+			pool:         func() *servertest.FakeServerPool { return servertest.NewFakeServerPool(backend, 0) },
+			wantStatus:   http.StatusServiceUnavailable,
+			wantBalancer: server.BalancerStatusQueueTimeout,
+			wantRequests: 1,
+		},
+		{
+			name: "shutting down",
+			pool: func() *servertest.FakeServerPool {
+				pool := servertest.NewFakeServerPool(backend, 1)
+				pool.BeginShutdown()
+				return pool
+			},
+			wantStatus:    http.StatusServiceUnavailable,
+			wantBalancer:  server.BalancerStatusShuttingDown,
+			wantRequests:  1,
+			wantAvailable: 1,
+		},
+		{
+			name: "no backend",
+			pool: func() *servertest.FakeServerPool {
+				pool := servertest.NewFakeServerPool(backend, 1)
+				pool.NextServerErr = errors.New("no healthy backend")
+				return pool
+			},
+			wantStatus:    http.StatusServiceUnavailable,
+			wantRequests:  1,
+			wantAvailable: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pool := tt.pool()
+			handler := NewTestBalancer(t, ctx, NewTestHttpConfig([]string{"/data"}, []string{"/data"}), pool).Config.Handler
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/data", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if got := rec.Header().Get(server.BalancerStatusHeader); got != tt.wantBalancer {
+				t.Errorf("Expected balancer status %q, got %q", tt.wantBalancer, got)
+			}
+			if got := pool.GetRequests(); got != tt.wantRequests {
+				t.Errorf("Expected %d requests to reach the pool, got %d", tt.wantRequests, got)
+			}
+			if got := pool.GetAvailableCapacity(); got != tt.wantAvailable {
+				t.Errorf("Expected capacity %d to be available afterwards, got %d", tt.wantAvailable, got)
+			}
+		})
+	}
+}
+
+// TestHealthChecksPauseEveryPool asserts the admin API pauses and resumes health checks of the named pools as well
+func TestHealthChecksPauseEveryPool(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defaultPool := servertest.NewFakeServerPool(http.NotFoundHandler(), 1)
+	billingPool := servertest.NewFakeServerPool(http.NotFoundHandler(), 1)
+	poolRouter, err := server.NewPoolRouter(defaultPool, map[string]server.ServerPool{"billing": billingPool}, server.DarkLaunchConfig{}, nil, nil, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create pool router: %v", err)
+	}
+	authHandler := auth.NewAuthHandler(ctx)
+	httpConfig := NewTestHttpConfig([]string{"/admin/health-checks"}, []string{"/admin/*"})
+	httpConfig.AdminToken = "secret"
+	httpServer := server.NewHttpServer(httpConfig, nil, defaultPool, poolRouter, nil, server.NewRegisterHandler(authHandler, nil, server.ScopeConfig{}), authHandler)
+
+	for _, paused := range []bool{true, false} {
+		req := httptest.NewRequest(http.MethodPut, "/admin/health-checks", strings.NewReader(`{"paused":`+strconv.FormatBool(paused)+`}`))
+		req.Header.Set(server.AdminTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		httpServer.Handler().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if defaultPool.HealthChecksPaused() != paused || billingPool.HealthChecksPaused() != paused {
+			t.Fatalf("Expected health checks of every pool paused=%v, got default=%v billing=%v", paused, defaultPool.HealthChecksPaused(), billingPool.HealthChecksPaused())
+		}
+	}
+}
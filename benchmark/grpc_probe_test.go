@@ -0,0 +1,141 @@
+package benchmark
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+)
+
+// grpcHealthBackend answers health checks and counts its checks and open connections
+type grpcHealthBackend struct {
+	healthpb.UnimplementedHealthServer
+	checks    atomic.Int64
+	openConns atomic.Int64
+}
+
+func (b *grpcHealthBackend) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	b.checks.Add(1)
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (b *grpcHealthBackend) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (b *grpcHealthBackend) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (b *grpcHealthBackend) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (b *grpcHealthBackend) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		b.openConns.Add(1)
+	case *stats.ConnEnd:
+		b.openConns.Add(-1)
+	}
+}
+
+func startGrpcHealthBackend(t *testing.T, opts ...grpc.ServerOption) (*grpcHealthBackend, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	backend := &grpcHealthBackend{}
+	srv := grpc.NewServer(append(opts, grpc.StatsHandler(backend))...)
+	healthpb.RegisterHealthServer(srv, backend)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	return backend, listener.Addr().String()
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestGrpcProbeReleasesConnections asserts the connection kept for a backend is closed once it is released
+func TestGrpcProbeReleasesConnections(t *testing.T) {
+	backend, addr := startGrpcHealthBackend(t)
+
+	probe, err := server.NewHealthProbe(server.HealthProbeGrpc, http.DefaultClient, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create health probe: %v", err)
+	}
+	target := &url.URL{Scheme: "http", Host: addr}
+	if err := probe.Check(context.Background(), target); err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	waitFor(t, func() bool { return backend.openConns.Load() == 1 }, "Probe holds no connection")
+
+	probe.Release(target)
+	waitFor(t, func() bool { return backend.openConns.Load() == 0 }, "Released connection still open")
+}
+
+// TestGrpcProbeUpstreamTLS asserts https backends are probed with the upstream TLS settings of their pool and that the
+// pool closes the probe connections on shutdown
+func TestGrpcProbeUpstreamTLS(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	// the test server certificate is valid for 127.0.0.1
+	certSource := httptest.NewTLSServer(http.NotFoundHandler())
+	certSource.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certSource.Certificate().Raw}), 0o600); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+	backend, addr := startGrpcHealthBackend(t, grpc.Creds(credentials.NewServerTLSFromCert(&certSource.TLS.Certificates[0])))
+
+	probe, err := server.NewHealthProbe(server.HealthProbeGrpc, http.DefaultClient, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create health probe: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{
+		URLs:                []string{"https://" + addr},
+		HealthCheckInterval: 20 * time.Millisecond,
+		HealthProbe:         probe,
+		UpstreamTLS:         server.UpstreamTLSConfig{CAFile: caFile},
+	})
+
+	waitFor(t, func() bool { return backend.checks.Load() > 0 }, "No health check passed the TLS handshake")
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	if err := proxyServerPool.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Failed to shut down pool: %v", err)
+	}
+	waitFor(t, func() bool { return backend.openConns.Load() == 0 }, "Probe connection still open after shutdown")
+}
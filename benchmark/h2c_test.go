@@ -0,0 +1,65 @@
+package benchmark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestSanitizedHeadersKeepTETrailers asserts TE is stripped as a hop-by-hop header unless it asks for trailers as gRPC does
+func TestSanitizedHeadersKeepTETrailers(t *testing.T) {
+	tests := []struct {
+		name   string
+		te     []string
+		wantTE string
+	}{
+		{name: "trailers", te: []string{"trailers"}, wantTE: "trailers"},
+		{name: "trailers among others", te: []string{"gzip, trailers"}, wantTE: "trailers"},
+		{name: "without trailers", te: []string{"gzip"}, wantTE: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTE string
+			handler := server.WithSanitizedHeaders(nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotTE = r.Header.Get("Te")
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/grpc.Service/Method", nil)
+			req.Header["Te"] = tt.te
+			req.Header.Set("Connection", "te")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if gotTE != tt.wantTE {
+				t.Errorf("Expected TE %q, got %q", tt.wantTE, gotTE)
+			}
+		})
+	}
+}
+
+// TestH2CRejectsUpstreamTLS asserts h2c backends cannot be combined with options needing TLS to the backend
+func TestH2CRejectsUpstreamTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(config *server.HttpConfig)
+		wantErr bool
+	}{
+		{name: "h2c alone", modify: func(config *server.HttpConfig) {}},
+		{name: "upstream TLS", modify: func(config *server.HttpConfig) { config.UpstreamTLS.CAFile = "ca.pem" }, wantErr: true},
+		{name: "mTLS backend auth", modify: func(config *server.HttpConfig) { config.BackendAuth.Type = server.BackendAuthMTLS }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := server.NewDefaultHttpConfig()
+			config.BackendProtocol = server.BackendProtocolH2C
+			tt.modify(config)
+
+			if err := config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
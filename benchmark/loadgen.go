@@ -0,0 +1,261 @@
+package benchmark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadGenConfig configures synthetic load against a running balancer
+type LoadGenConfig struct {
+	TargetURL    string
+	Path         string
+	Method       string
+	RPS          int // 0 sends as fast as the workers allow
+	Concurrency  int
+	Duration     time.Duration
+	PayloadSizes []int         // request body sizes in bytes, each request picks one at random, none sends empty bodies
+	Clients      int           // number of registered clients requests are spread across
+	ClientChurn  time.Duration // how often one client is replaced by a newly registered one, 0 disables churn
+	Timeout      time.Duration
+}
+
+// LoadGenReport summarizes a load generator run
+type LoadGenReport struct {
+	Requests    int
+	Errors      int
+	StatusCodes map[int]int
+	Elapsed     time.Duration
+	P50         time.Duration
+	P95         time.Duration
+	P99         time.Duration
+	Max         time.Duration
+}
+
+// RunLoadGen registers clients and sends requests to the balancer until the duration elapses or ctx is cancelled
+func RunLoadGen(ctx context.Context, config LoadGenConfig) (*LoadGenReport, error) {
+	client := &http.Client{Timeout: config.Timeout}
+
+	clients := newClientSet(client, config.TargetURL)
+	for range max(config.Clients, 1) {
+		if err := clients.add(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	if config.ClientChurn > 0 {
+		go clients.churn(ctx, config.ClientChurn)
+	}
+
+	payload := bytes.Repeat([]byte("x"), slices.Max(append([]int{0}, config.PayloadSizes...)))
+	tokens := rateLimiter(ctx, config.RPS)
+
+	var (
+		mu          sync.Mutex
+		latencies   []time.Duration
+		statusCodes = make(map[int]int)
+		errCount    atomic.Int64
+		wg          sync.WaitGroup
+	)
+
+	start := time.Now()
+	for range max(config.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				if tokens != nil {
+					if _, ok := <-tokens; !ok {
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				body := payload[:0]
+				if len(config.PayloadSizes) > 0 {
+					body = payload[:config.PayloadSizes[rand.IntN(len(config.PayloadSizes))]]
+				}
+				req, err := http.NewRequestWithContext(ctx, config.Method, config.TargetURL+config.Path, bytes.NewReader(body))
+				if err != nil {
+					errCount.Add(1)
+					continue
+				}
+				req.Header.Set("Authorization", clients.next())
+
+				requestStart := time.Now()
+				resp, err := client.Do(req)
+				if err != nil {
+					if ctx.Err() == nil {
+						errCount.Add(1)
+					}
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				latency := time.Since(requestStart)
+
+				mu.Lock()
+				latencies = append(latencies, latency)
+				statusCodes[resp.StatusCode]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := &LoadGenReport{
+		Requests:    len(latencies) + int(errCount.Load()),
+		Errors:      int(errCount.Load()),
+		StatusCodes: statusCodes,
+		Elapsed:     time.Since(start),
+	}
+
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		report.P50 = percentile(latencies, 0.50)
+		report.P95 = percentile(latencies, 0.95)
+		report.P99 = percentile(latencies, 0.99)
+		report.Max = latencies[len(latencies)-1]
+	}
+
+	return report, nil
+}
+
+// String formats the report for terminal output
+func (r *LoadGenReport) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Requests: %d in %s (%.1f req/s)\n", r.Requests, r.Elapsed.Round(time.Millisecond), float64(r.Requests)/r.Elapsed.Seconds())
+	fmt.Fprintf(&sb, "Transport errors: %d\n", r.Errors)
+	fmt.Fprintf(&sb, "Latency: p50=%s p95=%s p99=%s max=%s\n", r.P50, r.P95, r.P99, r.Max)
+
+	codes := make([]int, 0, len(r.StatusCodes))
+	for code := range r.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&sb, "Status %d: %d\n", code, r.StatusCodes[code])
+	}
+
+	return sb.String()
+}
+
+// rateLimiter returns a channel emitting rps tokens per second, nil if unlimited
+func rateLimiter(ctx context.Context, rps int) <-chan struct{} {
+	if rps <= 0 {
+		return nil
+	}
+
+	tokens := make(chan struct{})
+	go func() {
+		defer close(tokens)
+
+		ticker := time.NewTicker(time.Second / time.Duration(rps))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return tokens
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[min(int(float64(len(sorted))*p), len(sorted)-1)]
+}
+
+// clientSet holds registered client names used as Authorization header, rotated round-robin
+type clientSet struct {
+	httpClient *http.Client
+	targetURL  string
+	mu         sync.Mutex
+	names      []string
+	registered int
+	index      atomic.Uint64
+}
+
+func newClientSet(httpClient *http.Client, targetURL string) *clientSet {
+	return &clientSet{httpClient: httpClient, targetURL: targetURL}
+}
+
+func (c *clientSet) add(ctx context.Context) error {
+	c.mu.Lock()
+	c.registered++
+	name := fmt.Sprintf("loadgen-%d", c.registered)
+	c.mu.Unlock()
+
+	body := fmt.Sprintf(`{"name": %q, "weight": 1}`, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.targetURL+"/register", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error registering client %s: %w", name, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("error registering client %s: unexpected status %d", name, resp.StatusCode)
+	}
+
+	c.mu.Lock()
+	c.names = append(c.names, name)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// churn periodically registers a new client and drops the oldest one
+func (c *clientSet) churn(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.add(ctx); err != nil {
+				continue
+			}
+			c.mu.Lock()
+			c.names = c.names[1:]
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *clientSet) next() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.names[c.index.Add(1)%uint64(len(c.names))]
+}
@@ -0,0 +1,64 @@
+package benchmark
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// BenchmarkMiddlewareChain measures per-request overhead of the middleware chain without any proxying
+func BenchmarkMiddlewareChain(b *testing.B) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		name       string
+		middleware server.Middleware
+	}{
+		{"PanicRecovery", server.WithPanicRecovery()},
+		{"WhitelistedPaths", server.WithWhitelistedPaths([]string{"/dummy"})},
+		{"SanitizedHeaders", server.WithSanitizedHeaders(nil, []string{"X-Denied"})},
+		{"Logging", server.WithLogging(1, false)},
+		{"LoggingSampledOut", server.WithLogging(0, false)},
+		{"FullChainSampledOut", server.Chain(
+			server.WithPanicRecovery(),
+			server.WithSanitizedHeaders(nil, nil),
+			server.WithLogging(0, false),
+			server.WithWhitelistedPaths([]string{"/dummy"}),
+		)},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			h := c.middleware(handler)
+			req := httptest.NewRequest(http.MethodGet, "/dummy", nil)
+			w := &discardResponseWriter{header: make(http.Header)}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				h.ServeHTTP(w, req)
+			}
+		})
+	}
+}
+
+// discardResponseWriter avoids httptest.ResponseRecorder allocations skewing the results
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
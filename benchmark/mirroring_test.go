@@ -0,0 +1,71 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestMirrorsWaitedForOnShutdown asserts shutdown waits for mirrored requests in flight and cancels those outlasting
+// the shutdown timeout
+func TestMirrorsWaitedForOnShutdown(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{}, 1)
+	cancelled := make(chan struct{}, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+		cancelled <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	backends, urls := NewTestBackendPool(1, 0)
+	defer CleanupBackends(backends)
+
+	const shutdownTimeout = 200 * time.Millisecond
+	httpConfig := NewTestHttpConfig([]string{"/data"}, []string{"/data"})
+	httpConfig.ShutdownTimeout = shutdownTimeout
+	httpConfig.Mirror = server.MirrorConfig{URL: shadow.URL, Percent: 100}
+	ts := NewTestBalancer(t, ctx, httpConfig, NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: urls}))
+
+	mirrored := server.MirroredRequests().Mirrored
+	resp, err := http.Get(ts.URL + "/data")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Request was not mirrored")
+	}
+	if got := server.MirroredRequests().Mirrored - mirrored; got != 1 {
+		t.Errorf("Expected 1 mirrored request, got %d", got)
+	}
+
+	start := time.Now()
+	if err := ts.HttpServer.GracefulShutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < shutdownTimeout {
+		t.Errorf("Expected shutdown to wait for the mirrored request, it returned after %s", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Mirrored request outlasting shutdown was not cancelled")
+	}
+}
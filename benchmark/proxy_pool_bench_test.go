@@ -0,0 +1,49 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// BenchmarkNextServer measures backend selection on the data path, excluding the proxying itself
+func BenchmarkNextServer(b *testing.B) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, backendCount := range []int{3, 30} {
+		backends, urls := NewTestBackendPool(backendCount, 0)
+		defer CleanupBackends(backends)
+
+		proxyServerPool := NewTestProxyServerPool(b, ctx, server.ProxyServerPoolOptions{URLs: urls, MaxCapacity: 1000})
+
+		b.Run(fmt.Sprintf("Backends-%d", backendCount), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/dummy", nil)
+
+				for pb.Next() {
+					lease, err := proxyServerPool.NextServer(req)
+					if err != nil {
+						b.Errorf("Failed to select server: %v", err)
+						return
+					}
+					lease.Release()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestRequestIDForwarded asserts backends receive the request ID clients see on the response, an ID sent by the peer
+// is kept only if the peer is a trusted proxy
+func TestRequestIDForwarded(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		sentID         string
+		wantSentID     bool
+	}{
+		{name: "untrusted peer without ID"},
+		{name: "untrusted peer with ID", sentID: "client-chosen"},
+		{name: "trusted proxy with ID", trustedProxies: []string{"127.0.0.0/8", "::1/128"}, sentID: "proxy-chosen", wantSentID: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var receivedID atomic.Value
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/health" {
+					receivedID.Store(r.Header.Get(server.RequestIDHeader))
+				}
+			}))
+			defer backend.Close()
+
+			proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: []string{backend.URL}})
+
+			httpConfig := NewTestHttpConfig([]string{"/data"}, []string{"/data"})
+			httpConfig.TrustedProxies = tt.trustedProxies
+			ts := NewTestBalancer(t, ctx, httpConfig, proxyServerPool)
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/data", nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tt.sentID != "" {
+				req.Header.Set(server.RequestIDHeader, tt.sentID)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			responseID := resp.Header.Get(server.RequestIDHeader)
+			got, _ := receivedID.Load().(string)
+			if got == "" {
+				t.Fatalf("Backend received no request ID")
+			}
+			if got != responseID {
+				t.Fatalf("Backend received request ID %q, response carries %q", got, responseID)
+			}
+			if (got == tt.sentID) != tt.wantSentID {
+				t.Fatalf("Backend received request ID %q for sent ID %q, want it kept: %v", got, tt.sentID, tt.wantSentID)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestRequestSigning asserts backends accept signed requests once, also while they only know the previous key of a rotation
+func TestRequestSigning(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	oldKey := server.SigningKey{ID: "old", Secret: "old-secret"}
+	newKey := server.SigningKey{ID: "new", Secret: "new-secret"}
+
+	tests := []struct {
+		name        string
+		signing     server.RequestSigningConfig
+		backendKeys []server.SigningKey
+		wantErr     error
+	}{
+		{name: "active key", signing: server.RequestSigningConfig{Keys: []server.SigningKey{oldKey}}, backendKeys: []server.SigningKey{oldKey}},
+		{name: "backend knows only previous key", signing: server.RequestSigningConfig{ActiveKeyID: "new", PreviousKeyID: "old", Keys: []server.SigningKey{oldKey, newKey}}, backendKeys: []server.SigningKey{oldKey}},
+		{name: "backend knows only new key", signing: server.RequestSigningConfig{ActiveKeyID: "new", PreviousKeyID: "old", Keys: []server.SigningKey{oldKey, newKey}}, backendKeys: []server.SigningKey{newKey}},
+		{name: "rotated without previous key", signing: server.RequestSigningConfig{ActiveKeyID: "new", Keys: []server.SigningKey{oldKey, newKey}}, backendKeys: []server.SigningKey{oldKey}, wantErr: server.ErrUnknownSigningKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			verifier := server.NewSignatureVerifier(tt.backendKeys, time.Minute)
+			var mu sync.Mutex
+			var verifyErr, replayErr error
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/health" {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				verifyErr = verifier.Verify(r)
+				// the same request sent again by someone who captured it
+				replay := r.Clone(r.Context())
+				replay.Body = io.NopCloser(strings.NewReader("payload"))
+				replayErr = verifier.Verify(replay)
+			}))
+			defer backend.Close()
+
+			requestSigner, err := server.NewRequestSigner(tt.signing)
+			if err != nil {
+				t.Fatalf("Failed to create request signer: %v", err)
+			}
+			proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{
+				URLs:          []string{backend.URL},
+				RequestSigner: requestSigner,
+			})
+			ts := NewTestBalancer(t, ctx, NewTestHttpConfig([]string{"/data"}, []string{"/data"}), proxyServerPool)
+
+			resp, err := http.Post(ts.URL+"/data", "text/plain", strings.NewReader("payload"))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !errors.Is(verifyErr, tt.wantErr) {
+				t.Fatalf("Expected verification error %v, got %v", tt.wantErr, verifyErr)
+			}
+			if tt.wantErr == nil && !errors.Is(replayErr, server.ErrReplayedSignature) {
+				t.Fatalf("Expected replayed request to be refused, got %v", replayErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestOversizedResponseLogOnly asserts responses exceeding the size limit reach clients intact when validation only logs,
+// whether the backend sends their length up front or streams them
+func TestOversizedResponseLogOnly(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	payload := strings.Repeat("0123456789", 1000)
+
+	for _, streamed := range []bool{false, true} {
+		t.Run("streamed="+strconv.FormatBool(streamed), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/health" {
+					return
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				if !streamed {
+					w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+					w.Write([]byte(payload))
+					return
+				}
+				// flushing before the end leaves the length unknown, the response is sent chunked
+				for i := 0; i < len(payload); i += 1000 {
+					w.Write([]byte(payload[i : i+1000]))
+					w.(http.Flusher).Flush()
+				}
+			}))
+			defer backend.Close()
+
+			validation := server.ResponseValidationConfig{MaxBodySize: 1024}
+			proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{
+				URLs:               []string{backend.URL},
+				ResponseValidation: validation,
+			})
+			ts := NewTestBalancer(t, ctx, NewTestHttpConfig([]string{"/data"}, []string{"/data"}), proxyServerPool)
+
+			resp, err := http.Get(ts.URL + "/data")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if string(body) != payload {
+				t.Fatalf("Received %d bytes, want all %d", len(body), len(payload))
+			}
+			if violations := proxyServerPool.GetResponseViolations(); violations != 1 {
+				t.Fatalf("Counted %d violations, want 1", violations)
+			}
+		})
+	}
+}
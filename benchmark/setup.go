@@ -1,15 +1,23 @@
 package benchmark
 
 import (
+	"cmp"
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
+	"testing"
 	"time"
+
+	"github.com/javor454/balancer/auth"
+	"github.com/javor454/balancer/server"
 )
 
 // TestBackend represents a simulated backend server
 type TestBackend struct {
-	server  *httptest.Server
-	latency time.Duration
+	server    *httptest.Server
+	latency   time.Duration
+	unhealthy atomic.Bool
 }
 
 // NewTestBackendPool creates a pool of test backends
@@ -23,6 +31,11 @@ func NewTestBackendPool(count int, latency time.Duration) ([]*TestBackend, []str
 		}
 
 		backend.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" && backend.unhealthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
 			time.Sleep(backend.latency) // Simulate work
 
 			w.WriteHeader(http.StatusOK)
@@ -36,9 +49,81 @@ func NewTestBackendPool(count int, latency time.Duration) ([]*TestBackend, []str
 	return backends, urls
 }
 
+// SetHealthy makes the backend pass or fail its health checks
+func (b *TestBackend) SetHealthy(healthy bool) {
+	b.unhealthy.Store(!healthy)
+}
+
 // CleanupBackends closes all test backend servers
 func CleanupBackends(backends []*TestBackend) {
 	for _, b := range backends {
 		b.server.Close()
 	}
 }
+
+// NewTestHttpConfig creates a quiet server config listening on an ephemeral port with the given paths open
+func NewTestHttpConfig(whitelistedPaths []string, authBlacklistedPaths []string) *server.HttpConfig {
+	config := server.NewDefaultHttpConfig()
+	config.Port = 0
+	config.ShutdownTimeout = time.Second
+	config.WhitelistedPaths = whitelistedPaths
+	config.AuthBlacklistedPaths = authBlacklistedPaths
+	config.LogSampleRate = 0
+	config.SessionExpiryWarning = 0
+
+	return config
+}
+
+// NewTestProxyServerPool creates a pool probing its backends over HTTP. Options left zero get test defaults: the name
+// "default", a health check every minute and capacity for one request acquired within a second.
+func NewTestProxyServerPool(tb testing.TB, ctx context.Context, options server.ProxyServerPoolOptions) *server.ProxyServerPool {
+	tb.Helper()
+
+	if options.HealthProbe == nil {
+		healthProbe, err := server.NewHealthProbe(server.HealthProbeHttp, http.DefaultClient, time.Second)
+		if err != nil {
+			tb.Fatalf("Failed to create health probe: %v", err)
+		}
+		options.HealthProbe = healthProbe
+	}
+	options.Name = cmp.Or(options.Name, "default")
+	options.HealthCheckInterval = cmp.Or(options.HealthCheckInterval, time.Minute)
+	options.MaxCapacity = cmp.Or(options.MaxCapacity, 1)
+	options.AcquireCapacityTimeout = cmp.Or(options.AcquireCapacityTimeout, time.Second)
+
+	proxyServerPool, err := server.NewProxyServerPool(ctx, options)
+	if err != nil {
+		tb.Fatalf("Failed to create proxy server pool: %v", err)
+	}
+
+	return proxyServerPool
+}
+
+// TestBalancer is a balancer in front of a single pool served by an httptest server, which is closed on cleanup
+type TestBalancer struct {
+	*httptest.Server
+	HttpServer  *server.HttpServer
+	AuthHandler *auth.AuthHandler
+}
+
+// NewTestBalancer starts a balancer configured by config in front of pool, wired up the way main does it without
+// named pools or experiments
+func NewTestBalancer(tb testing.TB, ctx context.Context, config *server.HttpConfig, pool server.ServerPool) *TestBalancer {
+	tb.Helper()
+
+	poolRouter, err := server.NewPoolRouter(pool, nil, server.DarkLaunchConfig{}, nil, nil, nil, nil, false)
+	if err != nil {
+		tb.Fatalf("Failed to create pool router: %v", err)
+	}
+	trustedProxies, err := server.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		tb.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	authHandler := auth.NewAuthHandler(ctx)
+	httpServer := server.NewHttpServer(config, trustedProxies, pool, poolRouter, nil, server.NewRegisterHandler(authHandler, nil, server.ScopeConfig{}), authHandler)
+	ts := httptest.NewServer(httpServer.Handler())
+	tb.Cleanup(ts.Close)
+
+	return &TestBalancer{Server: ts, HttpServer: httpServer, AuthHandler: authHandler}
+}
@@ -0,0 +1,60 @@
+package benchmark
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/auth"
+)
+
+// snapshotV1 was written before clients had JSON tags
+const snapshotV1 = `{
+	"version": 1,
+	"clients": [{
+		"Name": "legacy",
+		"Weight": 4,
+		"RegisteredAt": "2026-01-02T03:04:05Z",
+		"History": [{"status": "registered", "at": "2026-01-02T03:04:05Z"}],
+		"Scopes": ["reports"]
+	}],
+	"tombstones": [{"name": "gone", "reason": "explicit", "evictedAt": "2026-01-02T04:00:00Z", "history": []}]
+}`
+
+// TestSnapshotMigratesV1 asserts a version 1 snapshot decodes into the current clients
+func TestSnapshotMigratesV1(t *testing.T) {
+	snapshot, err := auth.JSONSnapshotCodec{}.Decode([]byte(snapshotV1))
+	if err != nil {
+		t.Fatalf("Failed to decode v1 snapshot: %v", err)
+	}
+
+	if len(snapshot.Clients) != 1 {
+		t.Fatalf("Expected 1 client, got %d", len(snapshot.Clients))
+	}
+	client := snapshot.Clients[0]
+	registeredAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if client.Name != "legacy" || client.Weight != 4 || !client.RegisteredAt.Equal(registeredAt) {
+		t.Fatalf("Client fields lost in migration: %+v", client)
+	}
+	if len(client.History) != 1 || client.History[0].Status != auth.ClientRegistered {
+		t.Fatalf("Client history lost in migration: %+v", client.History)
+	}
+	if !slices.Equal(client.Scopes, []string{"reports"}) {
+		t.Fatalf("Client scopes lost in migration: %v", client.Scopes)
+	}
+	if len(snapshot.Tombstones) != 1 || snapshot.Tombstones[0].Name != "gone" {
+		t.Fatalf("Tombstones lost in migration: %+v", snapshot.Tombstones)
+	}
+
+	encoded, err := auth.JSONSnapshotCodec{}.Encode(snapshot)
+	if err != nil {
+		t.Fatalf("Failed to encode snapshot: %v", err)
+	}
+	reencoded, err := auth.JSONSnapshotCodec{}.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode current snapshot: %v", err)
+	}
+	if reencoded.Version != auth.SnapshotVersion || reencoded.Clients[0].Name != "legacy" {
+		t.Fatalf("Snapshot did not round trip: %+v", reencoded)
+	}
+}
@@ -0,0 +1,187 @@
+//go:build soak
+
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/auth"
+	"github.com/javor454/balancer/server"
+)
+
+// TestSoak runs client churn, flapping backends and steady traffic for SOAK_DURATION (default 1h)
+// while asserting goroutine count and heap stay bounded. The baseline is taken once churned clients start to expire,
+// the heap of the last third of the samples must not outgrow the first third. Run with: go test -tags soak -run TestSoak -timeout 0 ./benchmark/
+func TestSoak(t *testing.T) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	duration := time.Hour
+	if v := os.Getenv("SOAK_DURATION"); v != "" {
+		var err error
+		if duration, err = time.ParseDuration(v); err != nil {
+			t.Fatalf("Invalid SOAK_DURATION: %v", err)
+		}
+	}
+
+	const (
+		backendCount        = 5
+		healthCheckInterval = 100 * time.Millisecond
+		sampleInterval      = 10 * time.Second
+		goroutineSlack      = 50
+		heapGrowthFactor    = 2
+		heapTrendFactor     = 1.25
+		trafficClient       = "soak-traffic"
+	)
+	// registrations and evictions of churned clients balance once the first ones time out and are cleaned up
+	steadyState := auth.SessionTimeout + time.Minute
+	if duration < steadyState+3*sampleInterval {
+		t.Fatalf("SOAK_DURATION %s is too short, the heap reaches a steady state after %s", duration, steadyState)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backends, urls := NewTestBackendPool(backendCount, time.Millisecond)
+	defer CleanupBackends(backends)
+
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{
+		URLs:                urls,
+		HealthCheckInterval: healthCheckInterval,
+		MaxCapacity:         20,
+	})
+	ts := NewTestBalancer(t, ctx, NewTestHttpConfig([]string{"/dummy", "/register", "/health"}, []string{"/register", "/health"}), proxyServerPool)
+	authHandler := ts.AuthHandler
+
+	var unauthorized atomic.Int64
+	soakCtx, stopSoak := context.WithTimeout(ctx, duration)
+	defer stopSoak()
+
+	// backends flapping
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-soakCtx.Done():
+				return
+			case <-ticker.C:
+				backends[rand.IntN(len(backends))].SetHealthy(rand.IntN(3) > 0)
+			}
+		}
+	}()
+
+	// the traffic client re-registers before its session expires
+	authHandler.RegisterClient(trafficClient, 1, nil)
+	go func() {
+		ticker := time.NewTicker(auth.SessionTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-soakCtx.Done():
+				return
+			case <-ticker.C:
+				authHandler.RegisterClient(trafficClient, 1, nil)
+			}
+		}
+	}()
+
+	// clients registering, the auth handler evicts them after they time out
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-soakCtx.Done():
+				return
+			case <-ticker.C:
+				authHandler.RegisterClient(fmt.Sprintf("soak-%d", i), rand.IntN(5)+1, nil)
+			}
+		}
+	}()
+
+	// steady traffic
+	for range 10 {
+		go func() {
+			client := &http.Client{Timeout: 5 * time.Second}
+			for soakCtx.Err() == nil {
+				req, _ := http.NewRequestWithContext(soakCtx, http.MethodGet, ts.URL+"/dummy", nil)
+				req.Header.Set("Authorization", trafficClient)
+				resp, err := client.Do(req)
+				if err != nil {
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusUnauthorized {
+					unauthorized.Add(1)
+				}
+			}
+		}()
+	}
+
+	time.Sleep(steadyState)
+	baselineGoroutines, baselineHeap := sampleRuntime()
+	t.Logf("Baseline at steady state: goroutines=%d heap=%d", baselineGoroutines, baselineHeap)
+
+	var heaps []uint64
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-soakCtx.Done():
+			if n := unauthorized.Load(); n > 0 {
+				t.Fatalf("Traffic client lost its session, %d requests unauthorized", n)
+			}
+			third := len(heaps) / 3
+			if early, late := median(heaps[:third]), median(heaps[len(heaps)-third:]); float64(late) > float64(early)*heapTrendFactor {
+				t.Fatalf("Heap keeps growing: median %d bytes in the last third of the samples, %d in the first", late, early)
+			}
+			return
+		case <-ticker.C:
+			goroutines, heap := sampleRuntime()
+			heaps = append(heaps, heap)
+			t.Logf("Sample: goroutines=%d heap=%d", goroutines, heap)
+
+			if goroutines > baselineGoroutines+goroutineSlack {
+				t.Fatalf("Goroutine leak: %d goroutines, baseline %d", goroutines, baselineGoroutines)
+			}
+			if heap > baselineHeap*heapGrowthFactor {
+				t.Fatalf("Heap grew unbounded: %d bytes, baseline %d", heap, baselineHeap)
+			}
+		}
+	}
+}
+
+// sampleRuntime returns goroutine count and live heap after a forced GC
+func sampleRuntime() (int, uint64) {
+	runtime.GC()
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	return runtime.NumGoroutine(), stats.HeapAlloc
+}
+
+// median returns the middle of the samples, it smooths out garbage collections
+func median(samples []uint64) uint64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(samples))
+
+	return sorted[len(sorted)/2]
+}
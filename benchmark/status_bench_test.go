@@ -0,0 +1,51 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// BenchmarkStatusEndpoints measures polling of status endpoints, run in parallel to mimic heavy polling load
+func BenchmarkStatusEndpoints(b *testing.B) {
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backends, urls := NewTestBackendPool(1, 0)
+	defer CleanupBackends(backends)
+
+	proxyServerPool := NewTestProxyServerPool(b, ctx, server.ProxyServerPoolOptions{URLs: urls, MaxCapacity: 100})
+	balancer := NewTestBalancer(b, ctx, NewTestHttpConfig([]string{"/health", "/register"}, []string{"/health", "/register"}), proxyServerPool)
+	for _, name := range []string{"client1", "client2", "client3"} {
+		balancer.AuthHandler.RegisterClient(name, 1, nil)
+	}
+	handler := balancer.Config.Handler
+
+	for _, path := range []string{"/health", "/register"} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				w := &discardResponseWriter{header: make(http.Header)}
+
+				for pb.Next() {
+					handler.ServeHTTP(w, req)
+				}
+			})
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+		})
+	}
+}
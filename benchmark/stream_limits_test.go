@@ -0,0 +1,64 @@
+package benchmark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestStreamLimits asserts event streams beyond the per-client or total limit are refused with 429 while other
+// requests pass, and that closed streams free their slots
+func TestStreamLimits(t *testing.T) {
+	open := make(chan struct{})
+	release := make(chan struct{})
+	handler := server.WithStreamLimits(server.StreamLimitConfig{PerClient: 1, Total: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "text/event-stream" {
+			open <- struct{}{}
+			<-release
+		}
+	}))
+
+	stream := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/ui/events", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Accept", "text/event-stream")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range []string{"10.0.0.1:1000", "10.0.0.2:1000"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream(addr)
+		}()
+		<-open
+	}
+
+	if rec := stream("10.0.0.1:2000"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected second stream of a client to be refused with 429 and Retry-After, got %d", rec.Code)
+	}
+	if rec := stream("10.0.0.3:1000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected stream beyond the total limit to be refused with 429, got %d", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/diagnostics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected requests other than streams to pass, got %d", rec.Code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- stream("10.0.0.1:3000") }()
+	<-open
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Errorf("Expected stream after others closed to pass, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// TestStreamingUpload pushes a multi-GB body through the balancer with streamed request bodies
+// and asserts it was never buffered in memory
+func TestStreamingUpload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping multi-GB upload in short mode")
+	}
+
+	// Suppress logs
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalOutput)
+
+	const (
+		uploadSize    = 2 << 30
+		maxAllocated  = 64 << 20
+		uploadTimeout = 2 * time.Minute
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		received, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(strconv.FormatInt(received, 10)))
+	}))
+	defer backend.Close()
+
+	proxyServerPool := NewTestProxyServerPool(t, ctx, server.ProxyServerPoolOptions{URLs: []string{backend.URL}})
+
+	httpConfig := NewTestHttpConfig([]string{"/upload"}, []string{"/upload"})
+	httpConfig.LogSampleRate = 1
+	httpConfig.StreamRequestBodies = true
+	ts := NewTestBalancer(t, ctx, httpConfig, proxyServerPool)
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/upload", io.LimitReader(zeroReader{}, uploadSize))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	resp, err := (&http.Client{Timeout: uploadTimeout}).Do(req)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != strconv.Itoa(uploadSize) {
+		t.Fatalf("Unexpected response: status %d, body %q", resp.StatusCode, body)
+	}
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > maxAllocated {
+		t.Fatalf("Upload of %d bytes allocated %d bytes, body was buffered", uploadSize, allocated)
+	}
+}
+
+// zeroReader is an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// checkResult is a single line of the self-test report
+type checkResult struct {
+	name string
+	err  error
+}
+
+// runCheck implements the "check" subcommand validating the environment before serving, exits non-zero if any check fails
+func runCheck(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := flags.String("config", "", "path of a JSON or YAML config file overlaying the defaults")
+	checkHealth := flags.Bool("health", false, "also require every backend to pass its health probe")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of network checks")
+	flagOverrides := server.RegisterConfigFlags(flags)
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	httpConfig, err := server.LoadConfig(*configPath, os.Environ(), flagOverrides)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "FAIL  config: %v\n", err)
+		os.Exit(1)
+	}
+
+	results := []checkResult{
+		{"config", httpConfig.Validate()},
+		{"trusted proxies", checkTrustedProxies(httpConfig)},
+		{"backend auth", checkBackendAuth(httpConfig)},
+		{"request signing", checkRequestSigning(httpConfig)},
+		{"routing", checkRouting(httpConfig)},
+		{"admission rules", checkAdmission(httpConfig)},
+		{"backends resolve", checkBackendsResolve(ctx, httpConfig)},
+	}
+	if *checkHealth {
+		results = append(results, checkResult{"backends healthy", checkBackendsHealthy(ctx, httpConfig)})
+	}
+	results = append(results, checkResult{"addresses bindable", checkPortBindable(httpConfig)})
+
+	failed := false
+	for _, result := range results {
+		if result.err != nil {
+			failed = true
+			fmt.Fprintf(os.Stdout, "FAIL  %s: %v\n", result.name, result.err)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "OK    %s\n", result.name)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func checkTrustedProxies(httpConfig *server.HttpConfig) error {
+	_, err := server.ParseTrustedProxies(httpConfig.TrustedProxies)
+	return err
+}
+
+// checkBackendAuth also loads mTLS client certificates, those of the default pool and of every pool with its own
+func checkBackendAuth(httpConfig *server.HttpConfig) error {
+	_, err := server.NewBackendAuth(httpConfig.BackendAuth)
+	errs := []error{err}
+	for _, name := range slices.Sorted(maps.Keys(httpConfig.BackendPools)) {
+		if poolAuth := httpConfig.BackendPools[name].BackendAuth; poolAuth != nil {
+			if _, err := server.NewBackendAuth(*poolAuth); err != nil {
+				errs = append(errs, fmt.Errorf("pool %s: %w", name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkRequestSigning(httpConfig *server.HttpConfig) error {
+	_, err := server.NewRequestSigner(httpConfig.RequestSigning)
+	return err
+}
+
+// checkRouting validates experiments and that routing rules reference configured pools
+func checkRouting(httpConfig *server.HttpConfig) error {
+	experiments, err := server.NewExperiments(httpConfig.Experiments)
+	if err != nil {
+		return err
+	}
+
+	pools := make(map[string]server.ServerPool, len(httpConfig.BackendPools))
+	for name := range httpConfig.BackendPools {
+		pools[name] = nil
+	}
+	_, err = server.NewPoolRouter(nil, pools, httpConfig.DarkLaunch, httpConfig.RouteRules, httpConfig.ContentRoutes, experiments, httpConfig.TrafficSplits, httpConfig.PinSessions)
+
+	return err
+}
+
+func checkAdmission(httpConfig *server.HttpConfig) error {
+	_, err := server.NewAdmission(httpConfig.AdmissionRules, nil)
+	return err
+}
+
+func checkBackendsResolve(ctx context.Context, httpConfig *server.HttpConfig) error {
+	var errs []error
+	for _, target := range backendURLs(httpConfig) {
+		u, err := url.Parse(target)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkBackendsHealthy(ctx context.Context, httpConfig *server.HttpConfig) error {
+	errs := []error{checkPoolHealthy(ctx, httpConfig, httpConfig.BackendAuth, httpConfig.ProxyServers)}
+	for _, name := range slices.Sorted(maps.Keys(httpConfig.BackendPools)) {
+		poolConfig := httpConfig.BackendPools[name]
+		backendAuth := httpConfig.BackendAuth
+		if poolConfig.BackendAuth != nil {
+			backendAuth = *poolConfig.BackendAuth
+		}
+		errs = append(errs, checkPoolHealthy(ctx, httpConfig, backendAuth, poolConfig.Servers))
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkPoolHealthy probes the backends of a pool with its credentials
+func checkPoolHealthy(ctx context.Context, httpConfig *server.HttpConfig, backendAuthConfig server.BackendAuthConfig, urls []string) error {
+	backendAuth, err := server.NewBackendAuth(backendAuthConfig)
+	if err != nil {
+		return err
+	}
+
+	httpClient := &http.Client{
+		Timeout:   httpConfig.RequestTimeout,
+		Transport: backendAuth.Transport(),
+	}
+	healthProbe, err := server.NewHealthProbe(httpConfig.HealthCheckProbe, httpClient, httpConfig.RequestTimeout)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, target := range urls {
+		u, err := url.Parse(target)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := healthProbe.Check(ctx, u); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkPortBindable(httpConfig *server.HttpConfig) error {
+	addresses, err := server.ResolveBindAddresses(httpConfig.BindAddresses, httpConfig.Port)
+	if err != nil {
+		return err
+	}
+	if httpConfig.AdminPort != 0 {
+		adminAddresses, err := server.ResolveBindAddresses(httpConfig.AdminBindAddresses, httpConfig.AdminPort)
+		if err != nil {
+			return fmt.Errorf("admin listener: %w", err)
+		}
+		addresses = append(addresses, adminAddresses...)
+	}
+
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		if err := listener.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backendURLs returns backends of the default pool and all named pools
+func backendURLs(httpConfig *server.HttpConfig) []string {
+	urls := append([]string{}, httpConfig.ProxyServers...)
+	for _, poolConfig := range httpConfig.BackendPools {
+		urls = append(urls, poolConfig.Servers...)
+	}
+
+	return urls
+}
@@ -0,0 +1,54 @@
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// panics counts recovered panics of all tracked goroutines in the process
+var panics atomic.Int64
+
+// Panics returns the number of panics recovered in tracked goroutines
+func Panics() int64 {
+	return panics.Load()
+}
+
+// Group tracks background goroutines so their owner can wait for them to finish on shutdown
+type Group struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in a tracked goroutine, fn is expected to return once its context is cancelled.
+// A panic in fn is recovered and logged with its stack so it does not take the whole process down.
+func (g *Group) Go(fn func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				panics.Add(1)
+				slog.Error("Panic recovered in background goroutine", "error", err, "stack", string(debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// Wait blocks until all tracked goroutines return or ctx is done
+func (g *Group) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/javor454/balancer/benchmark"
+)
+
+// runLoadGen implements the "loadgen" subcommand generating synthetic load against a running balancer
+func runLoadGen(args []string) {
+	flags := flag.NewFlagSet("loadgen", flag.ExitOnError)
+
+	config := benchmark.LoadGenConfig{}
+	flags.StringVar(&config.TargetURL, "target", "http://localhost:8080", "balancer base URL")
+	flags.StringVar(&config.Path, "path", "/dummy", "path requests are sent to")
+	flags.StringVar(&config.Method, "method", http.MethodGet, "HTTP method of generated requests")
+	flags.IntVar(&config.RPS, "rps", 0, "requests per second across all workers, 0 for unlimited")
+	flags.IntVar(&config.Concurrency, "concurrency", 10, "number of concurrent workers")
+	flags.DurationVar(&config.Duration, "duration", 30*time.Second, "how long to generate load")
+	flags.Func("payload-size", "comma-separated request body sizes in bytes, each request picks one at random", func(value string) error {
+		for _, size := range strings.Split(value, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(size))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid payload size %q", size)
+			}
+			config.PayloadSizes = append(config.PayloadSizes, n)
+		}
+		return nil
+	})
+	flags.IntVar(&config.Clients, "clients", 1, "number of clients registered and rotated in the Authorization header")
+	flags.DurationVar(&config.ClientChurn, "client-churn", 0, "interval in which one client is replaced by a new one, 0 disables churn")
+	flags.DurationVar(&config.Timeout, "timeout", 30*time.Second, "per request timeout")
+	flags.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	log.Printf("Generating load against %s%s for %s", config.TargetURL, config.Path, config.Duration)
+	report, err := benchmark.RunLoadGen(ctx, config)
+	if err != nil {
+		log.Fatalf("Load generation failed: %v", err)
+	}
+
+	fmt.Fprint(os.Stdout, report)
+}
@@ -1,46 +1,218 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/javor454/balancer/auth"
 	"github.com/javor454/balancer/server"
 )
 
 func main() {
-	httpConfig := server.NewDefaultHttpConfig()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "loadgen":
+			runLoadGen(os.Args[2:])
+			return
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "migrate-config":
+			runMigrateConfig(os.Args[2:])
+			return
+		}
+	}
+
+	configPath := flag.String("config", "", "path of a JSON or YAML config file overlaying the defaults")
+	flagOverrides := server.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	httpConfig, err := server.LoadConfig(*configPath, os.Environ(), flagOverrides)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := httpConfig.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	if err := server.ApplyRuntimeConfig(httpConfig.Runtime); err != nil {
+		log.Fatalf("Failed to apply runtime config: %v", err)
+	}
+	if err := server.ConfigureLogOutputs(httpConfig.Log, httpConfig.AccessLog, httpConfig.DebugLog); err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+	server.SetVerboseLogging(httpConfig.VerboseLogging)
+	server.SetMaintenance(httpConfig.Maintenance)
+	server.SetReadOnly(httpConfig.ReadOnly)
 
 	shutdownHandler := server.NewShutdownHandler()
 	rootCtx := shutdownHandler.CreateRootCtxWithShutdown()
 
+	backendAuth, err := server.NewBackendAuth(httpConfig.BackendAuth)
+	if err != nil {
+		log.Fatalf("Failed to configure backend auth: %v", err)
+	}
+
+	requestSigner, err := server.NewRequestSigner(httpConfig.RequestSigning)
+	if err != nil {
+		log.Fatalf("Failed to configure request signing: %v", err)
+	}
+
 	httpClient := &http.Client{
-		Timeout: httpConfig.RequestTimeout,
+		Timeout:   httpConfig.RequestTimeout,
+		Transport: backendAuth.Transport(),
 	}
 
-	proxyServerPool, err := server.NewProxyServerPool(rootCtx, httpConfig.ProxyServers, httpConfig.HealthCheckInterval, httpClient, httpConfig.MaxCapacity, httpConfig.AcquireCapacityTimeout)
+	healthProbe, err := server.NewHealthProbe(httpConfig.HealthCheckProbe, httpClient, httpConfig.RequestTimeout)
+	if err != nil {
+		log.Fatalf("Failed to create health probe: %v", err)
+	}
+
+	newProxyServerPool := func(name string, urls []string, pushHeartbeats map[string]server.PushHeartbeatConfig, backendAuth *server.BackendAuth, responseValidation server.ResponseValidationConfig, errorBudget server.ErrorBudgetConfig) (*server.ProxyServerPool, error) {
+		return server.NewProxyServerPool(rootCtx, server.ProxyServerPoolOptions{
+			Name:                   name,
+			URLs:                   urls,
+			PushHeartbeats:         pushHeartbeats,
+			HealthCheckInterval:    httpConfig.HealthCheckInterval,
+			DrainTimeout:           httpConfig.DrainTimeout,
+			HealthProbe:            healthProbe,
+			HealthThresholds:       httpConfig.HealthCheckThresholds,
+			PassiveHealthCheck:     httpConfig.PassiveHealthCheck,
+			BackendAuth:            backendAuth,
+			RequestSigner:          requestSigner,
+			Prewarm:                httpConfig.ConnectionPrewarm,
+			ResponseValidation:     responseValidation,
+			ErrorBudget:            errorBudget,
+			Balancing:              httpConfig.Balancing,
+			MaxCapacity:            httpConfig.MaxCapacity,
+			BackendCapacity:        httpConfig.BackendCapacity,
+			AcquireCapacityTimeout: httpConfig.AcquireCapacityTimeout,
+			MaxQueueDepth:          httpConfig.MaxQueueDepth,
+			AutoTune:               httpConfig.AutoTune,
+			Starvation:             httpConfig.Starvation,
+			UpstreamTLS:            httpConfig.UpstreamTLS,
+			BackendProtocol:        httpConfig.BackendProtocol,
+		})
+	}
+
+	proxyServerPool, err := newProxyServerPool("default", httpConfig.ProxyServers, httpConfig.PushHeartbeats, backendAuth, httpConfig.ResponseValidation, httpConfig.ErrorBudget)
 	if err != nil {
 		log.Fatalf("Failed to create proxy server pool: %v", err)
 	}
 
+	backendPools := make(map[string]server.ServerPool, len(httpConfig.BackendPools))
+	for name, poolConfig := range httpConfig.BackendPools {
+		poolBackendAuth := backendAuth
+		if poolConfig.BackendAuth != nil {
+			if poolBackendAuth, err = server.NewBackendAuth(*poolConfig.BackendAuth); err != nil {
+				log.Fatalf("Failed to configure backend auth of pool %s: %v", name, err)
+			}
+		}
+		if backendPools[name], err = newProxyServerPool(name, poolConfig.Servers, nil, poolBackendAuth, poolConfig.ResponseValidation, poolConfig.ErrorBudget); err != nil {
+			log.Fatalf("Failed to create proxy server pool %s: %v", name, err)
+		}
+	}
+
+	experiments, err := server.NewExperiments(httpConfig.Experiments)
+	if err != nil {
+		log.Fatalf("Failed to configure experiments: %v", err)
+	}
+
+	poolRouter, err := server.NewPoolRouter(proxyServerPool, backendPools, httpConfig.DarkLaunch, httpConfig.RouteRules, httpConfig.ContentRoutes, experiments, httpConfig.TrafficSplits, httpConfig.PinSessions)
+	if err != nil {
+		log.Fatalf("Failed to create pool router: %v", err)
+	}
+
+	server.ListenOperationalSignals(rootCtx, poolRouter.Pools())
+	server.ShareCapacity(rootCtx, httpConfig.CapacityBorrowing, poolRouter.Pools())
+	server.WatchMemory(rootCtx, httpConfig.MemoryWatchdog)
+
 	authHandler := auth.NewAuthHandler(rootCtx)
-	registerHandler := server.NewRegisterHandler(authHandler)
+	clientStore, err := server.NewClientStore(httpConfig.ClientStore, authHandler)
+	if err != nil {
+		log.Fatalf("Failed to open client store: %v", err)
+	}
+	if err := clientStore.Restore(); err != nil {
+		log.Fatalf("Failed to restore clients: %v", err)
+	}
+	clientStore.Run(rootCtx)
+	// no geo database is bundled, rules matching countries need a GeoLookup passed here
+	admission, err := server.NewAdmission(httpConfig.AdmissionRules, nil)
+	if err != nil {
+		log.Fatalf("Failed to configure admission rules: %v", err)
+	}
+	registerHandler := server.NewRegisterHandler(authHandler, admission, httpConfig.Scopes)
 
 
-	httpServer := server.NewHttpServer(httpConfig.Port, httpConfig.ShutdownTimeout, httpConfig.WhitelistedPaths, httpConfig.AuthBlacklistedPaths, proxyServerPool, registerHandler, authHandler)
+	trustedProxies, err := server.ParseTrustedProxies(httpConfig.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	httpServer := server.NewHttpServer(httpConfig, trustedProxies, proxyServerPool, poolRouter, experiments, registerHandler, authHandler)
 	httpServerErrChan := httpServer.Serve()
 
 	var shutdownErr error
+	reason := "signal"
 	select {
 	case err := <-httpServerErrChan:
 		// only one goroutine in this app, why do it so complicated
 		shutdownHandler.SignalShutdown()
 		shutdownErr = err
+		reason = fmt.Sprintf("server error: %v", err)
 	case <-rootCtx.Done():
 		log.Print("Received shutdown signal...")
 	}
 
-	if err := httpServer.GracefulShutdown(); err != nil {
+	pools := poolRouter.Pools()
+	report := server.NewShutdownReport(reason, pools)
+
+	report.Phase("release queued requests", func() error {
+		for _, pool := range pools {
+			pool.BeginShutdown()
+		}
+		return nil
+	})
+
+	if err := report.Phase("drain http servers", httpServer.GracefulShutdown); err != nil {
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	// root context is cancelled by now, wait for background goroutines to notice
+	backgroundCtx, cancel := context.WithTimeout(context.Background(), httpConfig.ShutdownTimeout)
+	defer cancel()
+
+	if err := report.Phase("stop background goroutines", func() error {
+		backgroundErrs := []error{authHandler.Shutdown(backgroundCtx), clientStore.Shutdown(backgroundCtx)}
+		for _, pool := range pools {
+			backgroundErrs = append(backgroundErrs, pool.Shutdown(backgroundCtx))
+		}
+		return errors.Join(backgroundErrs...)
+	}); err != nil {
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	// after the auth handler stopped, no session expires between the snapshot and the exit
+	if err := report.Phase("persist clients", clientStore.Close); err != nil {
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	if err := report.Finish(pools, shutdownErr, httpConfig.ShutdownReportPath); err != nil {
+		log.Printf("Failed to write shutdown report: %v", err)
+	}
+
+	if err := server.CloseLogOutputs(); err != nil {
 		if shutdownErr == nil {
 			shutdownErr = err
 		}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/javor454/balancer/server"
+)
+
+// runMigrateConfig implements the "migrate-config" subcommand rewriting a config file of an older release in the current format
+func runMigrateConfig(args []string) {
+	flags := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path of the config file to migrate")
+	outPath := flags.String("out", "", "path to write the migrated config to, standard output if empty")
+	flags.Parse(args)
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read config: %v\n", err)
+		os.Exit(1)
+	}
+
+	migrated, notes, err := server.MigrateHttpConfig(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate %s: %v\n", *configPath, err)
+		os.Exit(1)
+	}
+
+	for _, note := range notes {
+		fmt.Fprintf(os.Stderr, "NOTE  %s\n", note)
+	}
+	if len(notes) == 0 {
+		fmt.Fprintf(os.Stderr, "%s is already in the current format\n", *configPath)
+	}
+
+	if *outPath == "" {
+		os.Stdout.Write(migrated)
+		return
+	}
+
+	if err := os.WriteFile(*outPath, migrated, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write migrated config: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// AdminTokenHeader carries the operator token required by admin endpoints
+	AdminTokenHeader = "X-Admin-Token"
+
+	// adminTokenCookie keeps the dashboard signed in, browsers cannot set headers on EventSource
+	adminTokenCookie = "balancer_admin"
+)
+
+// adminPublicPaths are admin endpoints of backends, they authenticate with the backend registration secret instead
+var adminPublicPaths = []string{"/admin/backends/register", "/admin/backends/heartbeat"}
+
+// WithAdminAuth requires the admin token on /admin/* in X-Admin-Token, or in the cookie set when the dashboard is opened
+// as /admin/ui?token=. Admin endpoints are refused while no token is configured. The token is never forwarded.
+func WithAdminAuth(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				sent := r.Header.Get(AdminTokenHeader)
+				r.Header.Del(AdminTokenHeader)
+
+				if !strings.HasPrefix(r.URL.Path, "/admin/") || slices.Contains(adminPublicPaths, r.URL.Path) {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				if token == "" {
+					http.Error(w, "Admin endpoints are disabled, no admin token is configured", http.StatusForbidden)
+					return
+				}
+
+				fromQuery := false
+				if sent == "" && r.URL.Path == "/admin/ui" && r.URL.Query().Has("token") {
+					sent, fromQuery = r.URL.Query().Get("token"), true
+				}
+				if cookie, err := r.Cookie(adminTokenCookie); sent == "" && err == nil {
+					sent = cookie.Value
+				}
+
+				if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				if fromQuery {
+					http.SetCookie(w, &http.Cookie{Name: adminTokenCookie, Value: token, Path: "/admin/", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
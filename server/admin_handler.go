@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+type verboseLoggingRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type maintenanceRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type balancingRequest struct {
+	Mode string `json:"mode"`
+}
+
+type healthChecksRequest struct {
+	Paused bool `json:"paused"`
+}
+
+// verboseLoggingHandler enables or disables verbose logging, same as SIGUSR1 but explicit
+func verboseLoggingHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req verboseLoggingRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		SetVerboseLogging(req.Enabled)
+
+		writeJSON(w, http.StatusOK, verboseLoggingRequest{Enabled: VerboseLogging()})
+	}
+}
+
+// healthChecksHandler pauses or resumes health checks of every pool, same as SIGUSR2 but explicit
+func healthChecksHandler(poolRouter *PoolRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req healthChecksRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		for _, pool := range poolRouter.Pools() {
+			pool.SetHealthChecksPaused(req.Paused)
+		}
+
+		writeJSON(w, http.StatusOK, healthChecksRequest{Paused: poolRouter.defaultPool.HealthChecksPaused()})
+	}
+}
+
+// balancingHandler shows the balancing mode on GET and switches every pool to another one on PUT
+func balancingHandler(poolRouter *PoolRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, balancingRequest{Mode: poolRouter.defaultPool.BalancingMode()})
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req balancingRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		for _, pool := range poolRouter.Pools() {
+			if err := pool.SetBalancingMode(req.Mode); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, balancingRequest{Mode: poolRouter.defaultPool.BalancingMode()})
+	}
+}
+
+// maintenanceHandler enables or disables maintenance mode
+func maintenanceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req maintenanceRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		SetMaintenance(req.Enabled)
+
+		writeJSON(w, http.StatusOK, maintenanceRequest{Enabled: Maintenance()})
+	}
+}
+
+// healthHistoryHandler lists recent health check results of a backend identified by its position in the pool
+func healthHistoryHandler(proxyServerPool ServerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid backend id", http.StatusBadRequest)
+			return
+		}
+
+		history, ok := proxyServerPool.HealthHistory(id)
+		if !ok {
+			http.Error(w, "Backend not found", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, history)
+	}
+}
+
+// errorBudgetsHandler lists error budgets of all pools which track one
+func errorBudgetsHandler(poolRouter *PoolRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		budgets := make([]ErrorBudgetStatus, 0)
+		for _, pool := range poolRouter.Pools() {
+			if budget, ok := pool.ErrorBudget(); ok {
+				budgets = append(budgets, budget)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, budgets)
+	}
+}
+
+// fairnessHandler lists fairness statistics of all pools
+func fairnessHandler(poolRouter *PoolRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pools := poolRouter.Pools()
+		stats := make([]FairnessStats, 0, len(pools))
+		for _, pool := range pools {
+			stats = append(stats, pool.Fairness())
+		}
+
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
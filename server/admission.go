@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	AdmissionAllow        = "allow"
+	AdmissionDeny         = "deny"
+	AdmissionDeprioritize = "deprioritize"
+)
+
+// admissionDefaultRule names decisions of requests not matched by any rule
+const admissionDefaultRule = "default"
+
+// GeoLookup resolves the country of a client address, e.g. backed by a GeoIP database
+type GeoLookup interface {
+	Country(addr netip.Addr) (string, error)
+}
+
+// AdmissionRuleConfig matches registration requests by all of its set conditions and applies Action to them
+type AdmissionRuleConfig struct {
+	Name        string
+	Header      string
+	HeaderValue string   // any value of Header matches if empty
+	CIDRs       []string // client address ranges
+	Countries   []string // ISO country codes, requires a GeoLookup
+	Action      string
+}
+
+// Admission decides whether clients may register, rules are evaluated in order and the first matching rule wins
+type Admission struct {
+	rules     []admissionRule
+	geoLookup GeoLookup
+	decisions map[string]*atomic.Uint64 // keyed by rule name and action
+}
+
+type admissionRule struct {
+	AdmissionRuleConfig
+	prefixes  []netip.Prefix
+	countries map[string]struct{}
+}
+
+// NewAdmission validates admission rules, geoLookup may be nil if no rule matches on countries
+func NewAdmission(configs []AdmissionRuleConfig, geoLookup GeoLookup) (*Admission, error) {
+	a := &Admission{
+		rules:     make([]admissionRule, 0, len(configs)),
+		geoLookup: geoLookup,
+		decisions: make(map[string]*atomic.Uint64),
+	}
+
+	for _, config := range configs {
+		if config.Name == "" || config.Name == admissionDefaultRule {
+			return nil, fmt.Errorf("admission rule name %q is not allowed", config.Name)
+		}
+
+		switch config.Action {
+		case AdmissionAllow, AdmissionDeny, AdmissionDeprioritize:
+		default:
+			return nil, fmt.Errorf("admission rule %s has unknown action %q", config.Name, config.Action)
+		}
+
+		if len(config.Countries) > 0 && geoLookup == nil {
+			return nil, fmt.Errorf("admission rule %s matches countries but no geo lookup is configured", config.Name)
+		}
+
+		prefixes, err := ParseTrustedProxies(config.CIDRs)
+		if err != nil {
+			return nil, fmt.Errorf("admission rule %s: %w", config.Name, err)
+		}
+
+		countries := make(map[string]struct{}, len(config.Countries))
+		for _, country := range config.Countries {
+			countries[strings.ToUpper(country)] = struct{}{}
+		}
+
+		a.rules = append(a.rules, admissionRule{AdmissionRuleConfig: config, prefixes: prefixes, countries: countries})
+		a.decisions[config.Name+":"+config.Action] = &atomic.Uint64{}
+	}
+	a.decisions[admissionDefaultRule+":"+AdmissionAllow] = &atomic.Uint64{}
+
+	return a, nil
+}
+
+// Decide returns the action for the registration request
+func (a *Admission) Decide(r *http.Request) string {
+	if a == nil {
+		return AdmissionAllow
+	}
+
+	addr := clientAddr(r)
+	for _, rule := range a.rules {
+		if rule.matches(r, addr, a.geoLookup) {
+			a.decisions[rule.Name+":"+rule.Action].Add(1)
+			logDebugContext(r.Context(), "Admission rule applied", "rule", rule.Name, "action", rule.Action, "address", addr)
+			return rule.Action
+		}
+	}
+	a.decisions[admissionDefaultRule+":"+AdmissionAllow].Add(1)
+
+	return AdmissionAllow
+}
+
+// Decisions returns the number of decisions made per rule and action
+func (a *Admission) Decisions() map[string]uint64 {
+	if a == nil {
+		return nil
+	}
+
+	decisions := make(map[string]uint64, len(a.decisions))
+	for key, count := range a.decisions {
+		decisions[key] = count.Load()
+	}
+
+	return decisions
+}
+
+func (rule *admissionRule) matches(r *http.Request, addr netip.Addr, geoLookup GeoLookup) bool {
+	if rule.Header != "" {
+		values := r.Header.Values(rule.Header)
+		if len(values) == 0 {
+			return false
+		}
+		if rule.HeaderValue != "" && !slices.Contains(values, rule.HeaderValue) {
+			return false
+		}
+	}
+
+	if len(rule.prefixes) > 0 {
+		if !addr.IsValid() || !slices.ContainsFunc(rule.prefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr) }) {
+			return false
+		}
+	}
+
+	if len(rule.countries) > 0 {
+		if !addr.IsValid() {
+			return false
+		}
+		country, err := geoLookup.Country(addr)
+		if err != nil {
+			logDebugContext(r.Context(), "Geo lookup failed", "address", addr, "error", err)
+			return false
+		}
+		if _, ok := rule.countries[strings.ToUpper(country)]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientAddr returns the address of the client, forwarding headers are only present if set by a trusted proxy
+func clientAddr(r *http.Request) netip.Addr {
+	if realIP := r.Header.Get("X-Real-Ip"); realIP != "" {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(realIP)); err == nil {
+			return addr.Unmap()
+		}
+	}
+
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		first, _, _ := strings.Cut(forwardedFor, ",")
+		if addr, err := netip.ParseAddr(strings.TrimSpace(first)); err == nil {
+			return addr.Unmap()
+		}
+	}
+
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addrPort.Addr().Unmap()
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+// AffinityConfig pins clients to the backend which served them first by a cookie holding an opaque signed token of it.
+// Once the backend is unhealthy or gone the cookie is ignored and replaced by one naming the backend serving the request
+// instead. Empty CookieName disables it.
+type AffinityConfig struct {
+	CookieName string
+	Secret     string        // signs cookies, balancers sharing clients need the same one, random per start when empty
+	MaxAge     time.Duration // of the cookie, 0 keeps it for the browser session
+}
+
+// affinity issues and verifies affinity cookies of a pool
+type affinity struct {
+	config AffinityConfig
+	pool   string
+	key    []byte
+}
+
+func newAffinity(pool string, config AffinityConfig) *affinity {
+	if config.CookieName == "" {
+		return nil
+	}
+
+	key := []byte(config.Secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	return &affinity{config: config, pool: pool, key: key}
+}
+
+// token identifies a backend of the pool in cookies without revealing its URL, it is keyed so it can be neither forged
+// nor matched against guessed URLs, and it binds the backend to the pool so a cookie of one pool is not honored by another
+func (a *affinity) token(backend string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(a.pool))
+	mac.Write([]byte{0})
+	mac.Write([]byte(backend))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// names reports whether a cookie value is the token of backend, compared in constant time
+func (a *affinity) names(value string, backend string) bool {
+	return hmac.Equal([]byte(value), []byte(a.token(backend)))
+}
+
+// pinned returns the healthy server the request is pinned to, nil if it is not pinned or its server cannot take it
+func (a *affinity) pinned(r *http.Request, healthyServers []*server) *server {
+	if a == nil {
+		return nil
+	}
+	cookie, err := r.Cookie(a.config.CookieName)
+	if err != nil {
+		return nil
+	}
+	for _, s := range healthyServers {
+		if a.names(cookie.Value, s.url.String()) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// pin sets the affinity cookie on a response of s unless the request is pinned to s already
+func (a *affinity) pin(resp *http.Response, s *server) {
+	if a == nil || resp.Request == nil {
+		return
+	}
+	backend := s.url.String()
+	if cookie, err := resp.Request.Cookie(a.config.CookieName); err == nil && a.names(cookie.Value, backend) {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     a.config.CookieName,
+		Value:    a.token(backend),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if a.config.MaxAge > 0 {
+		cookie.MaxAge = int(a.config.MaxAge.Seconds())
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncWriter buffers writes in a bounded queue written by a single goroutine, when the queue is full the oldest line is dropped
+type AsyncWriter struct {
+	out       io.Writer
+	queue     chan []byte
+	dropped   atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex // guards closed so Write never sends on a closed queue
+	closed    bool
+}
+
+// NewAsyncWriter starts the writer goroutine, Close must be called to flush the queue
+func NewAsyncWriter(out io.Writer, queueSize int) *AsyncWriter {
+	w := &AsyncWriter{
+		out:   out,
+		queue: make(chan []byte, max(queueSize, 1)),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		for line := range w.queue {
+			w.out.Write(line)
+		}
+	}()
+
+	return w
+}
+
+// Write enqueues a copy of p and never blocks on the underlying writer
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	line := make([]byte, len(p))
+	copy(line, p)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return w.out.Write(line)
+	}
+
+	for {
+		select {
+		case w.queue <- line:
+			return len(p), nil
+		default:
+		}
+
+		// queue is full, make room by dropping the oldest line
+		select {
+		case <-w.queue:
+			w.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of lines dropped because the queue was full
+func (w *AsyncWriter) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Close flushes queued lines, writes after Close go directly to the underlying writer
+func (w *AsyncWriter) Close() error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.queue)
+		w.mu.Unlock()
+	})
+	<-w.done
+
+	return nil
+}
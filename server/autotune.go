@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// autoTuneMaxSamples bounds the latencies kept between adjustments, later requests only count towards the error rate
+const autoTuneMaxSamples = 4096
+
+// AutoTuneConfig adjusts the capacity of a pool within MinCapacity and MaxCapacity to keep the p95 latency of proxied
+// requests under TargetLatency and their error rate under MaxErrorRate. Capacity is halved when a target is missed and
+// raised by Step when both are met while requests had to wait for capacity. Zero Interval disables it.
+type AutoTuneConfig struct {
+	Interval      time.Duration
+	TargetLatency time.Duration
+	MaxErrorRate  float64 // fraction of requests failing with a 5xx response or a transport error, 0 ignores errors
+	MinCapacity   int
+	MaxCapacity   int
+	Step          int
+	MinRequests   int // intervals with fewer requests leave the capacity alone
+}
+
+// autoTuner observes proxied requests of a pool between adjustments
+type autoTuner struct {
+	config    AutoTuneConfig
+	mu        sync.Mutex
+	latencies []time.Duration
+	requests  int
+	errors    int
+}
+
+func newAutoTuner(config AutoTuneConfig) *autoTuner {
+	if config.Interval <= 0 {
+		return nil
+	}
+	config.Step = max(config.Step, 1)
+	config.MinCapacity = max(config.MinCapacity, 1)
+
+	return &autoTuner{config: config}
+}
+
+// recordLatency counts a request served by a backend, it is called once its lease is released
+func (t *autoTuner) recordLatency(latency time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests++
+	if len(t.latencies) < autoTuneMaxSamples {
+		t.latencies = append(t.latencies, latency)
+	}
+}
+
+// recordError counts a 5xx response or a transport error
+func (t *autoTuner) recordError() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.errors++
+	t.mu.Unlock()
+}
+
+// collect returns the observations since the previous call and starts over
+func (t *autoTuner) collect() (p95 time.Duration, errorRate float64, requests int) {
+	t.mu.Lock()
+	latencies, requests, errors := t.latencies, t.requests, t.errors
+	t.latencies, t.requests, t.errors = nil, 0, 0
+	t.mu.Unlock()
+
+	if requests > 0 {
+		errorRate = float64(min(errors, requests)) / float64(requests)
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		p95 = latencies[(len(latencies)*95-1)/100]
+	}
+
+	return p95, errorRate, requests
+}
+
+// autoTune adjusts the capacity of the pool every interval until ctx is cancelled
+func (p *ProxyServerPool) autoTune(ctx context.Context) {
+	config := p.autoTuner.config
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	var lastContentions uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p95, errorRate, requests := p.autoTuner.collect()
+			contentions := p.capacity.contentions()
+			contended := contentions > lastContentions
+			lastContentions = contentions
+			if requests < config.MinRequests {
+				continue
+			}
+
+			current := p.capacity.capacity()
+			next := current
+			switch {
+			case config.TargetLatency > 0 && p95 > config.TargetLatency,
+				config.MaxErrorRate > 0 && errorRate > config.MaxErrorRate:
+				next = current / 2
+			case contended:
+				next = current + config.Step
+			}
+			next = max(config.MinCapacity, next)
+			if config.MaxCapacity > 0 {
+				next = min(config.MaxCapacity, next)
+			}
+			if next == current {
+				continue
+			}
+
+			p.capacity.setCapacity(next)
+			slog.Info("Adjusted pool capacity", "pool", p.name, "from", current, "to", next, "p95", p95, "errorRate", errorRate, "requests", requests)
+		}
+	}
+}
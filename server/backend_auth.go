@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	BackendAuthNone   = ""
+	BackendAuthBearer = "bearer"
+	BackendAuthBasic  = "basic"
+	BackendAuthMTLS   = "mtls"
+)
+
+var ErrUnknownBackendAuth = errors.New("unknown backend auth type")
+
+// BackendAuthConfig describes credentials the proxy attaches to requests forwarded to the pool
+type BackendAuthConfig struct {
+	Type     string
+	Token    string
+	Username string
+	Password string
+	CertFile string
+	KeyFile  string
+}
+
+// BackendAuth injects the configured credentials into proxied requests
+type BackendAuth struct {
+	config    BackendAuthConfig
+	transport http.RoundTripper
+}
+
+// NewBackendAuth validates the config and prepares credentials, for mTLS the client certificate is loaded once here
+func NewBackendAuth(config BackendAuthConfig) (*BackendAuth, error) {
+	a := &BackendAuth{config: config}
+
+	switch config.Type {
+	case BackendAuthNone:
+	case BackendAuthBearer:
+		if config.Token == "" {
+			return nil, errors.New("bearer backend auth requires a token")
+		}
+	case BackendAuthBasic:
+		if config.Username == "" {
+			return nil, errors.New("basic backend auth requires a username")
+		}
+	case BackendAuthMTLS:
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading backend client certificate: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		a.transport = transport
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackendAuth, config.Type)
+	}
+
+	return a, nil
+}
+
+// apply sets the credential headers on an outgoing request
+func (a *BackendAuth) apply(r *http.Request) {
+	if a == nil {
+		return
+	}
+
+	switch a.config.Type {
+	case BackendAuthBearer:
+		r.Header.Set("Authorization", "Bearer "+a.config.Token)
+	case BackendAuthBasic:
+		r.SetBasicAuth(a.config.Username, a.config.Password)
+	}
+}
+
+// Transport returns the round tripper carrying the client certificate, nil when the default transport should be used
+func (a *BackendAuth) Transport() http.RoundTripper {
+	if a == nil {
+		return nil
+	}
+
+	return a.transport
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// SetBackendEnabled takes a backend identified by its position in the pool out of rotation or puts it back, independently
+// of its health. A disabled backend keeps being health checked and drains its requests in flight until it is enabled again.
+func (p *ProxyServerPool) SetBackendEnabled(id int, enabled bool) (BackendStatus, error) {
+	for _, s := range *p.servers.Load() {
+		if s.id != id {
+			continue
+		}
+
+		if s.disabled.Swap(!enabled) != !enabled {
+			slog.Info("Backend rotation changed by operator", "backend", s.url.String(), "enabled", enabled)
+			p.refreshHealthyServers()
+			if enabled {
+				p.undrain(s)
+			} else {
+				p.drain(s)
+			}
+		}
+
+		return s.status(), nil
+	}
+
+	return BackendStatus{}, ErrUnknownBackend
+}
+
+// backendRotationHandler drains or enables a backend of the default pool identified by its position in the pool
+func backendRotationHandler(proxyServerPool ServerPool, enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid backend id", http.StatusBadRequest)
+			return
+		}
+
+		status, err := proxyServerPool.SetBackendEnabled(id, enabled)
+		if err != nil {
+			http.Error(w, "Backend not found", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// BackendRegistrationConfig enables backends to join the default pool themselves and to push heartbeats, both are disabled without a secret
+type BackendRegistrationConfig struct {
+	Secret       string
+	HeartbeatTTL time.Duration // backends must register again within this interval to stay in the pool
+}
+
+type backendHeartbeatRequest struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+type backendRegistrationRequest struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Secret string `json:"secret"`
+}
+
+// backendRegistrationHandler admits backends posting their URL, weight and the shared secret, posting again is the heartbeat
+func backendRegistrationHandler(proxyServerPool ServerPool, config BackendRegistrationConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.Secret == "" {
+			http.Error(w, "Backend registration is disabled", http.StatusNotFound)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req backendRegistrationRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(req.Secret), []byte(config.Secret)) != 1 {
+			slog.Warn("Rejected backend registration, invalid secret", "backend", req.URL)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if req.URL == "" {
+			http.Error(w, "URL is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Weight == 0 {
+			req.Weight = 1
+		}
+		if req.Weight < 1 || req.Weight > 100 {
+			http.Error(w, "Weight must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+
+		status, err := proxyServerPool.RegisterBackend(r.Context(), req.URL, req.Weight, config.HeartbeatTTL)
+		switch {
+		case errors.Is(err, ErrUnhealthyBackend):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, ErrStaticBackend):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrShuttingDown):
+			w.Header().Set(BalancerStatusHeader, BalancerStatusShuttingDown)
+			http.Error(w, "Balancer is shutting down", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// backendHeartbeatHandler accepts heartbeats of backends pushing them instead of being polled, it uses the registration secret
+func backendHeartbeatHandler(proxyServerPool ServerPool, config BackendRegistrationConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.Secret == "" {
+			http.Error(w, "Backend heartbeats are disabled", http.StatusNotFound)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req backendHeartbeatRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(req.Secret), []byte(config.Secret)) != 1 {
+			slog.Warn("Rejected heartbeat, invalid secret", "backend", req.URL)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		status, err := proxyServerPool.Heartbeat(req.URL)
+		switch {
+		case errors.Is(err, ErrUnknownBackend):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+	}
+}
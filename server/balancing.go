@@ -0,0 +1,88 @@
+package server
+
+import "math/rand/v2"
+
+const (
+	BalancingRoundRobin     = "round-robin"
+	BalancingConsistentHash = "consistent-hash"
+	BalancingP2C            = "p2c"
+
+	HashKeyClientIP = "ip"
+	HashKeyHeader   = "header"
+	HashKeyCookie   = "cookie"
+	HashKeyPath     = "path"
+
+	HashFunctionFNV    = "fnv"
+	HashFunctionXXHash = "xxhash"
+	HashFunctionMaglev = "maglev"
+)
+
+// defaultVirtualNodes smooths the distribution of keys when backends come and go
+const defaultVirtualNodes = 100
+
+// BalancingConfig selects how a pool picks a healthy backend for a request
+type BalancingConfig struct {
+	Mode            string         // round-robin (default), consistent-hash, p2c or the experimental bandit
+	HashKey         HashKeyConfig  // request attribute keying consistent-hash mode
+	VirtualNodes    int            // ring points per unit of backend weight in consistent-hash mode
+	HashFunction    string         // fnv (plain FNV-1a), xxhash (XXH64) or a maglev lookup table, a mixed FNV-1a ring by default
+	ExplorationRate float64        // fraction of requests sent to a random backend in bandit mode, 0.1 by default
+	Affinity        AffinityConfig // sticky sessions by cookie, they take precedence over the mode while the backend is healthy
+}
+
+// HashKeyConfig names the request attribute a client is identified by, Name is the header or cookie name and
+// Segment the index of the path segment, e.g. 1 keys /tenants/acme/orders by acme
+type HashKeyConfig struct {
+	Source  string // ip (default), header, cookie or path
+	Name    string
+	Segment int
+}
+
+// leastLoadedOfTwo samples two random servers and returns the one with fewer requests in flight.
+// Unlike picking the global minimum it avoids herding all requests onto the same idle server.
+func leastLoadedOfTwo(servers []*server) *server {
+	if len(servers) == 1 {
+		return servers[0]
+	}
+
+	i := rand.IntN(len(servers))
+	j := (i + 1 + rand.IntN(len(servers)-1)) % len(servers)
+	a, b := servers[i], servers[j]
+	if b.inFlight.Load() < a.inFlight.Load() {
+		return b
+	}
+
+	return a
+}
+
+// BackendCapacityConfig limits requests in flight per backend so a slow backend cannot take the whole capacity of its pool,
+// PerBackend overrides MaxInFlight for backends keyed by URL, 0 means no limit
+type BackendCapacityConfig struct {
+	MaxInFlight int
+	PerBackend  map[string]int
+}
+
+func (c BackendCapacityConfig) limit(rawUrl string) int {
+	if limit, ok := c.PerBackend[rawUrl]; ok {
+		return limit
+	}
+
+	return c.MaxInFlight
+}
+
+// reserveAnother reserves a slot on a healthy server which did not fail the request yet, starting at a random one so
+// saturation of a server spreads its load evenly, nil if all are saturated
+func reserveAnother(servers []*server, attempt *retryAttempt) *server {
+	start := rand.IntN(len(servers))
+	for i := range servers {
+		s := servers[(start+i)%len(servers)]
+		if attempt != nil && attempt.failed(s) {
+			continue
+		}
+		if s.reserve() {
+			return s
+		}
+	}
+
+	return nil
+}
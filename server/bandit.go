@@ -0,0 +1,222 @@
+package server
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// BalancingBandit is experimental, it learns which backends are fastest and most reliable instead of spreading requests evenly
+	BalancingBandit = "bandit"
+
+	// defaultExplorationRate is the fraction of requests sent to a random backend when none is configured
+	defaultExplorationRate = 0.1
+	// banditDecay weighs the latest observation of a backend in its moving averages, older ones fade out
+	banditDecay = 0.2
+)
+
+// BanditStats compares backends as seen by bandit selection. Explored requests go to a random backend, they are the
+// baseline round-robin would achieve, exploited requests go to the backend with the best score.
+type BanditStats struct {
+	Pool            string               `json:"pool"`
+	ExplorationRate float64              `json:"explorationRate"`
+	Exploited       BanditOutcome        `json:"exploited"`
+	Explored        BanditOutcome        `json:"explored"`
+	Backends        []BanditBackendStats `json:"backends"`
+}
+
+// BanditOutcome counts requests and their latency since start
+type BanditOutcome struct {
+	Requests         uint64  `json:"requests"`
+	AverageLatencyMs float64 `json:"averageLatencyMs"`
+}
+
+// BanditBackendStats are the moving averages a backend is scored by, the score is the success rate per second of latency
+type BanditBackendStats struct {
+	URL         string  `json:"url"`
+	Pulls       uint64  `json:"pulls"`
+	LatencyMs   float64 `json:"latencyMs"`
+	SuccessRate float64 `json:"successRate"`
+	Score       float64 `json:"score"`
+}
+
+// bandit selects backends epsilon-greedily, backends without observations are tried first
+type bandit struct {
+	explorationRate float64
+	mu              sync.Mutex
+	arms            map[string]*banditArm // keyed by backend URL
+	exploited       banditOutcome
+	explored        banditOutcome
+}
+
+type banditArm struct {
+	pulls       uint64
+	latency     float64 // seconds
+	success     float64
+	hasLatency  bool
+	hasOutcomes bool
+}
+
+type banditOutcome struct {
+	requests uint64
+	latency  time.Duration
+}
+
+func newBandit(explorationRate float64) *bandit {
+	if explorationRate <= 0 {
+		explorationRate = defaultExplorationRate
+	}
+
+	return &bandit{explorationRate: min(explorationRate, 1), arms: make(map[string]*banditArm)}
+}
+
+// score is the success rate per second of latency, latencies under a millisecond do not count as faster
+func (a *banditArm) score() float64 {
+	success := a.success
+	if !a.hasOutcomes {
+		success = 1
+	}
+
+	return success / max(a.latency, 0.001)
+}
+
+// pick returns a backend and whether it was explored
+func (b *bandit) pick(servers []*server) (*server, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *server
+	bestScore := -1.0
+	for _, s := range servers {
+		arm, ok := b.arms[s.url.String()]
+		if !ok || !arm.hasLatency {
+			return s, true
+		}
+		if score := arm.score(); score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+
+	if rand.Float64() < b.explorationRate {
+		return servers[rand.IntN(len(servers))], true
+	}
+
+	return best, false
+}
+
+func (b *bandit) arm(s *server) *banditArm {
+	arm, ok := b.arms[s.url.String()]
+	if !ok {
+		arm = &banditArm{}
+		b.arms[s.url.String()] = arm
+	}
+
+	return arm
+}
+
+// recordLatency updates the latency of the backend once its lease is released
+func (b *bandit) recordLatency(s *server, latency time.Duration, explored bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	arm := b.arm(s)
+	arm.pulls++
+	if arm.hasLatency {
+		arm.latency += banditDecay * (latency.Seconds() - arm.latency)
+	} else {
+		arm.latency, arm.hasLatency = latency.Seconds(), true
+	}
+
+	outcome := &b.exploited
+	if explored {
+		outcome = &b.explored
+	}
+	outcome.requests++
+	outcome.latency += latency
+}
+
+// recordResult updates the success rate of the backend, failed covers 5xx responses and transport errors
+func (b *bandit) recordResult(s *server, failed bool) {
+	if b == nil {
+		return
+	}
+
+	success := 1.0
+	if failed {
+		success = 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	arm := b.arm(s)
+	if arm.hasOutcomes {
+		arm.success += banditDecay * (success - arm.success)
+	} else {
+		arm.success, arm.hasOutcomes = success, true
+	}
+}
+
+// forget drops a backend which left the pool
+func (b *bandit) forget(s *server) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	delete(b.arms, s.url.String())
+	b.mu.Unlock()
+}
+
+func (b *bandit) stats(pool string) BanditStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := BanditStats{
+		Pool:            pool,
+		ExplorationRate: b.explorationRate,
+		Exploited:       b.exploited.stats(),
+		Explored:        b.explored.stats(),
+		Backends:        make([]BanditBackendStats, 0, len(b.arms)),
+	}
+	for url, arm := range b.arms {
+		stats.Backends = append(stats.Backends, BanditBackendStats{
+			URL:         url,
+			Pulls:       arm.pulls,
+			LatencyMs:   arm.latency * 1000,
+			SuccessRate: arm.success,
+			Score:       arm.score(),
+		})
+	}
+
+	return stats
+}
+
+func (o banditOutcome) stats() BanditOutcome {
+	stats := BanditOutcome{Requests: o.requests}
+	if o.requests > 0 {
+		stats.AverageLatencyMs = float64(o.latency.Microseconds()) / float64(o.requests) / 1000
+	}
+
+	return stats
+}
+
+// banditHandler lists bandit statistics of all pools balancing in bandit mode
+func banditHandler(poolRouter *PoolRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := make([]BanditStats, 0)
+		for _, pool := range poolRouter.Pools() {
+			if bandit, ok := pool.Bandit(); ok {
+				stats = append(stats, bandit)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
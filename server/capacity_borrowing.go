@@ -0,0 +1,143 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// CapacityBorrowingConfig lets pools whose requests wait for capacity borrow the unused capacity of idle pools, a lender
+// takes its capacity back once its own requests wait and a borrower returns it once it no longer needs it. Capacity is
+// moved every Interval, at most MaxBorrowed on top of the MaxCapacity of a pool and at most MaxLent out of it.
+// Capacity taken back stays in use by the borrower until its requests finish. Zero Interval disables it.
+type CapacityBorrowingConfig struct {
+	Interval    time.Duration
+	MaxBorrowed int
+	MaxLent     int
+}
+
+// capacityLoan is capacity of lender held by borrower
+type capacityLoan struct {
+	borrower *ProxyServerPool
+	lender   *ProxyServerPool
+}
+
+// capacityLedger moves capacity between pools and keeps track of the units lent
+type capacityLedger struct {
+	config CapacityBorrowingConfig
+	pools  []*ProxyServerPool
+	loans  map[capacityLoan]int
+}
+
+// ShareCapacity lets pools borrow unused capacity of each other every interval until ctx is cancelled, pools other than
+// ProxyServerPool neither borrow nor lend
+func ShareCapacity(ctx context.Context, config CapacityBorrowingConfig, pools []ServerPool) {
+	if config.Interval <= 0 {
+		return
+	}
+	ledger := &capacityLedger{config: config, loans: make(map[capacityLoan]int)}
+	for _, pool := range pools {
+		if pool, ok := pool.(*ProxyServerPool); ok {
+			ledger.pools = append(ledger.pools, pool)
+		}
+	}
+	if len(ledger.pools) < 2 {
+		return
+	}
+	// pools are visited in a stable order so none is favored by map iteration
+	slices.SortFunc(ledger.pools, func(a, b *ProxyServerPool) int { return cmp.Compare(a.name, b.name) })
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ledger.settle()
+			}
+		}
+	}()
+}
+
+// settle returns capacity to lenders needing it and from borrowers not needing it anymore, then lends idle capacity
+// to pools whose requests wait
+func (l *capacityLedger) settle() {
+	for loan, units := range l.loans {
+		needed := min(units, loan.lender.GetWaiting())
+		if loan.borrower.GetWaiting() == 0 {
+			needed = max(needed, min(units, loan.borrower.GetAvailableCapacity()))
+		}
+		l.move(loan, -needed)
+	}
+
+	for _, borrower := range l.pools {
+		// waiters granted capacity leave the queue asynchronously, so the count is taken once
+		wanted := min(borrower.GetWaiting(), l.config.MaxBorrowed-l.borrowed(borrower))
+		for _, lender := range l.pools {
+			if wanted <= 0 {
+				break
+			}
+			if lender == borrower || lender.GetWaiting() > 0 || l.borrowed(lender) > 0 {
+				continue
+			}
+			if units := min(wanted, lender.GetAvailableCapacity(), l.config.MaxLent-l.lent(lender)); units > 0 {
+				l.move(capacityLoan{borrower: borrower, lender: lender}, units)
+				wanted -= units
+			}
+		}
+	}
+}
+
+// move lends units of capacity, negative units are returned
+func (l *capacityLedger) move(loan capacityLoan, units int) {
+	// the pool giving capacity away shrinks first so it is not granted twice
+	switch {
+	case units > 0:
+		loan.lender.capacity.setCapacity(loan.lender.capacity.capacity() - units)
+		loan.borrower.capacity.setCapacity(loan.borrower.capacity.capacity() + units)
+	case units < 0:
+		loan.borrower.capacity.setCapacity(loan.borrower.capacity.capacity() + units)
+		loan.lender.capacity.setCapacity(loan.lender.capacity.capacity() - units)
+	default:
+		return
+	}
+	l.loans[loan] += units
+	if l.loans[loan] <= 0 {
+		delete(l.loans, loan)
+	}
+
+	if units > 0 {
+		slog.Info("Lent pool capacity", "lender", loan.lender.name, "borrower", loan.borrower.name, "units", units)
+	} else {
+		slog.Info("Returned pool capacity", "lender", loan.lender.name, "borrower", loan.borrower.name, "units", -units)
+	}
+}
+
+// borrowed returns the capacity pool holds of other pools
+func (l *capacityLedger) borrowed(pool *ProxyServerPool) int {
+	var units int
+	for loan, n := range l.loans {
+		if loan.borrower == pool {
+			units += n
+		}
+	}
+
+	return units
+}
+
+// lent returns the capacity of pool held by other pools
+func (l *capacityLedger) lent(pool *ProxyServerPool) int {
+	var units int
+	for loan, n := range l.loans {
+		if loan.lender == pool {
+			units += n
+		}
+	}
+
+	return units
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/javor454/balancer/auth"
+	"github.com/javor454/balancer/lifecycle"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// defaultClientStoreInterval bounds how many registrations a crash loses
+	defaultClientStoreInterval = 30 * time.Second
+
+	// clientStoreOpenTimeout bounds waiting for the lock of a database another balancer holds open
+	clientStoreOpenTimeout = time.Second
+)
+
+var (
+	clientsBucket = []byte("clients")
+	snapshotKey   = []byte("snapshot")
+)
+
+// ClientStoreConfig persists the registered clients to an embedded BoltDB database at Path so they survive restarts,
+// a snapshot is written every Interval and once more on shutdown. Empty Path disables it.
+type ClientStoreConfig struct {
+	Path     string
+	Interval time.Duration // 30s by default
+}
+
+// ClientStore writes snapshots of the registered clients to BoltDB and restores them on startup, a nil store does nothing.
+// Queued requests are not persisted, they belong to connections which do not survive a restart.
+type ClientStore struct {
+	db          *bolt.DB
+	interval    time.Duration
+	authHandler *auth.AuthHandler
+	codec       auth.SnapshotCodec
+	background  lifecycle.Group
+}
+
+// NewClientStore opens the database, it returns nil if persistence is disabled
+func NewClientStore(config ClientStoreConfig, authHandler *auth.AuthHandler) (*ClientStore, error) {
+	if config.Path == "" {
+		return nil, nil
+	}
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultClientStoreInterval
+	}
+
+	db, err := bolt.Open(config.Path, 0o600, &bolt.Options{Timeout: clientStoreOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error opening client store %s: %w", config.Path, err)
+	}
+
+	return &ClientStore{db: db, interval: interval, authHandler: authHandler, codec: auth.JSONSnapshotCodec{}}, nil
+}
+
+// Restore loads the clients of the last snapshot, a missing snapshot is a first start
+func (s *ClientStore) Restore() error {
+	if s == nil {
+		return nil
+	}
+
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(clientsBucket); bucket != nil {
+			// the value is only valid during the transaction
+			data = append(data, bucket.Get(snapshotKey)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading client snapshot: %w", err)
+	}
+	if data == nil {
+		slog.Info("No client snapshot to restore", "path", s.db.Path())
+		return nil
+	}
+
+	snapshot, err := s.codec.Decode(data)
+	if err != nil {
+		return fmt.Errorf("error restoring clients from %s: %w", s.db.Path(), err)
+	}
+	s.authHandler.Restore(snapshot)
+	slog.Info("Restored clients", "path", s.db.Path(), "clients", len(snapshot.Clients), "tombstones", len(snapshot.Tombstones))
+
+	return nil
+}
+
+// Save writes a snapshot of the clients in one transaction, a crash never leaves a partial snapshot
+func (s *ClientStore) Save() error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := s.codec.Encode(s.authHandler.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(clientsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(snapshotKey, data)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing client snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Run saves a snapshot every interval until ctx is done, the final snapshot is left to the shutdown
+func (s *ClientStore) Run(ctx context.Context) {
+	if s == nil {
+		return
+	}
+
+	s.background.Go(func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Save(); err != nil {
+					slog.Error("Failed to persist clients", "path", s.db.Path(), "error", err)
+				}
+			}
+		}
+	})
+}
+
+// Shutdown waits for the periodic snapshots to stop, they stop once the context passed to Run is cancelled
+func (s *ClientStore) Shutdown(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.background.Wait(ctx); err != nil {
+		return fmt.Errorf("client store shutdown failed: %w", err)
+	}
+
+	return nil
+}
+
+// Close writes the final snapshot and closes the database
+func (s *ClientStore) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	saveErr := s.Save()
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("error closing client store: %w", err)
+	}
+
+	return saveErr
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// UpstreamEncodingForward sends the Accept-Encoding of the client to backends, encoded responses pass through as they are
+	UpstreamEncodingForward = "forward"
+	// UpstreamEncodingStrip drops the Accept-Encoding of the client, the transport then asks backends for gzip itself and
+	// decompresses their responses so the balancer decides about compression towards the client
+	UpstreamEncodingStrip = "strip"
+	// UpstreamEncodingIdentity asks backends for uncompressed responses
+	UpstreamEncodingIdentity = "identity"
+
+	// defaultCompressionMinSize keeps small responses uncompressed, gzip overhead outweighs the savings below it
+	defaultCompressionMinSize = 1024
+)
+
+// defaultCompressedTypes are compressed when no ContentTypes are configured
+var defaultCompressedTypes = []string{"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+
+// CompressionConfig gzips responses for clients accepting it and manages the Accept-Encoding sent to backends.
+// Responses a backend already encoded are never compressed again, so forwarding keeps backend compression end-to-end.
+type CompressionConfig struct {
+	Enabled          bool
+	MinSize          ByteSize // responses with a smaller Content-Length are sent uncompressed, 1KB by default
+	ContentTypes     []string // media types to compress, "text/*" matches all text types, text and JSON by default
+	UpstreamEncoding string   // forward (default), strip or identity
+}
+
+// Validate checks the upstream encoding is known
+func (c CompressionConfig) Validate() error {
+	switch c.UpstreamEncoding {
+	case "", UpstreamEncodingForward, UpstreamEncodingStrip, UpstreamEncodingIdentity:
+		return nil
+	default:
+		return fmt.Errorf("unknown upstream encoding %q, expected forward, strip or identity", c.UpstreamEncoding)
+	}
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// WithCompression applies the upstream encoding to requests and gzips responses when enabled
+func WithCompression(config CompressionConfig) Middleware {
+	minSize := int64(config.MinSize)
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+	contentTypes := config.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultCompressedTypes
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				acceptsGzip := acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip")
+
+				switch config.UpstreamEncoding {
+				case UpstreamEncodingStrip:
+					r.Header.Del("Accept-Encoding")
+				case UpstreamEncodingIdentity:
+					r.Header.Set("Accept-Encoding", "identity")
+				}
+
+				if !config.Enabled || !acceptsGzip || r.Method == http.MethodHead {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				cw := &compressingWriter{ResponseWriter: w, minSize: minSize, contentTypes: contentTypes}
+				defer cw.close()
+				next.ServeHTTP(cw, r)
+			},
+		)
+	}
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows encoding, "*" counts unless refused with q=0
+func acceptsEncoding(header string, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		refused := false
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				refused = true
+			}
+		}
+		if name == encoding {
+			return !refused
+		}
+		accepted = !refused
+	}
+
+	return accepted
+}
+
+// compressingWriter decides on the first header write whether the response is compressed, compressed responses lose their
+// Content-Length because the compressed length is unknown until the end, they are sent chunked instead
+type compressingWriter struct {
+	http.ResponseWriter
+	minSize      int64
+	contentTypes []string
+	gz           *gzip.Writer
+	wroteHeader  bool
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, Flush is handled here so buffered gzip data goes out first
+func (cw *compressingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressingWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	if cw.compressible(code) {
+		header := cw.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// the compressed body is not byte-identical to the one the backend tagged
+			header.Set("ETag", "W/"+etag)
+		}
+
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressingWriter) compressible(code int) bool {
+	header := cw.Header()
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		return false
+	}
+	// an encoded backend response passes through untouched, compressing it again would corrupt it for the client
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	if header.Get("Content-Range") != "" {
+		return false
+	}
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length < cw.minSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	// clients of event streams do not expect them compressed and gzip would hold events back until a flush
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+
+	return slices.ContainsFunc(cw.contentTypes, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(mediaType, prefix)
+		}
+		return mediaType == pattern
+	})
+}
+
+func (cw *compressingWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+
+	return cw.gz.Write(b)
+}
+
+// FlushError flushes compressed data written so far so streamed responses keep working
+func (cw *compressingWriter) FlushError() error {
+	if cw.gz != nil {
+		if err := cw.gz.Flush(); err != nil {
+			return err
+		}
+	}
+
+	err := http.NewResponseController(cw.ResponseWriter).Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+
+	return err
+}
+
+// close writes the gzip trailer once the handler returned
+func (cw *compressingWriter) close() {
+	if cw.gz == nil {
+		return
+	}
+
+	cw.gz.Close()
+	cw.gz.Reset(nil)
+	gzipWriters.Put(cw.gz)
+	cw.gz = nil
+}
@@ -1,17 +1,207 @@
 package server
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"time"
+)
 
 type HttpConfig struct {
 	Port                   int
+	AdminPort              int       // dedicated listener for health and admin endpoints so they stay reachable under overload, 0 disables it
+	BindAddresses          []string  // IP addresses or interface names the main listener binds to, all interfaces if empty
+	AdminBindAddresses     []string  // same for the admin listener, e.g. "127.0.0.1" and "::1" keep it local
+	TLS                    TLSConfig // terminates TLS on the main listener, disabled by default
 	ShutdownTimeout        time.Duration
+	ShutdownReportPath     string // the shutdown report is always logged, it is also written to this file if set
 	RequestTimeout         time.Duration
+	Retry                  RetryConfig
+	GoroutineGovernor      GoroutineGovernorConfig // sheds requests during spikes, disabled by default
 	WhitelistedPaths       []string
-	AuthBlacklistedPaths   []string
+	AuthBlacklistedPaths   []string // served without client auth, "GET /clients/*" exempts only one method
+	TrustedProxies         []string
+	DeniedHeaders          []string
+	RouteMethods           map[string][]string // allowed methods per path pattern, e.g. "/public/*": {"GET", "HEAD"}
+	LogSampleRate          float64
+	StreamRequestBodies    bool                   // never buffer request bodies, needed for large uploads, incompatible with request signing
+	VerboseLogging         bool                   // log debug records, they are filtered by Log.Level as well
+	Log                    LogConfig              // level, format and output of every log record
+	Maintenance            bool                   // start with proxied requests rejected, toggled at runtime via /admin/maintenance
+	ReadOnly               bool                   // start with registrations and admin changes refused, toggled at runtime via /admin/read-only
+	MaintenanceBypassToken string                 // operators sending it in X-Maintenance-Bypass reach backends during maintenance
+	AdminToken             string                 // required in X-Admin-Token by /admin/* endpoints, they are refused while empty
+	FailureInjection       FailureInjectionConfig // for resilience testing of clients, injects nothing by default
+	AccessLog              LogOutputConfig
+	DebugLog               LogOutputConfig
 	ProxyServers           []string
+	ResponseValidation     ResponseValidationConfig
+	Balancing              BalancingConfig              // applies to every pool
+	ErrorBudget            ErrorBudgetConfig            // of the default pool, named pools configure their own
+	BackendPools           map[string]BackendPoolConfig // additional named pools, ProxyServers form the default pool
+	DarkLaunch             DarkLaunchConfig
+	RouteRules             []RouteRuleConfig    // evaluated in order on headers and paths, the first match picks the pool
+	TrafficSplits          []TrafficSplitConfig // percentages of the default pool traffic sent to other pools, e.g. a canary
+	ContentRoutes          []ContentRouteConfig // evaluated in order on JSON request bodies, the first match picks the pool
+	PinSessions            bool                 // keep each client session on the pool it was first routed to
+	Experiments            []ExperimentConfig
 	HealthCheckInterval    time.Duration
+	DrainTimeout           time.Duration // requests in flight on a removed or unhealthy backend get this long to finish, 0 waits for them indefinitely
+	HealthCheckProbe       string
+	ReadyMinBackends       int                      // /ready answers 503 while the default pool has fewer healthy backends, 1 by default
+	HealthCheckThresholds  HealthCheckThresholds    // damp flapping by requiring streaks of results, applies to every pool
+	PassiveHealthCheck     PassiveHealthCheckConfig // applies to every pool
+	BackendRegistration    BackendRegistrationConfig
+	PushHeartbeats         map[string]PushHeartbeatConfig // backends of the default pool keyed by URL which push heartbeats instead of being polled
+	BackendAuth            BackendAuthConfig
+	BackendProtocol        string            // http1 (default) or h2c for plaintext gRPC backends, applies to every pool
+	AcceptH2C              bool              // serve cleartext HTTP/2 on the main listener for gRPC clients, streaming calls need StreamRequestBodies
+	UpstreamTLS            UpstreamTLSConfig // verification of https:// backends and client certificates presented to them, applies to every pool
+	RequestSigning         RequestSigningConfig
+	ConnectionPrewarm      ConnectionPrewarmConfig // applies to every pool
 	MaxCapacity            int
+	BackendCapacity        BackendCapacityConfig // in-flight limits per backend within the capacity of a pool, applies to every pool
 	AcquireCapacityTimeout time.Duration
+	MaxQueueDepth          int                     // requests waiting for capacity beyond it are refused right away, 0 for no limit
+	AutoTune               AutoTuneConfig          // adjusts MaxCapacity of every pool at runtime, disabled by default
+	CapacityBorrowing      CapacityBorrowingConfig // lends unused capacity of idle pools to pools whose requests wait, disabled by default
+	Starvation             StarvationConfig        // warns about clients waiting for capacity too long in any pool, disabled by default
+	SessionExpiryWarning   time.Duration
+	ClientStore            ClientStoreConfig               // persists registered clients to BoltDB across restarts, disabled by default
+	AdmissionRules         []AdmissionRuleConfig           // evaluated in order on registration, the first match decides
+	ClientBandwidth        BandwidthLimitConfig            // applied to each registered client separately
+	RouteBandwidth         map[string]BandwidthLimitConfig // keyed by path prefix, the longest matching prefix applies
+	Compression            CompressionConfig               // gzip for clients and the Accept-Encoding sent to backends
+	Scopes                 ScopeConfig                     // route groups registered clients may access, unrestricted by default
+	MemoryWatchdog         MemoryWatchdogConfig            // sheds load before the heap outgrows its limit, disabled by default
+	Mirror                 MirrorConfig                    // copies a sample of proxied requests to a shadow backend, disabled by default
+	RateLimit              RateLimitConfig                 // shared by all requests, unlimited by default
+	RouteRateLimits        map[string]RateLimitConfig      // keyed by path prefix, the longest matching prefix applies on top of RateLimit
+	StreamLimits           StreamLimitConfig               // concurrent server-sent event streams per client and in total
+	Runtime                RuntimeConfig
+}
+
+// BackendPoolConfig is a named group of backends selectable by routing rules
+type BackendPoolConfig struct {
+	Servers            []string
+	ResponseValidation ResponseValidationConfig
+	ErrorBudget        ErrorBudgetConfig
+	BackendAuth        *BackendAuthConfig // credentials of the pool, nil uses those of the default pool
+}
+
+// Validate checks combinations of options which cannot work together, every problem found is reported
+func (c *HttpConfig) Validate() error {
+	var errs []error
+	if _, err := ResolveBindAddresses(c.BindAddresses, c.Port); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ResolveBindAddresses(c.AdminBindAddresses, c.AdminPort); err != nil {
+		errs = append(errs, fmt.Errorf("admin listener: %w", err))
+	}
+	if err := c.UpstreamTLS.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.TLS.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Scopes.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Compression.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Mirror.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Log.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ReadyMinBackends < 0 {
+		errs = append(errs, errors.New("minimum healthy backends of readiness must not be negative"))
+	}
+	if c.MemoryWatchdog.ShedRatio < 0 || c.MemoryWatchdog.ShedRatio > 1 {
+		errs = append(errs, errors.New("memory watchdog shed ratio must be between 0 and 1"))
+	}
+	if c.AutoTune.MaxCapacity > 0 && c.AutoTune.MinCapacity > c.AutoTune.MaxCapacity {
+		errs = append(errs, errors.New("auto-tuning minimum capacity exceeds its maximum capacity"))
+	}
+	if c.CapacityBorrowing.MaxBorrowed < 0 || c.CapacityBorrowing.MaxLent < 0 {
+		errs = append(errs, errors.New("capacity borrowing limits must not be negative"))
+	}
+	if c.CapacityBorrowing.Interval > 0 && c.AutoTune.Interval > 0 {
+		errs = append(errs, errors.New("auto-tuning and capacity borrowing both change the capacity of pools and cannot be combined"))
+	}
+	if c.PassiveHealthCheck.Failures > 0 && c.PassiveHealthCheck.Window <= 0 {
+		errs = append(errs, errors.New("passive health checks require a positive window"))
+	}
+	if fi := c.FailureInjection; min(fi.LatencyRate, fi.ErrorRate, fi.DropRate) < 0 || fi.ErrorRate+fi.DropRate > 1 || fi.LatencyRate > 1 {
+		errs = append(errs, errors.New("failure injection rates must be between 0 and 1, error and drop rates together too"))
+	}
+	for prefix, limit := range c.RouteRateLimits {
+		if limit.RequestsPerSecond < 0 || limit.Burst < 0 {
+			errs = append(errs, fmt.Errorf("rate limit of %s must not be negative", prefix))
+		}
+	}
+	if c.RateLimit.RequestsPerSecond < 0 || c.RateLimit.Burst < 0 {
+		errs = append(errs, errors.New("rate limit must not be negative"))
+	}
+	if c.StreamLimits.PerClient < 0 || c.StreamLimits.Total < 0 {
+		errs = append(errs, errors.New("stream limits must not be negative"))
+	}
+	if c.StreamRequestBodies && c.Retry.BufferRequestBodies {
+		errs = append(errs, errors.New("streamed request bodies cannot be buffered for retries"))
+	}
+	if c.StreamRequestBodies && len(c.ContentRoutes) > 0 {
+		errs = append(errs, errors.New("content routes read the request body and cannot be combined with streamed request bodies"))
+	}
+	if c.StreamRequestBodies && len(c.RequestSigning.Keys) > 0 {
+		errs = append(errs, errors.New("request signing hashes the request body and cannot be combined with streamed request bodies"))
+	}
+	if c.RequestTimeout > 0 && c.AcquireCapacityTimeout > c.RequestTimeout {
+		errs = append(errs, fmt.Errorf("acquire capacity timeout %s exceeds the request timeout %s, requests would time out while queued", c.AcquireCapacityTimeout, c.RequestTimeout))
+	}
+	if c.BackendProtocol == BackendProtocolH2C {
+		if !c.UpstreamTLS.isZero() || len(c.UpstreamTLS.PerBackend) > 0 {
+			errs = append(errs, errors.New("h2c backends are reached without TLS, upstream TLS cannot be applied"))
+		}
+		if c.BackendAuth.Type == BackendAuthMTLS {
+			errs = append(errs, errors.New("h2c backends are reached without TLS, mTLS backend auth cannot be applied"))
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.BackendPools)) {
+			if backendAuth := c.BackendPools[name].BackendAuth; backendAuth != nil && backendAuth.Type == BackendAuthMTLS {
+				errs = append(errs, fmt.Errorf("h2c backends are reached without TLS, mTLS backend auth of pool %s cannot be applied", name))
+			}
+		}
+	}
+	for i, rawUrl := range c.ProxyServers {
+		if err := validateBackendURL(rawUrl); err != nil {
+			errs = append(errs, fmt.Errorf("ProxyServers[%d]: %w", i, err))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.BackendPools)) {
+		for i, rawUrl := range c.BackendPools[name].Servers {
+			if err := validateBackendURL(rawUrl); err != nil {
+				errs = append(errs, fmt.Errorf("BackendPools.%s.Servers[%d]: %w", name, i, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateBackendURL checks a backend is an absolute http or https URL
+func validateBackendURL(rawUrl string) error {
+	backend, err := url.Parse(rawUrl)
+	if err != nil {
+		return fmt.Errorf("invalid backend URL %q: %w", rawUrl, err)
+	}
+	if backend.Scheme != "http" && backend.Scheme != "https" || backend.Host == "" {
+		return fmt.Errorf("invalid backend URL %q, expected an absolute http or https URL", rawUrl)
+	}
+
+	return nil
 }
 
 func NewDefaultHttpConfig() *HttpConfig {
@@ -19,11 +209,29 @@ func NewDefaultHttpConfig() *HttpConfig {
 		Port:                   8080,
 		ShutdownTimeout:        10 * time.Second,
 		RequestTimeout:         10 * time.Second,
-		WhitelistedPaths:       []string{"/dummy", "/register", "/health"},
-		AuthBlacklistedPaths:   []string{"/register", "/health"},
+		Retry:                  RetryConfig{Attempts: 1, MaxBufferedBodySize: 1 << 20},
+		WhitelistedPaths:       []string{"/dummy", "/register", "/health", "/healthz", "/ready", "/admin/diagnostics", "/admin/logging", "/admin/health-checks", "/admin/maintenance", "/admin/balancing", "/admin/read-only", "/admin/backends/*", "/admin/error-budgets", "/admin/fairness", "/admin/bandit", "/admin/switchover", "/admin/ui", "/admin/ui/events", "/queue/stats", "/clients/*"},
+		AuthBlacklistedPaths:   []string{"/register", "GET /clients/*", "/health", "/healthz", "/ready", "/queue/stats", "/admin/*"}, // admin endpoints require the admin token instead, clients whose session ended must still be able to look themselves up
+		LogSampleRate:          1,
+		VerboseLogging:         true,
+		Log:                    LogConfig{Level: "debug", Format: LogFormatText, Output: "stderr"},
+		AccessLog:              LogOutputConfig{Async: true, QueueSize: 10000},
+		DebugLog:               LogOutputConfig{Async: true, QueueSize: 10000},
 		ProxyServers:           []string{"http://wiremock1:8080", "http://wiremock2:8080", "http://wiremock3:8080"},
 		HealthCheckInterval:    5 * time.Second,
+		DrainTimeout:           30 * time.Second,
+		HealthCheckProbe:       HealthProbeHttp,
+		ReadyMinBackends:       1,
+		HealthCheckThresholds:  HealthCheckThresholds{Rise: 2, Fall: 3},
+		PassiveHealthCheck:     PassiveHealthCheckConfig{Failures: 5, Window: 10 * time.Second},
+		BackendRegistration:    BackendRegistrationConfig{HeartbeatTTL: 30 * time.Second},
+		ConnectionPrewarm:      ConnectionPrewarmConfig{Interval: 30 * time.Second},
 		MaxCapacity:            5,
 		AcquireCapacityTimeout: 10 * time.Second,
+		SessionExpiryWarning:   time.Minute,
+		StreamLimits:           StreamLimitConfig{PerClient: 5, Total: 1000},
+		Runtime: RuntimeConfig{
+			AutoMaxProcs: true,
+		},
 	}
 }
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a size in bytes, config files may write it as a number of bytes or with a unit such as "10MB" or "512KiB"
+type ByteSize int64
+
+const (
+	durationFormats = `a duration such as "300ms", "1.5s" or "2h45m"`
+	byteSizeFormats = `a number of bytes or a size such as "512B", "10KB", "1.5MB", "2GB" (powers of 1000) or "64KiB", "10MiB", "2GiB" (powers of 1024)`
+)
+
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// ParseByteSize parses a number of bytes with an optional unit
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	split := strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) })
+	if split < 0 {
+		split = len(s)
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s[:split]), 64)
+	unit, ok := byteSizeUnits[strings.ToUpper(strings.TrimSpace(s[split:]))]
+	if err != nil || !ok || value < 0 || value*unit > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q, expected %s", s, byteSizeFormats)
+	}
+
+	return ByteSize(value * unit), nil
+}
+
+// UnmarshalJSON accepts a number of bytes or a string with a unit
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+
+	return nil
+}
+
+// LoadHttpConfig reads a JSON or, with a .yaml or .yml extension, a YAML config file overlaying the defaults, fields missing
+// in the file keep their default. Keys match field names case-insensitively, keys starting with ConfigCommentKey are comments,
+// durations are strings such as "10s" and sizes may carry a unit such as "10MB". Every invalid field is reported, not just the first.
+func LoadHttpConfig(path string) (*HttpConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+
+	var raw any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var document any
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("error parsing config %s: %w", path, err)
+		}
+		if raw, err = normalizeYAMLValue(document, ""); err != nil {
+			return nil, fmt.Errorf("error parsing config %s: %w", path, err)
+		}
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("error parsing config %s: %w", path, err)
+		}
+	}
+
+	config := NewDefaultHttpConfig()
+	if err := decodeConfigValue(reflect.ValueOf(config).Elem(), raw, ""); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// normalizeYAMLValue converts a decoded YAML document to the values a JSON decoder using numbers produces,
+// so both formats go through the same decoding
+func normalizeYAMLValue(value any, path string) (any, error) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			normalized, err := normalizeYAMLValue(item, joinConfigPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			value[key] = normalized
+		}
+		return value, nil
+	case map[any]any:
+		return nil, fmt.Errorf("%s: keys must be strings", path)
+	case []any:
+		for i, item := range value {
+			normalized, err := normalizeYAMLValue(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			value[i] = normalized
+		}
+		return value, nil
+	case int:
+		return json.Number(strconv.Itoa(value)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(value, 10)), nil
+	case float64:
+		return json.Number(strconv.FormatFloat(value, 'g', -1, 64)), nil
+	case time.Time:
+		// unquoted timestamps are resolved by YAML, config fields holding them are strings
+		return value.Format(time.RFC3339Nano), nil
+	default:
+		return value, nil
+	}
+}
+
+var (
+	durationType = reflect.TypeFor[time.Duration]()
+	byteSizeType = reflect.TypeFor[ByteSize]()
+)
+
+// decodeConfigValue stores a generically decoded JSON value in v, path names the value in errors.
+// Objects and arrays are decoded completely, the errors of all their invalid entries are joined.
+func decodeConfigValue(v reflect.Value, raw any, path string) error {
+	switch v.Type() {
+	case durationType:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected %s", path, durationFormats)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q, expected %s", path, s, durationFormats)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case byteSizeType:
+		var s string
+		switch raw := raw.(type) {
+		case string:
+			s = raw
+		case json.Number:
+			s = raw.String()
+		default:
+			return fmt.Errorf("%s: expected %s", path, byteSizeFormats)
+		}
+		size, err := ParseByteSize(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetInt(int64(size))
+		return nil
+	}
+
+	var errs []error
+	switch v.Kind() {
+	case reflect.Pointer:
+		// null keeps an optional value unset
+		if raw == nil {
+			v.SetZero()
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := decodeConfigValue(elem.Elem(), raw, path); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		fields, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		for _, key := range sortedKeys(fields) {
+			if strings.HasPrefix(key, ConfigCommentKey) {
+				continue
+			}
+			field := v.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) })
+			if !field.IsValid() || !field.CanSet() {
+				errs = append(errs, fmt.Errorf("%s: unknown field", joinConfigPath(path, key)))
+				continue
+			}
+			if err := decodeConfigValue(field, fields[key], joinConfigPath(path, key)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	case reflect.Map:
+		entries, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(entries))
+		for _, key := range sortedKeys(entries) {
+			entry := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeConfigValue(entry, entries[key], joinConfigPath(path, key)); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			m.SetMapIndex(reflect.ValueOf(key), entry)
+		}
+		v.Set(m)
+	case reflect.Slice:
+		items, ok := raw.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeConfigValue(s.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		v.Set(s)
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return fmt.Errorf("%s: expected true or false", path)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+		i, err := n.Int64()
+		if err != nil || v.OverflowInt(i) {
+			return fmt.Errorf("%s: expected an integer, got %s", path, n)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a non-negative integer", path)
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		if err != nil || v.OverflowUint(u) {
+			return fmt.Errorf("%s: expected a non-negative integer, got %s", path, n)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a number", path)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: expected a number, got %s", path, n)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("%s: unsupported config type %s", path, v.Type())
+	}
+
+	return errors.Join(errs...)
+}
+
+// sortedKeys orders the keys of an object so errors are reported in a stable order
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
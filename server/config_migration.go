@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ConfigCommentKey holds notes in a config file, LoadHttpConfig ignores keys starting with it
+const ConfigCommentKey = "//"
+
+var backendPoolConfigType = reflect.TypeFor[BackendPoolConfig]()
+
+// MigrateHttpConfig rewrites a config file written for an older release in the current format.
+// The notes describe every change whose semantics differ, they are also stored in the output under ConfigCommentKey.
+func MigrateHttpConfig(data []byte) ([]byte, []string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	var notes []string
+	migrated := migrateConfigValue(reflect.TypeFor[HttpConfig](), raw, "", &notes)
+
+	// the result must load, otherwise it needs a migration this release does not know about
+	if err := decodeConfigValue(reflect.ValueOf(NewDefaultHttpConfig()).Elem(), migrated, ""); err != nil {
+		return nil, nil, fmt.Errorf("migrated config is still invalid: %w", err)
+	}
+
+	if fields, ok := migrated.(map[string]any); ok && len(notes) > 0 {
+		fields[ConfigCommentKey] = notes
+	}
+
+	out, err := json.MarshalIndent(migrated, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding config: %w", err)
+	}
+
+	return append(out, '\n'), notes, nil
+}
+
+// migrateConfigValue returns raw in the current format of type t, unknown fields are kept for decoding to report them
+func migrateConfigValue(t reflect.Type, raw any, path string, notes *[]string) any {
+	if t == durationType {
+		// durations used to be encoded by encoding/json as nanoseconds
+		if n, ok := raw.(json.Number); ok {
+			if ns, err := n.Int64(); err == nil {
+				d := time.Duration(ns).String()
+				*notes = append(*notes, fmt.Sprintf("%s: durations are strings now, %s nanoseconds became %q", path, n, d))
+				return d
+			}
+		}
+		return raw
+	}
+
+	if t == backendPoolConfigType {
+		// pools used to be plain server lists
+		if servers, ok := raw.([]any); ok {
+			*notes = append(*notes, fmt.Sprintf("%s: pools are objects now, the server list moved to Servers, ResponseValidation and ErrorBudget of the default pool do not apply to it", path))
+			raw = map[string]any{"Servers": servers}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields, ok := raw.(map[string]any)
+		if !ok {
+			return raw
+		}
+		for key, value := range fields {
+			if field, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) }); ok {
+				fields[key] = migrateConfigValue(field.Type, value, joinConfigPath(path, key), notes)
+			}
+		}
+	case reflect.Map:
+		entries, ok := raw.(map[string]any)
+		if !ok {
+			return raw
+		}
+		for key, value := range entries {
+			entries[key] = migrateConfigValue(t.Elem(), value, joinConfigPath(path, key), notes)
+		}
+	case reflect.Slice:
+		items, ok := raw.([]any)
+		if !ok {
+			return raw
+		}
+		for i, item := range items {
+			items[i] = migrateConfigValue(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), notes)
+		}
+	}
+
+	return raw
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ConfigEnvPrefix starts the names of environment variables overriding config fields, e.g. BALANCER_PORT sets Port and
+// BALANCER_BALANCING_MODE sets Balancing.Mode
+const ConfigEnvPrefix = "BALANCER_"
+
+// configEnvAliases are shorter environment variable names of config fields
+var configEnvAliases = map[string]string{
+	ConfigEnvPrefix + "STRATEGY": "Balancing.Mode",
+}
+
+// configField is a config field settable from the environment or a flag
+type configField struct {
+	path string // field names joined by dots as in errors of config files
+	env  string
+	flag string // lowercase words joined by dashes, nested fields joined by dots, e.g. balancing.hash-key.source
+	typ  reflect.Type
+}
+
+var configFields = collectConfigFields(reflect.TypeFor[HttpConfig](), "", "", "")
+
+// collectConfigFields lists the fields of a config struct, nested structs are walked while maps, slices and values with
+// their own format are set as a whole
+func collectConfigFields(t reflect.Type, path string, env string, flagName string) []configField {
+	var fields []configField
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		words := splitConfigWords(field.Name)
+		f := configField{
+			path: joinConfigPath(path, field.Name),
+			env:  env + strings.ToUpper(strings.Join(words, "_")),
+			flag: strings.ToLower(strings.Join(words, "-")),
+			typ:  field.Type,
+		}
+		if flagName != "" {
+			f.env = env + "_" + strings.ToUpper(strings.Join(words, "_"))
+			f.flag = flagName + "." + f.flag
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType && field.Type != byteSizeType {
+			fields = append(fields, collectConfigFields(field.Type, f.path, f.env, f.flag)...)
+			continue
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// splitConfigWords splits a field name into its words, abbreviations such as TLS in UpstreamTLS stay whole
+func splitConfigWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if !unicode.IsUpper(runes[i]) {
+			continue
+		}
+		if unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1]) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	return append(words, string(runes[start:]))
+}
+
+// EnvConfigOverrides picks the config fields set by environment variables from environ, as returned by os.Environ,
+// keyed by field path. Unknown variables starting with ConfigEnvPrefix are refused as they are most likely typos.
+func EnvConfigOverrides(environ []string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, ConfigEnvPrefix) {
+			continue
+		}
+
+		path, ok := configEnvAliases[name]
+		if !ok {
+			i := slices.IndexFunc(configFields, func(f configField) bool { return ConfigEnvPrefix+f.env == name })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown config environment variable %s", name)
+			}
+			path = configFields[i].path
+		}
+		overrides[path] = value
+	}
+
+	return overrides, nil
+}
+
+// RegisterConfigFlags defines a flag for every config field on flags, the returned overrides are keyed by field path
+// and filled in as flags are parsed. Flags already defined by the command keep their meaning.
+func RegisterConfigFlags(flags *flag.FlagSet) map[string]string {
+	overrides := make(map[string]string)
+	for _, field := range configFields {
+		if flags.Lookup(field.flag) != nil {
+			continue
+		}
+		flags.Func(field.flag, fmt.Sprintf("overrides %s (%s), same as %s%s", field.path, field.typ, ConfigEnvPrefix, field.env), func(value string) error {
+			overrides[field.path] = value
+			return nil
+		})
+	}
+
+	return overrides
+}
+
+// ApplyConfigOverrides sets config fields keyed by field path from their text, lists are comma-separated unless given
+// as JSON and maps or lists of objects are JSON as in config files
+func ApplyConfigOverrides(config *HttpConfig, overrides map[string]string) error {
+	paths := make([]string, 0, len(overrides))
+	for path := range overrides {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		field := reflect.ValueOf(config).Elem()
+		for _, name := range strings.Split(path, ".") {
+			field = field.FieldByName(name)
+			if !field.IsValid() {
+				return fmt.Errorf("%s: unknown field", path)
+			}
+		}
+
+		raw, err := parseConfigOverride(field.Type(), overrides[path])
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := decodeConfigValue(field, raw, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseConfigOverride turns the text of an override into the value a config file would hold
+func parseConfigOverride(t reflect.Type, value string) (any, error) {
+	if t == durationType || t == byteSizeType {
+		return value, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", value)
+		}
+		return b, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return json.Number(strings.TrimSpace(value)), nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			items := make([]any, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("expected JSON: %w", err)
+	}
+
+	return raw, nil
+}
+
+// LoadConfig overlays the defaults with the config file if any, then with the environment variables in environ and then
+// with flag overrides, as filled in by RegisterConfigFlags
+func LoadConfig(path string, environ []string, flagOverrides map[string]string) (*HttpConfig, error) {
+	config := NewDefaultHttpConfig()
+	if path != "" {
+		var err error
+		if config, err = LoadHttpConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	envOverrides, err := EnvConfigOverrides(environ)
+	if err != nil {
+		return nil, err
+	}
+	if err := ApplyConfigOverrides(config, envOverrides); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
+	if err := ApplyConfigOverrides(config, flagOverrides); err != nil {
+		return nil, fmt.Errorf("invalid flag: %w", err)
+	}
+
+	return config, nil
+}
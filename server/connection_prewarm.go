@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prewarmTimeout bounds a round of prewarming a server
+const prewarmTimeout = 5 * time.Second
+
+// ConnectionPrewarmConfig keeps connections to every backend of a pool open so the first requests after idle periods
+// or a backend recovery skip the TCP and TLS handshakes, TLS sessions are cached for resumption either way
+type ConnectionPrewarmConfig struct {
+	Connections         int           // kept open per backend, 0 disables prewarming
+	Interval            time.Duration // how often the connections are refreshed, must stay below the idle timeout of backends
+	TLSSessionCacheSize int           // TLS sessions kept for resumption across the backends of a pool, 0 uses the Go default
+}
+
+// ConnectionStats shows how often proxied requests reused a connection and how often TLS handshakes resumed a session
+type ConnectionStats struct {
+	NewConnections    uint64  `json:"newConnections"`
+	ReusedConnections uint64  `json:"reusedConnections"`
+	ReuseRate         float64 `json:"reuseRate"`
+	TLSHandshakes     uint64  `json:"tlsHandshakes"`
+	TLSResumed        uint64  `json:"tlsResumed"`
+	TLSResumptionRate float64 `json:"tlsResumptionRate"`
+	Prewarms          uint64  `json:"prewarms"` // connections opened or refreshed by prewarming
+}
+
+// connectionStats counts connection usage of proxied requests, prewarming is not counted as usage
+type connectionStats struct {
+	newConnections    atomic.Uint64
+	reusedConnections atomic.Uint64
+	tlsHandshakes     atomic.Uint64
+	tlsResumed        atomic.Uint64
+	prewarms          atomic.Uint64
+}
+
+func (s *connectionStats) snapshot() ConnectionStats {
+	stats := ConnectionStats{
+		NewConnections:    s.newConnections.Load(),
+		ReusedConnections: s.reusedConnections.Load(),
+		TLSHandshakes:     s.tlsHandshakes.Load(),
+		TLSResumed:        s.tlsResumed.Load(),
+		Prewarms:          s.prewarms.Load(),
+	}
+	if total := stats.NewConnections + stats.ReusedConnections; total > 0 {
+		stats.ReuseRate = float64(stats.ReusedConnections) / float64(total)
+	}
+	if stats.TLSHandshakes > 0 {
+		stats.TLSResumptionRate = float64(stats.TLSResumed) / float64(stats.TLSHandshakes)
+	}
+
+	return stats
+}
+
+// newPoolTransport returns a transport of its own for a pool, based on the backend auth transport if there is one,
+// keeping enough idle connections for prewarming and caching TLS sessions
+func newPoolTransport(base http.RoundTripper, config ConnectionPrewarmConfig) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+
+	transport = transport.Clone()
+	transport.MaxIdleConnsPerHost = max(transport.MaxIdleConnsPerHost, http.DefaultMaxIdleConnsPerHost, config.Connections)
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(config.TLSSessionCacheSize)
+
+	return transport
+}
+
+// tracedTransport counts connection reuse and TLS session resumption of the requests it carries
+type tracedTransport struct {
+	stats *connectionStats
+	trace *httptrace.ClientTrace
+	next  http.RoundTripper
+}
+
+func newTracedTransport(stats *connectionStats, next http.RoundTripper) *tracedTransport {
+	return &tracedTransport{
+		stats: stats,
+		next:  next,
+		trace: &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					stats.reusedConnections.Add(1)
+				} else {
+					stats.newConnections.Add(1)
+				}
+			},
+			TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+				if err != nil {
+					return
+				}
+				stats.tlsHandshakes.Add(1)
+				if state.DidResume {
+					stats.tlsResumed.Add(1)
+				}
+			},
+		},
+	}
+}
+
+func (t *tracedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), t.trace)))
+}
+
+// prewarm opens or refreshes the configured number of connections to a server by sending that many HEAD requests at once
+func (p *ProxyServerPool) prewarm(s *server) {
+	if p.prewarmConfig.Connections <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, prewarmTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for range p.prewarmConfig.Connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url.String(), nil)
+			if err != nil {
+				return
+			}
+			p.backendAuth.apply(req)
+			resp, err := s.baseTransport.RoundTrip(req)
+			if err != nil {
+				logDebug("Prewarming connection failed", "backend", s.url.String(), "error", err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			p.connectionStats.prewarms.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	logDebug("Prewarmed connections", "backend", s.url.String(), "connections", p.prewarmConfig.Connections)
+}
+
+// startPrewarming keeps the connections to a server warm until the pool stops or the server leaves it
+func (p *ProxyServerPool) startPrewarming(s *server) {
+	if p.prewarmConfig.Connections <= 0 || p.prewarmConfig.Interval <= 0 {
+		return
+	}
+
+	p.background.Go(func() {
+		ticker := time.NewTicker(p.prewarmConfig.Interval)
+		defer ticker.Stop()
+
+		for {
+			if !slices.Contains(*p.servers.Load(), s) {
+				return
+			}
+			if s.IsAlive() {
+				p.prewarm(s)
+			}
+
+			select {
+			case <-p.ctx.Done():
+				slog.Info("Prewarming stopped", "backend", s.url.String())
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maglevTableSize is the number of maglev lookup table entries, a prime far above the number of backends so each
+// backend owns close to its share of entries
+const maglevTableSize = 65537
+
+// hashRing maps keys to backends so that a key keeps its backend while the set of backends changes only slightly.
+// In maglev mode the ring is a lookup table instead, which spreads keys more evenly at the cost of moving a few more.
+type hashRing struct {
+	hash    func(key string) uint64
+	points  []uint64
+	servers []*server // servers[i] owns points[i]
+	table   []*server // maglev lookup table
+}
+
+type hashRingPoint struct {
+	hash   uint64
+	server *server
+}
+
+func newHashRing(servers []*server, virtualNodes int, function string) *hashRing {
+	switch function {
+	case HashFunctionMaglev:
+		return &hashRing{hash: xxhashKey, table: newMaglevTable(servers)}
+	case HashFunctionXXHash:
+		return newRing(servers, virtualNodes, xxhashKey)
+	case HashFunctionFNV:
+		return newRing(servers, virtualNodes, fnvKey)
+	default:
+		return newRing(servers, virtualNodes, hashKey)
+	}
+}
+
+func newRing(servers []*server, virtualNodes int, hash func(string) uint64) *hashRing {
+	points := make([]hashRingPoint, 0, len(servers)*virtualNodes)
+	for _, server := range servers {
+		for i := range virtualNodes * int(max(server.weight.Load(), 1)) {
+			points = append(points, hashRingPoint{hash: hash(server.url.String() + "#" + strconv.Itoa(i)), server: server})
+		}
+	}
+	slices.SortFunc(points, func(a, b hashRingPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+
+	ring := &hashRing{hash: hash, points: make([]uint64, len(points)), servers: make([]*server, len(points))}
+	for i, point := range points {
+		ring.points[i] = point.hash
+		ring.servers[i] = point.server
+	}
+
+	return ring
+}
+
+// newMaglevTable fills the lookup table as described in the Maglev paper, every backend takes turns claiming the next
+// free entry of its own permutation of the table, a backend takes as many turns per round as its weight
+func newMaglevTable(servers []*server) []*server {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	offsets := make([]uint64, len(servers))
+	skips := make([]uint64, len(servers))
+	next := make([]uint64, len(servers))
+	for i, server := range servers {
+		name := server.url.String()
+		offsets[i] = xxhashKey(name) % maglevTableSize
+		skips[i] = xxhashKey(name+"#skip")%(maglevTableSize-1) + 1
+	}
+
+	table := make([]*server, maglevTableSize)
+	filled := 0
+	for {
+		for i, server := range servers {
+			for range max(server.weight.Load(), 1) {
+				entry := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				for table[entry] != nil {
+					next[i]++
+					entry = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				}
+				table[entry] = server
+				next[i]++
+				if filled++; filled == maglevTableSize {
+					return table
+				}
+			}
+		}
+	}
+}
+
+// get returns the owner of the first ring point at or after the hash of key or its maglev entry, nil if the ring is empty
+func (r *hashRing) get(key string) *server {
+	if r.table != nil {
+		return r.table[r.hash(key)%maglevTableSize]
+	}
+	if len(r.points) == 0 {
+		return nil
+	}
+
+	i, _ := slices.BinarySearch(r.points, r.hash(key))
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.servers[i]
+}
+
+// hashKey hashes with FNV-1a followed by a 64 bit finalizer, FNV alone clusters similar keys such as "url#1" and "url#2"
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// fnvKey is plain FNV-1a as used by other proxies
+func fnvKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func xxhashKey(key string) uint64 {
+	return xxhash64([]byte(key))
+}
+
+// requestHashKey returns the attribute identifying the client of the request, empty if the request lacks it
+func requestHashKey(r *http.Request, config HashKeyConfig) string {
+	switch config.Source {
+	case HashKeyHeader:
+		return r.Header.Get(config.Name)
+	case HashKeyCookie:
+		if cookie, err := r.Cookie(config.Name); err == nil {
+			return cookie.Value
+		}
+		return ""
+	case HashKeyPath:
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if config.Segment < len(segments) {
+			return segments[config.Segment]
+		}
+		return ""
+	default:
+		if addr := clientAddr(r); addr.IsValid() {
+			return addr.String()
+		}
+		return ""
+	}
+}
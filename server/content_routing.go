@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxContentRoutingBodySize bounds the body inspected by content routes, larger bodies are routed as if no route matched
+const maxContentRoutingBodySize = 1 << 20
+
+// ContentRouteConfig routes requests with a JSON body whose field equals Value to Pool, e.g. "type": "video" to a GPU pool.
+// Field is a dot separated path into nested objects such as "job.type", numbers and booleans compare by their JSON text.
+type ContentRouteConfig struct {
+	Field string
+	Value string
+	Pool  string
+}
+
+// contentRoute is a content route resolved to its pool
+type contentRoute struct {
+	path  []string
+	value string
+	pool  ServerPool
+	name  string
+}
+
+func newContentRoutes(configs []ContentRouteConfig, pools map[string]ServerPool) ([]contentRoute, error) {
+	routes := make([]contentRoute, 0, len(configs))
+	for _, config := range configs {
+		if config.Field == "" {
+			return nil, errors.New("content route requires a field")
+		}
+		pool, ok := pools[config.Pool]
+		if !ok {
+			return nil, fmt.Errorf("content route on %s: %w: %s", config.Field, ErrUnknownPool, config.Pool)
+		}
+		routes = append(routes, contentRoute{path: strings.Split(config.Field, "."), value: config.Value, pool: pool, name: config.Pool})
+	}
+
+	return routes, nil
+}
+
+// matchContentRoute returns the first route matching the JSON body of the request, the body is restored for the backend
+func matchContentRoute(r *http.Request, routes []contentRoute) (contentRoute, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return contentRoute{}, false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return contentRoute{}, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxContentRoutingBodySize+1))
+	if len(body) > maxContentRoutingBodySize || err != nil {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return contentRoute{}, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return contentRoute{}, false
+	}
+
+	for _, route := range routes {
+		if value, ok := jsonField(document, route.path); ok && value == route.value {
+			return route, true
+		}
+	}
+
+	return contentRoute{}, false
+}
+
+// jsonField returns the scalar at path formatted as text, objects and arrays do not match
+func jsonField(document any, path []string) (string, bool) {
+	for _, key := range path {
+		fields, ok := document.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if document, ok = fields[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch value := document.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return fmt.Sprint(value), true
+	case nil:
+		return "null", true
+	default:
+		return "", false
+	}
+}
+
+// readCloser reads from a reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard/index.html
+var dashboardPage []byte
+
+// dashboardEventInterval is how often the dashboard receives a new state
+const dashboardEventInterval = time.Second
+
+type dashboardState struct {
+	MaxCapacity       int             `json:"maxCapacity"`
+	AvailableCapacity int             `json:"availableCapacity"`
+	Waiting           int             `json:"waiting"`
+	RequestRate       float64         `json:"requestRate"`
+	Backends          []BackendStatus `json:"backends"`
+	RecentErrors      []ProxyError    `json:"recentErrors"`
+}
+
+// dashboardHandler serves the embedded dashboard page
+func dashboardHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(dashboardPage)
+	}
+}
+
+// dashboardEventsHandler streams the pool state to the dashboard as server-sent events until the client leaves or shuttingDown is closed.
+// With the deltas query parameter the first state is followed by "patch" events carrying RFC 6902 JSON Patches against the previous state,
+// a full state is sent instead whenever it is smaller than the patch.
+func dashboardEventsHandler(proxyServerPool ServerPool, shuttingDown <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		deltas := r.URL.Query().Has("deltas")
+		var previous any
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		ticker := time.NewTicker(dashboardEventInterval)
+		defer ticker.Stop()
+
+		lastRequests, lastTick := proxyServerPool.GetRequests(), time.Now()
+		for {
+			requests, now := proxyServerPool.GetRequests(), time.Now()
+			state := dashboardState{
+				MaxCapacity:       proxyServerPool.GetMaxCapacity(),
+				AvailableCapacity: proxyServerPool.GetAvailableCapacity(),
+				Waiting:           proxyServerPool.GetWaiting(),
+				Backends:          proxyServerPool.Backends(),
+				RecentErrors:      proxyServerPool.RecentErrors(),
+			}
+			if elapsed := now.Sub(lastTick).Seconds(); elapsed > 0 {
+				state.RequestRate = float64(requests-lastRequests) / elapsed
+			}
+			lastRequests, lastTick = requests, now
+
+			data, err := json.Marshal(state)
+			if err != nil {
+				return
+			}
+			event := ""
+			if deltas {
+				event, data, previous, err = dashboardDelta(previous, data)
+				if err != nil {
+					return
+				}
+			}
+			if event != "" {
+				fmt.Fprintf(w, "event: %s\n", event)
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shuttingDown:
+				fmt.Fprintf(w, "event: shutdown\ndata: {\"status\": %q}\n\n", BalancerStatusShuttingDown)
+				rc.Flush()
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// dashboardDelta returns the patch event turning previous into the encoded state, or no event name and the state itself if that is smaller.
+// The decoded state is returned to diff the next state against.
+func dashboardDelta(previous any, state []byte) (string, []byte, any, error) {
+	var current any
+	if err := json.Unmarshal(state, &current); err != nil {
+		return "", nil, nil, err
+	}
+	if previous == nil {
+		return "", state, current, nil
+	}
+
+	patch, err := json.Marshal(diffJSON([]jsonPatchOperation{}, "", previous, current))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if len(patch) >= len(state) {
+		return "", state, current, nil
+	}
+
+	return "patch", patch, current, nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/javor454/balancer/lifecycle"
+)
+
+type diagnosticsResponse struct {
+	Runtime            RuntimeSettings   `json:"runtime"`
+	Memory             MemoryStats       `json:"memory"`
+	Goroutines         int               `json:"goroutines"`
+	ShedRequests       uint64            `json:"shedRequests"`
+	VerboseLogging     bool              `json:"verboseLogging"`
+	Maintenance        bool              `json:"maintenance"`
+	ReadOnly           bool              `json:"readOnly"`
+	HealthChecksPaused bool              `json:"healthChecksPaused"`
+	BackgroundPanics   int64             `json:"backgroundPanics"`
+	DroppedLogLines    int64             `json:"droppedLogLines"`
+	ResponseViolations uint64            `json:"responseViolations"`
+	Connections        ConnectionStats   `json:"connections"`
+	AdmissionDecisions map[string]uint64 `json:"admissionDecisions"`
+	Mirror             MirrorStats       `json:"mirror"`
+}
+
+func diagnosticsHandler(proxyServerPool ServerPool, registerHandler *RegisterHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, diagnosticsResponse{
+			Runtime:            CurrentRuntimeSettings(),
+			Memory:             CurrentMemoryStats(),
+			Goroutines:         runtime.NumGoroutine(),
+			ShedRequests:       ShedRequests(),
+			VerboseLogging:     VerboseLogging(),
+			Maintenance:        Maintenance(),
+			ReadOnly:           ReadOnly(),
+			HealthChecksPaused: proxyServerPool.HealthChecksPaused(),
+			BackgroundPanics:   lifecycle.Panics(),
+			DroppedLogLines:    DroppedLogLines(),
+			ResponseViolations: proxyServerPool.GetResponseViolations(),
+			Connections:        proxyServerPool.ConnectionStats(),
+			AdmissionDecisions: registerHandler.admission.Decisions(),
+			Mirror:             MirroredRequests(),
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errorBudgetBuckets is the resolution of the rolling window
+const errorBudgetBuckets = 60
+
+// ErrorBudgetConfig tracks an SLO error budget of a pool, zero TargetSuccessRate disables tracking.
+// The burn rate is the error rate divided by the allowed error rate, 1 spends the budget exactly over the window.
+type ErrorBudgetConfig struct {
+	TargetSuccessRate float64 // e.g. 0.999
+	Window            time.Duration
+	AlertBurnRate     float64 // alert once the burn rate reaches it, 0 disables alerts
+	AlertWebhook      string  // URL receiving a JSON ErrorBudgetStatus when alerting
+	MinRequests       uint64  // burn rates of fewer requests in the window are not alerted on
+}
+
+// ErrorBudgetStatus is the error budget of a pool over the rolling window
+type ErrorBudgetStatus struct {
+	Pool              string  `json:"pool"`
+	TargetSuccessRate float64 `json:"targetSuccessRate"`
+	Window            string  `json:"window"`
+	Requests          uint64  `json:"requests"`
+	Errors            uint64  `json:"errors"`
+	BurnRate          float64 `json:"burnRate"`
+	BudgetRemaining   float64 `json:"budgetRemaining"` // fraction of the budget left, negative once exhausted
+}
+
+// errorBudget counts proxied requests and server errors in a rolling window of buckets
+type errorBudget struct {
+	pool        string
+	config      ErrorBudgetConfig
+	bucketWidth time.Duration
+	mu          sync.Mutex
+	buckets     [errorBudgetBuckets]errorBudgetBucket
+	lastAlert   time.Time
+}
+
+type errorBudgetBucket struct {
+	epoch    int64 // bucket index since the unix epoch, stale buckets are reset on use
+	requests uint64
+	errors   uint64
+}
+
+func newErrorBudget(pool string, config ErrorBudgetConfig) *errorBudget {
+	if config.TargetSuccessRate <= 0 || config.TargetSuccessRate >= 1 {
+		return nil
+	}
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+
+	return &errorBudget{
+		pool:        pool,
+		config:      config,
+		bucketWidth: max(config.Window/errorBudgetBuckets, time.Millisecond),
+	}
+}
+
+// record counts a proxied request, failed covers 5xx responses and transport errors
+func (b *errorBudget) record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	epoch := time.Now().UnixNano() / int64(b.bucketWidth)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bucket := &b.buckets[epoch%errorBudgetBuckets]
+	if bucket.epoch != epoch {
+		*bucket = errorBudgetBucket{epoch: epoch}
+	}
+	bucket.requests++
+	if failed {
+		bucket.errors++
+	}
+}
+
+func (b *errorBudget) status() ErrorBudgetStatus {
+	epoch := time.Now().UnixNano() / int64(b.bucketWidth)
+	status := ErrorBudgetStatus{
+		Pool:              b.pool,
+		TargetSuccessRate: b.config.TargetSuccessRate,
+		Window:            b.config.Window.String(),
+		BudgetRemaining:   1,
+	}
+
+	b.mu.Lock()
+	for _, bucket := range b.buckets {
+		if epoch-bucket.epoch < errorBudgetBuckets {
+			status.Requests += bucket.requests
+			status.Errors += bucket.errors
+		}
+	}
+	b.mu.Unlock()
+
+	if status.Requests > 0 {
+		allowedErrorRate := 1 - b.config.TargetSuccessRate
+		status.BurnRate = float64(status.Errors) / float64(status.Requests) / allowedErrorRate
+		status.BudgetRemaining = 1 - float64(status.Errors)/(float64(status.Requests)*allowedErrorRate)
+	}
+
+	return status
+}
+
+// watch alerts when the budget burns too fast, at most once per window
+func (b *errorBudget) watch(ctx context.Context, httpClient *http.Client) {
+	ticker := time.NewTicker(b.bucketWidth)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := b.status()
+			if status.BurnRate < b.config.AlertBurnRate || status.Requests < b.config.MinRequests || time.Since(b.lastAlert) < b.config.Window {
+				continue
+			}
+			b.lastAlert = time.Now()
+
+			slog.Warn("Error budget burning", "pool", b.pool, "burnRate", status.BurnRate, "errors", status.Errors, "requests", status.Requests)
+			if b.config.AlertWebhook != "" {
+				b.sendAlert(ctx, httpClient, status)
+			}
+		}
+	}
+}
+
+func (b *errorBudget) sendAlert(ctx context.Context, httpClient *http.Client, status ErrorBudgetStatus) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		slog.Error("Failed to encode error budget alert", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.AlertWebhook, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to create error budget alert", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		slog.Error("Failed to send error budget alert", "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Warn("Error budget alert webhook failed", "status", resp.StatusCode)
+	}
+}
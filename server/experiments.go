@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+)
+
+// experimentCookieMaxAge keeps anonymous visitors in the same variant for 30 days
+const experimentCookieMaxAge = 30 * 24 * 60 * 60
+
+// VariantConfig is a variant of an experiment, requests in the variant are routed to Pool if set
+type VariantConfig struct {
+	Name   string
+	Weight int
+	Pool   string
+}
+
+// ExperimentConfig defines an A/B experiment, clients are assigned a variant proportionally to variant weights
+type ExperimentConfig struct {
+	Name     string
+	Variants []VariantConfig
+}
+
+// Experiments assigns sticky variants of all configured experiments
+type Experiments struct {
+	experiments []experiment
+}
+
+type experiment struct {
+	ExperimentConfig
+	totalWeight int
+}
+
+type experimentsKey struct{}
+
+// NewExperiments validates experiment definitions
+func NewExperiments(configs []ExperimentConfig) (*Experiments, error) {
+	e := &Experiments{experiments: make([]experiment, 0, len(configs))}
+	names := make(map[string]struct{}, len(configs))
+
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("experiment name is required")
+		}
+		if _, ok := names[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate experiment %s", config.Name)
+		}
+		names[config.Name] = struct{}{}
+
+		if len(config.Variants) == 0 {
+			return nil, fmt.Errorf("experiment %s has no variants", config.Name)
+		}
+
+		totalWeight := 0
+		for _, variant := range config.Variants {
+			if variant.Weight < 1 {
+				return nil, fmt.Errorf("experiment %s variant %s must have a positive weight", config.Name, variant.Name)
+			}
+			totalWeight += variant.Weight
+		}
+
+		e.experiments = append(e.experiments, experiment{ExperimentConfig: config, totalWeight: totalWeight})
+	}
+
+	return e, nil
+}
+
+// WithExperiments assigns variants to every request and stores them in the request context.
+// Registered clients are bucketed by their Authorization header, anonymous ones get a sticky cookie.
+func WithExperiments(experiments *Experiments) Middleware {
+	return func(next http.Handler) http.Handler {
+		if experiments == nil || len(experiments.experiments) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				clientID := requestClientName(r)
+				assignments := make(map[string]string, len(experiments.experiments))
+
+				for _, e := range experiments.experiments {
+					variant := e.assign(w, r, clientID)
+					assignments[e.Name] = variant.Name
+				}
+
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), experimentsKey{}, assignments)))
+			},
+		)
+	}
+}
+
+// ExperimentVariants returns experiment name to variant assignments of the request
+func ExperimentVariants(ctx context.Context) map[string]string {
+	assignments, _ := ctx.Value(experimentsKey{}).(map[string]string)
+	return assignments
+}
+
+func (e *experiment) assign(w http.ResponseWriter, r *http.Request, clientID string) VariantConfig {
+	if clientID != "" {
+		h := fnv.New32a()
+		h.Write([]byte(e.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(clientID))
+		return e.variantAt(int(h.Sum32() % uint32(e.totalWeight)))
+	}
+
+	cookieName := "balancer_exp_" + e.Name
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		for _, variant := range e.Variants {
+			if variant.Name == cookie.Value {
+				return variant
+			}
+		}
+	}
+
+	variant := e.variantAt(rand.IntN(e.totalWeight))
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    variant.Name,
+		Path:     "/",
+		MaxAge:   experimentCookieMaxAge,
+		HttpOnly: true,
+	})
+
+	return variant
+}
+
+// variantAt maps a point in [0, totalWeight) to a variant
+func (e *experiment) variantAt(point int) VariantConfig {
+	for _, variant := range e.Variants {
+		if point < variant.Weight {
+			return variant
+		}
+		point -= variant.Weight
+	}
+
+	return e.Variants[len(e.Variants)-1]
+}
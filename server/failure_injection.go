@@ -0,0 +1,98 @@
+package server
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	BalancerStatusInjectedFailure = "injected-failure"
+
+	FailureLatency = "latency"
+	FailureError   = "error"
+	FailureDrop    = "drop"
+)
+
+// FailureInjectionConfig makes the balancer itself misbehave so downstream teams can test their resilience, the zero value injects nothing.
+// Rates are fractions of eligible requests. With Header set only requests carrying it are eligible,
+// and a header value naming a failure (latency, error or drop) injects that failure regardless of the rates.
+type FailureInjectionConfig struct {
+	Header      string
+	Latency     time.Duration
+	LatencyRate float64
+	ErrorRate   float64 // answered with 500
+	DropRate    float64 // connection closed without a response
+}
+
+func (c FailureInjectionConfig) enabled() bool {
+	return c.Header != "" || c.LatencyRate > 0 || c.ErrorRate > 0 || c.DropRate > 0
+}
+
+// WithFailureInjection injects latency, errors and dropped connections as configured, the scoping header is not forwarded to backends
+func WithFailureInjection(config FailureInjectionConfig) Middleware {
+	if !config.enabled() {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				forced := ""
+				if config.Header != "" {
+					values, ok := r.Header[http.CanonicalHeaderKey(config.Header)]
+					if !ok {
+						next.ServeHTTP(w, r)
+						return
+					}
+					if len(values) > 0 {
+						forced = values[0]
+					}
+					r.Header.Del(config.Header)
+				}
+
+				if forced == FailureLatency || (forced == "" && rand.Float64() < config.LatencyRate) {
+					logDebugContext(r.Context(), "Injecting latency", "latency", config.Latency, "method", r.Method, "path", r.URL.Path)
+					select {
+					case <-time.After(config.Latency):
+					case <-r.Context().Done():
+						return
+					}
+				}
+
+				failure := forced
+				if failure == "" {
+					switch roll := rand.Float64(); {
+					case roll < config.DropRate:
+						failure = FailureDrop
+					case roll < config.DropRate+config.ErrorRate:
+						failure = FailureError
+					}
+				}
+
+				switch failure {
+				case FailureDrop:
+					logDebugContext(r.Context(), "Injecting dropped connection", "method", r.Method, "path", r.URL.Path)
+					conn, _, err := http.NewResponseController(w).Hijack()
+					if err != nil {
+						slog.WarnContext(r.Context(), "Cannot drop connection, injecting an error instead", "error", err)
+						failInjected(w)
+						return
+					}
+					conn.Close()
+				case FailureError:
+					logDebugContext(r.Context(), "Injecting error", "method", r.Method, "path", r.URL.Path)
+					failInjected(w)
+				default:
+					next.ServeHTTP(w, r)
+				}
+			},
+		)
+	}
+}
+
+func failInjected(w http.ResponseWriter) {
+	w.Header().Set(BalancerStatusHeader, BalancerStatusInjectedFailure)
+	http.Error(w, "Injected failure", http.StatusInternalServerError)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// fairQueue limits concurrent requests and hands freed capacity to waiting requests by weighted fair queuing.
+// Each waiting request gets a virtual finish time advancing by 1/weight per request of its client,
+// the earliest finish time is served first so a client with twice the weight waits half as long under contention.
+type fairQueue struct {
+	mu          sync.Mutex
+	maxCapacity int
+	maxDepth    int // waiters beyond it are refused right away, 0 for no limit
+	inUse       int
+	virtualTime float64
+	lastFinish  map[string]float64 // latest finish time handed out per client
+	waiters     fairQueueWaiters
+	sequence    uint64 // breaks finish time ties in arrival order
+	contended   uint64 // requests which found no capacity and waited or were refused since start
+}
+
+type fairQueueWaiter struct {
+	finish   float64
+	sequence uint64
+	index    int
+	granted  bool
+	ready    chan struct{}
+	client   string
+	since    time.Time
+}
+
+func newFairQueue(maxCapacity int, maxDepth int) *fairQueue {
+	return &fairQueue{
+		maxCapacity: maxCapacity,
+		maxDepth:    maxDepth,
+		lastFinish:  make(map[string]float64),
+	}
+}
+
+// acquire takes a unit of capacity, waiting in the fair queue until it is granted or done is closed.
+// A full queue refuses the request with ErrQueueFull instead of letting it wait.
+func (q *fairQueue) acquire(ctx context.Context, client string, weight int, done <-chan struct{}) error {
+	q.mu.Lock()
+	if q.inUse < q.maxCapacity && len(q.waiters) == 0 {
+		q.inUse++
+		q.mu.Unlock()
+		return nil
+	}
+	q.contended++
+	if q.maxDepth > 0 && len(q.waiters) >= q.maxDepth {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	finish := max(q.virtualTime, q.lastFinish[client]) + 1/float64(max(weight, 1))
+	q.lastFinish[client] = finish
+	q.sequence++
+	waiter := &fairQueueWaiter{finish: finish, sequence: q.sequence, ready: make(chan struct{}), client: client, since: time.Now()}
+	heap.Push(&q.waiters, waiter)
+	q.mu.Unlock()
+
+	var err error
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-done:
+		err = ErrShuttingDown
+	case <-ctx.Done():
+		err = ErrNoCapacity
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if waiter.granted {
+		// capacity was granted while giving up, pass it on
+		q.releaseLocked()
+		return err
+	}
+	heap.Remove(&q.waiters, waiter.index)
+
+	return err
+}
+
+// release returns a unit of capacity, handing it directly to the next waiter if there is one
+func (q *fairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.releaseLocked()
+}
+
+func (q *fairQueue) releaseLocked() {
+	if len(q.waiters) == 0 {
+		// prevents going negative if release is called more times than acquire
+		q.inUse = max(q.inUse-1, 0)
+		// nobody waits so finish times of idle clients carry no information anymore
+		clear(q.lastFinish)
+		return
+	}
+	if q.inUse > q.maxCapacity {
+		// the capacity was lowered, waiters get their turn once usage fell below it
+		q.inUse--
+		return
+	}
+
+	waiter := heap.Pop(&q.waiters).(*fairQueueWaiter)
+	q.virtualTime = waiter.finish
+	waiter.granted = true
+	close(waiter.ready)
+}
+
+// available returns the capacity which is not in use
+func (q *fairQueue) available() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.maxCapacity - q.inUse
+}
+
+// capacity returns the current capacity
+func (q *fairQueue) capacity() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.maxCapacity
+}
+
+// setCapacity changes the capacity, raising it grants it to waiters right away, lowering it lets requests in flight finish
+func (q *fairQueue) setCapacity(maxCapacity int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.maxCapacity = maxCapacity
+	for q.inUse < q.maxCapacity && len(q.waiters) > 0 {
+		q.inUse++
+		q.releaseLocked()
+	}
+}
+
+// contentions returns the number of requests which found no capacity since start
+func (q *fairQueue) contentions() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.contended
+}
+
+// fairQueueWaiters is a min-heap of waiters ordered by finish time
+type fairQueueWaiters []*fairQueueWaiter
+
+func (h fairQueueWaiters) Len() int { return len(h) }
+
+func (h fairQueueWaiters) Less(i, j int) bool {
+	if h[i].finish != h[j].finish {
+		return h[i].finish < h[j].finish
+	}
+	return h[i].sequence < h[j].sequence
+}
+
+func (h fairQueueWaiters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fairQueueWaiters) Push(x any) {
+	waiter := x.(*fairQueueWaiter)
+	waiter.index = len(*h)
+	*h = append(*h, waiter)
+}
+
+func (h *fairQueueWaiters) Pop() any {
+	old := *h
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return waiter
+}
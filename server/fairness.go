@@ -0,0 +1,164 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// fairnessBuckets of fairnessBucketWidth make up the rolling window of fairness statistics
+	fairnessBuckets     = 60
+	fairnessBucketWidth = time.Second
+)
+
+// FairnessStats shows how evenly a pool shared capacity between clients and requests between backends over the rolling window.
+// The Gini coefficients are computed over counts divided by weight, 0 means every client or backend got exactly its weighted share
+// and values towards 1 mean a few of them got almost everything.
+type FairnessStats struct {
+	Pool        string          `json:"pool"`
+	Strategy    string          `json:"strategy"`
+	Window      string          `json:"window"`
+	Clients     []FairnessShare `json:"clients"`
+	ClientGini  float64         `json:"clientGini"`
+	Backends    []FairnessShare `json:"backends"`
+	BackendGini float64         `json:"backendGini"`
+	MaxWaitMs   float64         `json:"maxWaitMs"` // longest time a request waited for capacity
+}
+
+// FairnessShare is the number of requests admitted for a client or sent to a backend, Share is the fraction of all of them
+type FairnessShare struct {
+	Name   string  `json:"name"`
+	Weight int     `json:"weight"`
+	Count  uint64  `json:"count"`
+	Share  float64 `json:"share"`
+}
+
+// fairnessStats counts admitted clients and chosen backends in a rolling window of buckets
+type fairnessStats struct {
+	mu      sync.Mutex
+	buckets [fairnessBuckets]fairnessBucket
+}
+
+type fairnessBucket struct {
+	epoch    int64 // bucket index since the unix epoch, stale buckets are reset on use
+	clients  map[string]FairnessShare
+	backends map[string]FairnessShare
+	maxWait  time.Duration
+}
+
+// bucket returns the current bucket, the caller holds the lock
+func (s *fairnessStats) bucket() *fairnessBucket {
+	epoch := time.Now().UnixNano() / int64(fairnessBucketWidth)
+	bucket := &s.buckets[epoch%fairnessBuckets]
+	if bucket.epoch != epoch {
+		*bucket = fairnessBucket{epoch: epoch, clients: make(map[string]FairnessShare), backends: make(map[string]FairnessShare)}
+	}
+
+	return bucket
+}
+
+// recordAdmission counts capacity granted to a client after waiting for it, requests without a client count as an empty name
+func (s *fairnessStats) recordAdmission(client string, weight int, waited time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := s.bucket()
+	share := bucket.clients[client]
+	share.Name, share.Weight, share.Count = client, max(weight, 1), share.Count+1
+	bucket.clients[client] = share
+	bucket.maxWait = max(bucket.maxWait, waited)
+}
+
+// recordBackend counts a request sent to a backend
+func (s *fairnessStats) recordBackend(backend string, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := s.bucket()
+	share := bucket.backends[backend]
+	share.Name, share.Weight, share.Count = backend, max(weight, 1), share.Count+1
+	bucket.backends[backend] = share
+}
+
+func (s *fairnessStats) stats(pool string, strategy string) FairnessStats {
+	epoch := time.Now().UnixNano() / int64(fairnessBucketWidth)
+	clients := make(map[string]FairnessShare)
+	backends := make(map[string]FairnessShare)
+	var maxWait time.Duration
+
+	s.mu.Lock()
+	for _, bucket := range s.buckets {
+		if epoch-bucket.epoch >= fairnessBuckets {
+			continue
+		}
+		mergeFairnessShares(clients, bucket.clients)
+		mergeFairnessShares(backends, bucket.backends)
+		maxWait = max(maxWait, bucket.maxWait)
+	}
+	s.mu.Unlock()
+
+	stats := FairnessStats{
+		Pool:      pool,
+		Strategy:  strategy,
+		Window:    (fairnessBuckets * fairnessBucketWidth).String(),
+		Clients:   sortedFairnessShares(clients),
+		Backends:  sortedFairnessShares(backends),
+		MaxWaitMs: float64(maxWait.Microseconds()) / 1000,
+	}
+	stats.ClientGini = weightedGini(stats.Clients)
+	stats.BackendGini = weightedGini(stats.Backends)
+
+	return stats
+}
+
+// mergeFairnessShares adds the counts of from to into, the latest weight wins
+func mergeFairnessShares(into map[string]FairnessShare, from map[string]FairnessShare) {
+	for name, share := range from {
+		total := into[name]
+		total.Name, total.Weight, total.Count = name, share.Weight, total.Count+share.Count
+		into[name] = total
+	}
+}
+
+// sortedFairnessShares computes the shares and orders them from the largest
+func sortedFairnessShares(shares map[string]FairnessShare) []FairnessShare {
+	var total uint64
+	for _, share := range shares {
+		total += share.Count
+	}
+
+	sorted := make([]FairnessShare, 0, len(shares))
+	for _, share := range shares {
+		share.Share = float64(share.Count) / float64(total)
+		sorted = append(sorted, share)
+	}
+	slices.SortFunc(sorted, func(a, b FairnessShare) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+
+	return sorted
+}
+
+// weightedGini returns the Gini coefficient of counts per unit of weight
+func weightedGini(shares []FairnessShare) float64 {
+	values := make([]float64, 0, len(shares))
+	var sum float64
+	for _, share := range shares {
+		value := float64(share.Count) / float64(max(share.Weight, 1))
+		values = append(values, value)
+		sum += value
+	}
+	if len(values) < 2 || sum == 0 {
+		return 0
+	}
+
+	slices.Sort(values)
+	var weighted float64
+	for i, value := range values {
+		weighted += float64(2*(i+1)-len(values)-1) * value
+	}
+
+	return weighted / (float64(len(values)) * sum)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const BalancerStatusOverloaded = "overloaded"
+
+var (
+	ErrTooManyGoroutines = errors.New("too many goroutines")
+	ErrGoroutineRate     = errors.New("goroutines created too fast")
+)
+
+// GoroutineGovernorConfig sheds new requests and streams, each served by a goroutine, before scheduler and memory overhead grows
+// unbounded during a spike. MaxGoroutines caps all goroutines of the process, MaxNewPerSecond the requests accepted per second,
+// zero disables a limit.
+type GoroutineGovernorConfig struct {
+	MaxGoroutines   int
+	MaxNewPerSecond int
+}
+
+// shedRequests counts requests shed by the governor since start
+var shedRequests atomic.Uint64
+
+// ShedRequests returns the number of requests shed by the goroutine governor
+func ShedRequests() uint64 {
+	return shedRequests.Load()
+}
+
+// goroutineGovernor admits new goroutines while the process is below its limits
+type goroutineGovernor struct {
+	config GoroutineGovernorConfig
+	mu     sync.Mutex
+	second int64 // unix second the count belongs to
+	count  int
+}
+
+func (g *goroutineGovernor) admit() error {
+	if g.config.MaxGoroutines > 0 && runtime.NumGoroutine() > g.config.MaxGoroutines {
+		return ErrTooManyGoroutines
+	}
+	if g.config.MaxNewPerSecond <= 0 {
+		return nil
+	}
+
+	now := time.Now().Unix()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.second != now {
+		g.second, g.count = now, 0
+	}
+	if g.count >= g.config.MaxNewPerSecond {
+		return ErrGoroutineRate
+	}
+	g.count++
+
+	return nil
+}
+
+// WithGoroutineGovernor rejects requests with 503 and the reason once the governor limits are exceeded
+func WithGoroutineGovernor(config GoroutineGovernorConfig) Middleware {
+	if config.MaxGoroutines <= 0 && config.MaxNewPerSecond <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	governor := &goroutineGovernor{config: config}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if err := governor.admit(); err != nil {
+					shedRequests.Add(1)
+					logDebugContext(r.Context(), "Shedding request", "method", r.Method, "path", r.URL.Path, "error", err)
+					w.Header().Set(BalancerStatusHeader, BalancerStatusOverloaded)
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, "Balancer overloaded: "+err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// BackendProtocolHTTP1 proxies over HTTP/1.1, https backends still negotiate HTTP/2 when they support it
+	BackendProtocolHTTP1 = "http1"
+	// BackendProtocolH2C proxies over cleartext HTTP/2 with prior knowledge, as plaintext gRPC backends expect
+	BackendProtocolH2C = "h2c"
+)
+
+// newH2CTransport speaks cleartext HTTP/2 to backends, requests are streams multiplexed over one connection per backend
+// so every gRPC call is balanced on its own rather than pinned with its connection
+func newH2CTransport() http.RoundTripper {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// newBaseTransport returns the transport backends of a pool are reached by
+func newBaseTransport(backendAuth *BackendAuth, prewarm ConnectionPrewarmConfig, backendProtocol string) http.RoundTripper {
+	if backendProtocol == BackendProtocolH2C {
+		return newH2CTransport()
+	}
+
+	return newPoolTransport(backendAuth.Transport(), prewarm)
+}
+
+// withH2C serves cleartext HTTP/2 next to HTTP/1.1 so gRPC clients without TLS can connect, with TLS HTTP/2 is negotiated anyway
+func withH2C(handler http.Handler) http.Handler {
+	return h2c.NewHandler(handler, &http2.Server{})
+}
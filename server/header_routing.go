@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RouteRuleConfig routes requests to Pool by a header value, a path prefix or both, e.g. X-Tenant: acme to the pool of acme.
+// An empty Value matches any request carrying Header. Rules are evaluated in order and the first match wins.
+type RouteRuleConfig struct {
+	Header     string
+	Value      string
+	PathPrefix string
+	Pool       string
+}
+
+// routeRule is a routing rule resolved to its pool
+type routeRule struct {
+	config RouteRuleConfig
+	pool   ServerPool
+}
+
+func newRouteRules(configs []RouteRuleConfig, pools map[string]ServerPool) ([]routeRule, error) {
+	rules := make([]routeRule, 0, len(configs))
+	for i, config := range configs {
+		if config.Header == "" && config.PathPrefix == "" {
+			return nil, fmt.Errorf("routing rule %d requires a header or a path prefix", i)
+		}
+		pool, ok := pools[config.Pool]
+		if !ok {
+			return nil, fmt.Errorf("routing rule %d: %w: %s", i, ErrUnknownPool, config.Pool)
+		}
+		rules = append(rules, routeRule{config: config, pool: pool})
+	}
+
+	return rules, nil
+}
+
+func (rule routeRule) matches(r *http.Request) bool {
+	if rule.config.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rule.config.PathPrefix) {
+		return false
+	}
+	if rule.config.Header == "" {
+		return true
+	}
+
+	value := r.Header.Get(rule.config.Header)
+	return value != "" && (rule.config.Value == "" || value == rule.config.Value)
+}
+
+// matchRouteRule returns the first rule matching the request
+func matchRouteRule(r *http.Request, rules []routeRule) (routeRule, bool) {
+	for _, rule := range rules {
+		if rule.matches(r) {
+			return rule, true
+		}
+	}
+
+	return routeRule{}, false
+}
@@ -1,26 +1,131 @@
 package server
 
 import (
-	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func healthHandler(proxyServerPool *ProxyServerPool) http.HandlerFunc {
+type healthResponse struct {
+	Status            string       `json:"status"`
+	MaxCapacity       int          `json:"maxCapacity"`
+	AvailableCapacity int          `json:"availableCapacity"`
+	Pools             []poolHealth `json:"pools,omitempty"` // only with ?verbose=true
+}
+
+type livenessResponse struct {
+	Status string `json:"status"`
+}
+
+type readinessResponse struct {
+	Status             string `json:"status"`
+	HealthyBackends    int    `json:"healthyBackends"`
+	MinHealthyBackends int    `json:"minHealthyBackends"`
+}
+
+type poolHealth struct {
+	Name     string          `json:"name"`
+	Backends []BackendHealth `json:"backends"`
+}
+
+// BackendHealth is the detailed health of a backend, the circuit is open while the backend is out of rotation
+// after failed active or passive checks and closed again once active checks pass
+type BackendHealth struct {
+	BackendStatus
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastCheck           *time.Time `json:"lastCheck,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	Circuit             string     `json:"circuit"`
+}
+
+// healthHandler reports the balancer as up together with the capacity of the default pool,
+// ?verbose=true adds the health of every backend of every pool
+func healthHandler(proxyServerPool ServerPool, poolRouter *PoolRouter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		response := map[string]any{
-			"status":            "ok",
-			"maxCapacity":       proxyServerPool.GetMaxCapacity(),
-			"availableCapacity": proxyServerPool.GetAvailableCapacity(),
+		response := healthResponse{
+			Status:            "ok",
+			MaxCapacity:       proxyServerPool.GetMaxCapacity(),
+			AvailableCapacity: proxyServerPool.GetAvailableCapacity(),
+		}
+		if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); verbose {
+			for _, pool := range poolRouter.Pools() {
+				response.Pools = append(response.Pools, poolHealth{Name: pool.Name(), Backends: backendHealth(pool)})
+			}
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// livenessHandler only tells the process serves requests, it never looks at backends so an orchestrator
+// does not restart the balancer because of them
+func livenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, livenessResponse{Status: "ok"})
+	}
+}
+
+// readinessHandler answers 503 while the default pool has fewer than minHealthyBackends healthy backends or the balancer
+// is shutting down, so orchestrators only send traffic to instances able to serve it
+func readinessHandler(proxyServerPool ServerPool, minHealthyBackends int, shuttingDown <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := readinessResponse{Status: "ready", MinHealthyBackends: minHealthyBackends}
+		for _, backend := range proxyServerPool.Backends() {
+			if backend.Alive && !backend.Disabled {
+				response.HealthyBackends++
+			}
+		}
+
+		select {
+		case <-shuttingDown:
+			w.Header().Set(BalancerStatusHeader, BalancerStatusShuttingDown)
+			response.Status = BalancerStatusShuttingDown
+			writeJSON(w, http.StatusServiceUnavailable, response)
+			return
+		default:
+		}
+
+		if response.HealthyBackends < minHealthyBackends {
+			response.Status = "not-ready"
+			writeJSON(w, http.StatusServiceUnavailable, response)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func backendHealth(pool ServerPool) []BackendHealth {
+	backends := pool.Backends()
+	health := make([]BackendHealth, 0, len(backends))
+	for _, backend := range backends {
+		h := BackendHealth{BackendStatus: backend, Circuit: "closed"}
+		if !backend.Alive {
+			h.Circuit = "open"
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+		// the history runs from newest to oldest
+		history, _ := pool.HealthHistory(backend.ID)
+		if len(history) > 0 {
+			h.LastCheck = &history[0].Time
+		}
+		for _, result := range history {
+			if result.Healthy {
+				break
+			}
+			if h.ConsecutiveFailures == 0 {
+				h.LastError = result.Error
+			}
+			h.ConsecutiveFailures++
+		}
+
+		health = append(health, h)
 	}
+
+	return health
 }
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	HealthProbeHttp = "http"
+	HealthProbeGrpc = "grpc"
+)
+
+var ErrUnknownHealthProbe = errors.New("unknown health probe type")
+
+// HealthCheckThresholds is the number of consecutive passed checks marking a dead backend alive (Rise)
+// and failed checks marking an alive backend dead (Fall), values below 1 count as 1
+type HealthCheckThresholds struct {
+	Rise int
+	Fall int
+}
+
+func (t HealthCheckThresholds) rise() int {
+	return max(t.Rise, 1)
+}
+
+func (t HealthCheckThresholds) fall() int {
+	return max(t.Fall, 1)
+}
+
+// HealthProbe checks whether a backend is able to serve traffic
+type HealthProbe interface {
+	Check(ctx context.Context, target *url.URL) error
+	// Release frees what the probe keeps for checking target once it left the pool
+	Release(target *url.URL)
+}
+
+// NewHealthProbe creates a health probe of the given type
+func NewHealthProbe(probeType string, httpClient *http.Client, timeout time.Duration) (HealthProbe, error) {
+	switch probeType {
+	case "", HealthProbeHttp:
+		return &httpHealthProbe{httpClient: httpClient}, nil
+	case HealthProbeGrpc:
+		return newGrpcHealthProbe(timeout), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHealthProbe, probeType)
+	}
+}
+
+// httpHealthProbe expects the backend to respond with 200 on GET /health
+type httpHealthProbe struct {
+	httpClient *http.Client
+}
+
+type probeTransportKey struct{}
+
+// withProbeTransport makes http probes reach the backend through transport, e.g. one trusting its CA
+func withProbeTransport(ctx context.Context, transport http.RoundTripper) context.Context {
+	return context.WithValue(ctx, probeTransportKey{}, transport)
+}
+
+func (p *httpHealthProbe) Check(ctx context.Context, target *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.JoinPath("health").String(), nil)
+	if err != nil {
+		return err
+	}
+
+	httpClient := p.httpClient
+	if transport, ok := ctx.Value(probeTransportKey{}).(http.RoundTripper); ok && transport != nil {
+		client := *p.httpClient
+		client.Transport = transport
+		httpClient = &client
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Release does nothing, idle connections belong to the transport of the pool
+func (p *httpHealthProbe) Release(*url.URL) {}
+
+// grpcHealthProbe calls grpc.health.v1.Health/Check on the backend, connections are reused between checks.
+// https backends are reached with the TLS settings of the probe transport, i.e. the upstream TLS of their pool.
+type grpcHealthProbe struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	conns   map[string]*grpc.ClientConn
+}
+
+func newGrpcHealthProbe(timeout time.Duration) *grpcHealthProbe {
+	return &grpcHealthProbe{
+		timeout: timeout,
+		conns:   make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (p *grpcHealthProbe) Check(ctx context.Context, target *url.URL) error {
+	conn, err := p.conn(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	// empty service name asks for the overall health of the backend
+	resp, err := healthpb.NewHealthClient(conn).Check(checkCtx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("unexpected serving status %s", resp.GetStatus())
+	}
+
+	return nil
+}
+
+func (p *grpcHealthProbe) conn(ctx context.Context, target *url.URL) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := grpcProbeKey(target)
+	if conn, ok := p.conns[key]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if target.Scheme == "https" {
+		tlsConfig := &tls.Config{}
+		if transport, ok := ctx.Value(probeTransportKey{}).(*http.Transport); ok && transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(target.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("error creating grpc client: %w", err)
+	}
+	p.conns[key] = conn
+
+	return conn, nil
+}
+
+// Release closes the connection to target
+func (p *grpcHealthProbe) Release(target *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := grpcProbeKey(target)
+	if conn, ok := p.conns[key]; ok {
+		conn.Close()
+		delete(p.conns, key)
+	}
+}
+
+// grpcProbeKey tells apart connections of the same host with and without TLS
+func grpcProbeKey(target *url.URL) string {
+	return target.Scheme + "://" + target.Host
+}
@@ -2,98 +2,297 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"time"
 
 	"github.com/javor454/balancer/auth"
 )
 
+const (
+	// BalancerStatusHeader carries the balancer state on responses the balancer generated itself
+	BalancerStatusHeader       = "X-Balancer-Status"
+	BalancerStatusShuttingDown = "shutting-down"
+	// BalancerStatusBackendsSaturated marks requests refused because every backend is at its in-flight limit
+	BalancerStatusBackendsSaturated = "backends-saturated"
+)
+
 // HttpServer represents the HTTP server with routing and shutdown capabilities
 type HttpServer struct {
 	srv             *http.Server
+	adminSrv        *http.Server // nil unless a dedicated admin port is configured
+	listeners       []listenerConfig
 	shutdownTimeout time.Duration
+	shadows         *shadowRequests // mirrored requests in flight
 }
 
-// NewHttpServer creates and configures a new HTTP server instance with logging, panic recovery, and URL whitelisting
-func NewHttpServer(port int, shutdownTimeout time.Duration, whitelistedPaths []string, authBlacklistedPaths []string, proxyServerPool *ProxyServerPool, registerHandler *RegisterHandler, authHandler *auth.AuthHandler) *HttpServer {
+// listenerConfig binds a server to hosts, resolved to addresses when serving so bind errors surface at startup
+type listenerConfig struct {
+	name  string
+	srv   *http.Server
+	hosts []string
+	port  int
+	tls   TLSConfig // terminated by the listener if enabled
+}
+
+// NewHttpServer creates and configures a new HTTP server instance with logging, panic recovery, header sanitization and URL whitelisting
+func NewHttpServer(config *HttpConfig, trustedProxies []netip.Prefix, proxyServerPool ServerPool, poolRouter *PoolRouter, experiments *Experiments, registerHandler *RegisterHandler, authHandler *auth.AuthHandler) *HttpServer {
 	mux := http.NewServeMux()
+	// long-lived streams are not interrupted by http.Server.Shutdown, they watch this instead
+	shuttingDown := make(chan struct{})
 
-	mux.HandleFunc("GET /health", healthHandler(proxyServerPool))
+	registerAdminRoutes(mux, config, proxyServerPool, poolRouter, registerHandler, shuttingDown)
 
 	mux.HandleFunc("GET /register", registerHandler.ListRegisteredClientsHandler)
-	mux.HandleFunc("POST /register", registerHandler.RegisterClientHandler)
+	mux.HandleFunc("POST /register", mutating(registerHandler.RegisterClientHandler))
+	mux.HandleFunc("GET /clients/{name}", registerHandler.GetClientHandler)
+	mux.HandleFunc("DELETE /clients/{name}", mutating(registerHandler.DeregisterClientHandler))
+	mux.HandleFunc("GET /queue/stats", queueStatsHandler(proxyServerPool))
+
+	shadows := newShadowRequests()
+	registerProxyServer(mux, poolRouter, config.MaintenanceBypassToken, config.Retry, config.Mirror, shadows)
 
-	registerProxyServer(mux, proxyServerPool)
+	// shared by both listeners, the dashboard streams from either
+	streamLimits := WithStreamLimits(config.StreamLimits)
 
 	wrappedMux := Chain(
+		WithRequestID(trustedProxies),
 		WithPanicRecovery(),
-		WithLogging(),
-		WithWhitelistedPaths(whitelistedPaths),
-		WithConditionalAuth(authBlacklistedPaths, authHandler),
+		WithGoroutineGovernor(config.GoroutineGovernor),
+		WithSanitizedHeaders(trustedProxies, config.DeniedHeaders),
+		WithExperiments(experiments),
+		WithLogging(config.LogSampleRate, config.StreamRequestBodies),
+		WithFailureInjection(config.FailureInjection),
+		WithWhitelistedPaths(config.WhitelistedPaths),
+		WithAllowedMethods(config.RouteMethods),
+		WithRateLimiting(config.RateLimit, config.RouteRateLimits),
+		WithAdminAuth(config.AdminToken),
+		WithConditionalAuth(config.AuthBlacklistedPaths, authHandler),
+		WithScopes(config.Scopes.Routes),
+		streamLimits,
+		WithBandwidthThrottling(config.ClientBandwidth, config.RouteBandwidth),
+		WithSessionExpiryWarning(config.SessionExpiryWarning, authHandler),
+		WithCompression(config.Compression),
 	)(mux)
+	if config.AcceptH2C {
+		wrappedMux = withH2C(wrappedMux)
+	}
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+		Addr:    fmt.Sprintf(":%d", config.Port),
 		Handler: wrappedMux,
 	}
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
 
 	h := &HttpServer{
 		srv:             srv,
-		shutdownTimeout: shutdownTimeout,
+		listeners:       []listenerConfig{{name: "Http", srv: srv, hosts: config.BindAddresses, port: config.Port, tls: config.TLS}},
+		shutdownTimeout: config.ShutdownTimeout,
+		shadows:         shadows,
+	}
+
+	if config.AdminPort != 0 {
+		adminMux := http.NewServeMux()
+		registerAdminRoutes(adminMux, config, proxyServerPool, poolRouter, registerHandler, shuttingDown)
+
+		h.adminSrv = &http.Server{
+			Addr: fmt.Sprintf(":%d", config.AdminPort),
+			Handler: Chain(
+				WithRequestID(trustedProxies),
+				WithPanicRecovery(),
+				WithAdminAuth(config.AdminToken),
+				WithConditionalAuth(config.AuthBlacklistedPaths, authHandler),
+				WithScopes(config.Scopes.Routes),
+				streamLimits,
+			)(adminMux),
+		}
+		// a separate listener keeps probes and operators responsive while the data path is saturated
+		h.listeners = append(h.listeners, listenerConfig{name: "Admin", srv: h.adminSrv, hosts: config.AdminBindAddresses, port: config.AdminPort})
 	}
 
 	return h
 }
 
-// Serve begins listening for HTTP requests and returns an error channel
+// registerAdminRoutes registers health and admin endpoints, they are served on the main and the dedicated admin port
+func registerAdminRoutes(mux *http.ServeMux, config *HttpConfig, proxyServerPool ServerPool, poolRouter *PoolRouter, registerHandler *RegisterHandler, shuttingDown <-chan struct{}) {
+	mux.HandleFunc("GET /health", healthHandler(proxyServerPool, poolRouter))
+	mux.HandleFunc("GET /healthz", livenessHandler())
+	mux.HandleFunc("GET /ready", readinessHandler(proxyServerPool, config.ReadyMinBackends, shuttingDown))
+	mux.HandleFunc("GET /admin/diagnostics", diagnosticsHandler(proxyServerPool, registerHandler))
+	mux.HandleFunc("PUT /admin/logging", mutating(verboseLoggingHandler()))
+	mux.HandleFunc("PUT /admin/health-checks", mutating(healthChecksHandler(poolRouter)))
+	mux.HandleFunc("PUT /admin/maintenance", mutating(maintenanceHandler()))
+	mux.HandleFunc("GET /admin/balancing", balancingHandler(poolRouter))
+	mux.HandleFunc("PUT /admin/balancing", mutating(balancingHandler(poolRouter)))
+	mux.HandleFunc("PUT "+readOnlyPath, mutating(readOnlyHandler()))
+	mux.HandleFunc("POST /admin/backends/register", mutating(backendRegistrationHandler(proxyServerPool, config.BackendRegistration)))
+	mux.HandleFunc("POST /admin/backends/heartbeat", backendHeartbeatHandler(proxyServerPool, config.BackendRegistration))
+	mux.HandleFunc("GET /admin/backends/{id}/health-history", healthHistoryHandler(proxyServerPool))
+	mux.HandleFunc("PUT /admin/backends/{id}/drain", mutating(backendRotationHandler(proxyServerPool, false)))
+	mux.HandleFunc("PUT /admin/backends/{id}/enable", mutating(backendRotationHandler(proxyServerPool, true)))
+	mux.HandleFunc("GET /admin/error-budgets", errorBudgetsHandler(poolRouter))
+	mux.HandleFunc("GET /admin/fairness", fairnessHandler(poolRouter))
+	mux.HandleFunc("GET /admin/switchover", switchoverHandler(poolRouter))
+	mux.HandleFunc("PUT /admin/switchover", mutating(switchoverHandler(poolRouter)))
+	mux.HandleFunc("GET /admin/bandit", banditHandler(poolRouter))
+	mux.HandleFunc("GET /admin/ui", dashboardHandler())
+	mux.HandleFunc("GET /admin/ui/events", dashboardEventsHandler(proxyServerPool, shuttingDown))
+}
+
+// Handler returns the fully wrapped root handler, useful for serving it without a listener
+func (s *HttpServer) Handler() http.Handler {
+	return s.srv.Handler
+}
+
+// Serve begins listening for HTTP requests on every bind address and returns an error channel,
+// addresses which cannot be resolved or bound and certificates which cannot be loaded are reported on it right away
 func (s *HttpServer) Serve() chan error {
-	serverError := make(chan error, 1)
+	type binding struct {
+		listener listenerConfig
+		address  string
+	}
 
-	go func() {
-		log.Printf("Starting Http server on port %s", s.srv.Addr)
-		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Http server error: %v", err)
-			serverError <- err
+	var bindings []binding
+	for _, listener := range s.listeners {
+		addresses, err := ResolveBindAddresses(listener.hosts, listener.port)
+		if err == nil && listener.tls.Enabled() {
+			err = setupTLS(listener.srv, listener.tls)
+		}
+		if err != nil {
+			slog.Error("Server error", "server", listener.name, "error", err)
+			serverError := make(chan error, 1)
+			serverError <- fmt.Errorf("%s server: %w", listener.name, err)
+			return serverError
 		}
-	}()
+		for _, address := range addresses {
+			bindings = append(bindings, binding{listener, address})
+		}
+	}
+
+	serverError := make(chan error, len(bindings))
+	for _, b := range bindings {
+		ln, err := net.Listen("tcp", b.address)
+		if err != nil {
+			slog.Error("Server cannot bind", "server", b.listener.name, "address", b.address, "error", err)
+			serverError <- fmt.Errorf("%s server cannot bind %s: %w", b.listener.name, b.address, err)
+			continue
+		}
+
+		slog.Info("Starting server", "server", b.listener.name, "address", ln.Addr().String(), "tls", b.listener.tls.Enabled())
+		go func() {
+			serve := b.listener.srv.Serve
+			if b.listener.tls.Enabled() {
+				// the certificate comes from TLSConfig.GetCertificate, ServeTLS adds HTTP/2 support on top of it
+				serve = func(ln net.Listener) error { return b.listener.srv.ServeTLS(ln, "", "") }
+			}
+			if err := serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Error("Server error", "server", b.listener.name, "error", err)
+				serverError <- err
+			}
+		}()
+	}
 
-	log.Print("Http server started")
+	slog.Info("Http server started")
 
 	return serverError
 }
 
-// GracefulShutdown attempts to gracefully shut down the server
+// GracefulShutdown attempts to gracefully shut down the server, the admin port goes down last so probes work until the end
 func (s *HttpServer) GracefulShutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
 
 	if err := s.srv.Shutdown(ctx); err != nil {
-		log.Printf("Http server shutdown failed: %v", err)
+		slog.Error("Http server shutdown failed", "error", err)
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
 
-	log.Printf("Http server shutdown completed")
+	// no requests are mirrored once the listener is down, those in flight get what is left of the timeout
+	if err := s.shadows.wait(ctx); err != nil {
+		slog.Warn("Cancelled mirrored requests still in flight", "error", err)
+	}
+
+	if s.adminSrv != nil {
+		if err := s.adminSrv.Shutdown(ctx); err != nil {
+			slog.Error("Admin server shutdown failed", "error", err)
+			return fmt.Errorf("admin server shutdown failed: %w", err)
+		}
+	}
+
+	slog.Info("Http server shutdown completed")
 
 	return nil
 }
 
-// registerProxyServer registers the proxy server with load balancing
-func registerProxyServer(mux *http.ServeMux, proxyServerPool *ProxyServerPool) {
+// registerProxyServer registers the proxy server with load balancing across the pool chosen by the router.
+// Requests failing before a backend responded are retried against another backend of the pool as configured.
+// A sample of requests is mirrored to a shadow backend if configured.
+func registerProxyServer(mux *http.ServeMux, poolRouter *PoolRouter, maintenanceBypassToken string, retry RetryConfig, mirror MirrorConfig, shadows *shadowRequests) {
 	loadBalancer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler, err := proxyServerPool.NextServer(r.Context())
+		proxyServerPool := poolRouter.Route(r)
+
+		retries, err := prepareRetries(r, retry)
 		if err != nil {
-			http.Error(w, "No available backend servers", http.StatusServiceUnavailable)
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
 			return
 		}
+		attempt := &retryAttempt{remaining: retries}
+		if retries > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), retryAttemptKey{}, attempt))
+		}
+		r, trace := withProxyTrace(r)
 
-		handler.ServeHTTP(w, r)
+		for {
+			trace.attempt++
+			lease, err := proxyServerPool.NextServer(r)
+			if errors.Is(err, ErrShuttingDown) {
+				// queued requests are not preserved across restarts, clients should retry against another instance
+				w.Header().Set(BalancerStatusHeader, BalancerStatusShuttingDown)
+				w.Header().Set("Connection", "close")
+				http.Error(w, "Balancer is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrNoCapacity) {
+				status := BalancerStatusQueueTimeout
+				if errors.Is(err, ErrQueueFull) {
+					status = BalancerStatusQueueFull
+				}
+				w.Header().Set(BalancerStatusHeader, status)
+				w.Header().Set("Retry-After", retryAfterSeconds(proxyServerPool.QueueStats()))
+				http.Error(w, "No capacity available, retry later", http.StatusServiceUnavailable)
+				return
+			}
+			if errors.Is(err, ErrBackendsSaturated) {
+				w.Header().Set(BalancerStatusHeader, BalancerStatusBackendsSaturated)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "All backends are saturated", http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				http.Error(w, "No available backend servers", http.StatusServiceUnavailable)
+				return
+			}
 
-		proxyServerPool.ReleaseCapacity()
+			attempt.err = nil
+			lease.ServeHTTP(w, r)
+
+			if attempt.err == nil {
+				return
+			}
+			logDebugContext(r.Context(), "Retrying request", "method", r.Method, "path", r.URL.Path, "error", attempt.err)
+			attempt.remaining--
+			attempt.tried = append(attempt.tried, lease.backend)
+			if r.GetBody != nil {
+				r.Body, _ = r.GetBody()
+			}
+		}
 	})
 
-	mux.Handle("/", loadBalancer)
+	mux.Handle("/", WithMaintenanceMode(maintenanceBypassToken)(withMirroring(mirror, shadows, loadBalancer)))
 
-	log.Print("Proxy server registered")
+	slog.Info("Proxy server registered")
 }
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// maxPooledBufferSize keeps occasional large responses from pinning memory in the pool
+const maxPooledBufferSize = 64 << 10
+
+type jsonEncoder struct {
+	buf *bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonEncoderPool = sync.Pool{
+	New: func() any {
+		buf := &bytes.Buffer{}
+		return &jsonEncoder{buf: buf, enc: json.NewEncoder(buf)}
+	},
+}
+
+// writeJSON encodes v with a pooled encoder and writes it with the given status code
+func writeJSON(w http.ResponseWriter, statusCode int, v any) error {
+	e := jsonEncoderPool.Get().(*jsonEncoder)
+	defer func() {
+		if e.buf.Cap() <= maxPooledBufferSize {
+			jsonEncoderPool.Put(e)
+		}
+	}()
+	e.buf.Reset()
+
+	if err := e.enc.Encode(v); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err := w.Write(e.buf.Bytes())
+
+	return err
+}
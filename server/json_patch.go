@@ -0,0 +1,66 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPatchOperation is a single RFC 6902 operation, only add, remove and replace are produced.
+// Value is always encoded since null is a valid value, appliers ignore it on remove.
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// diffJSON appends the operations turning from into to, both generically decoded JSON values.
+// Arrays are compared by index, so an element inserted at the front replaces every element after it.
+func diffJSON(ops []jsonPatchOperation, path string, from any, to any) []jsonPatchOperation {
+	switch from := from.(type) {
+	case map[string]any:
+		to, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		for key := range from {
+			if _, ok := to[key]; !ok {
+				ops = append(ops, jsonPatchOperation{Op: "remove", Path: path + "/" + jsonPointerEscaper.Replace(key)})
+			}
+		}
+		for key, toValue := range to {
+			keyPath := path + "/" + jsonPointerEscaper.Replace(key)
+			if fromValue, ok := from[key]; ok {
+				ops = diffJSON(ops, keyPath, fromValue, toValue)
+			} else {
+				ops = append(ops, jsonPatchOperation{Op: "add", Path: keyPath, Value: toValue})
+			}
+		}
+		return ops
+	case []any:
+		to, ok := to.([]any)
+		if !ok {
+			break
+		}
+		common := min(len(from), len(to))
+		for i := range common {
+			ops = diffJSON(ops, path+"/"+strconv.Itoa(i), from[i], to[i])
+		}
+		// removing from the end keeps the indexes of the remaining removals valid
+		for i := len(from) - 1; i >= common; i-- {
+			ops = append(ops, jsonPatchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(to); i++ {
+			ops = append(ops, jsonPatchOperation{Op: "add", Path: path + "/-", Value: to[i]})
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		ops = append(ops, jsonPatchOperation{Op: "replace", Path: path, Value: to})
+	}
+
+	return ops
+}
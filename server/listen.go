@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// ResolveBindAddresses returns the listen addresses for port on the given hosts. A host is an IPv4 or IPv6 address or the name
+// of a network interface listening on all its addresses, no hosts listen on all interfaces of both address families.
+func ResolveBindAddresses(hosts []string, port int) ([]string, error) {
+	if len(hosts) == 0 {
+		return []string{net.JoinHostPort("", strconv.Itoa(port))}, nil
+	}
+
+	addresses := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			addresses = append(addresses, netip.AddrPortFrom(addr, uint16(port)).String())
+			continue
+		}
+
+		iface, err := net.InterfaceByName(host)
+		if err != nil {
+			return nil, fmt.Errorf("bind address %q is neither an IP address nor a network interface: %w", host, err)
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("error listing addresses of interface %s: %w", host, err)
+		}
+		if len(ifaceAddrs) == 0 {
+			return nil, fmt.Errorf("interface %s has no addresses to bind to", host)
+		}
+		for _, ifaceAddr := range ifaceAddrs {
+			prefix, err := netip.ParsePrefix(ifaceAddr.String())
+			if err != nil {
+				continue
+			}
+			addr := prefix.Addr()
+			if addr.Is6() && addr.IsLinkLocalUnicast() {
+				addr = addr.WithZone(iface.Name)
+			}
+			addresses = append(addresses, netip.AddrPortFrom(addr, uint16(port)).String())
+		}
+	}
+
+	return addresses, nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LogConfig configures the structured logger every component logs through, log.Printf lines end up in it at info level
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+	Output string // stderr, stdout or a file path the logs are appended to
+}
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogOutputConfig configures a log stream, async outputs never block request handling on slow stdout
+type LogOutputConfig struct {
+	Async     bool
+	QueueSize int
+}
+
+var (
+	// accessLog receives one record per request from WithLogging
+	accessLog = slog.Default()
+	// debugLog receives verbose records, see logDebug
+	debugLog        = slog.Default()
+	asyncLogWriters []*AsyncWriter
+	logFile         *os.File // nil unless logs go to a file
+)
+
+// Validate checks the level and format are known
+func (c LogConfig) Validate() error {
+	if _, err := parseLogLevel(c.Level); err != nil {
+		return err
+	}
+	if c.Format != LogFormatText && c.Format != LogFormatJSON {
+		return fmt.Errorf("unknown log format %q, expected %s or %s", c.Format, LogFormatText, LogFormatJSON)
+	}
+
+	return nil
+}
+
+// ConfigureLogOutputs sets up the default logger and the access and debug log streams, it must be called before serving
+func ConfigureLogOutputs(logConfig LogConfig, accessLogConfig LogOutputConfig, debugLogConfig LogOutputConfig) error {
+	level, err := parseLogLevel(logConfig.Level)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer
+	switch logConfig.Output {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		if logFile, err = os.OpenFile(logConfig.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return fmt.Errorf("error opening log output: %w", err)
+		}
+		out = logFile
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	newHandler := func(w io.Writer) slog.Handler {
+		if logConfig.Format == LogFormatJSON {
+			return requestIDHandler{slog.NewJSONHandler(w, options)}
+		}
+		return requestIDHandler{slog.NewTextHandler(w, options)}
+	}
+
+	slog.SetDefault(slog.New(newHandler(out)))
+	accessLog = newLogger(out, newHandler, accessLogConfig)
+	debugLog = newLogger(out, newHandler, debugLogConfig)
+
+	return nil
+}
+
+// CloseLogOutputs flushes async log streams and closes the log file
+func CloseLogOutputs() error {
+	var errs []error
+	for _, w := range asyncLogWriters {
+		errs = append(errs, w.Close())
+	}
+	if logFile != nil {
+		errs = append(errs, logFile.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// DroppedLogLines returns the number of lines dropped by async log streams under backpressure
+func DroppedLogLines() int64 {
+	var dropped int64
+	for _, w := range asyncLogWriters {
+		dropped += w.Dropped()
+	}
+
+	return dropped
+}
+
+func newLogger(out io.Writer, newHandler func(io.Writer) slog.Handler, config LogOutputConfig) *slog.Logger {
+	if !config.Async {
+		return slog.Default()
+	}
+
+	w := NewAsyncWriter(out, config.QueueSize)
+	asyncLogWriters = append(asyncLogWriters, w)
+
+	return slog.New(newHandler(w))
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+
+	return level, nil
+}
+
+type requestLogAttrsKey struct{}
+
+// requestLogAttrs collects fields of the access log record while the request is served
+type requestLogAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// AddRequestLogAttrs adds fields such as the selected backend to the access log record of the request, a no-op for requests which are not logged
+func AddRequestLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if collected, ok := ctx.Value(requestLogAttrsKey{}).(*requestLogAttrs); ok {
+		collected.mu.Lock()
+		collected.attrs = append(collected.attrs, attrs...)
+		collected.mu.Unlock()
+	}
+}
+
+// verboseLogging enables per-request and per-health-check log lines which are too noisy for normal operation
+var verboseLogging atomic.Bool
+
+// SetVerboseLogging enables or disables verbose log lines
+func SetVerboseLogging(enabled bool) {
+	verboseLogging.Store(enabled)
+	slog.Info("Verbose logging changed", "enabled", enabled)
+}
+
+// ToggleVerboseLogging flips verbose logging and returns the new state
+func ToggleVerboseLogging() bool {
+	for {
+		current := verboseLogging.Load()
+		if verboseLogging.CompareAndSwap(current, !current) {
+			slog.Info("Verbose logging changed", "enabled", !current)
+			return !current
+		}
+	}
+}
+
+// VerboseLogging reports whether verbose log lines are enabled
+func VerboseLogging() bool {
+	return verboseLogging.Load()
+}
+
+// logDebug logs at debug level only when verbose logging is enabled
+func logDebug(msg string, args ...any) {
+	logDebugContext(context.Background(), msg, args...)
+}
+
+// logDebugContext is logDebug for records of a request, they carry its request ID
+func logDebugContext(ctx context.Context, msg string, args ...any) {
+	if verboseLogging.Load() {
+		debugLog.DebugContext(ctx, msg, args...)
+	}
+}
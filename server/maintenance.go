@@ -0,0 +1,51 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// MaintenanceBypassHeader carries the operator token which lets requests through during maintenance
+	MaintenanceBypassHeader   = "X-Maintenance-Bypass"
+	BalancerStatusMaintenance = "maintenance"
+)
+
+// maintenance rejects proxied requests so backends can be worked on without client traffic
+var maintenance atomic.Bool
+
+// SetMaintenance enables or disables maintenance mode
+func SetMaintenance(enabled bool) {
+	maintenance.Store(enabled)
+	slog.Info("Maintenance mode changed", "enabled", enabled)
+}
+
+// Maintenance reports whether maintenance mode is enabled
+func Maintenance() bool {
+	return maintenance.Load()
+}
+
+// WithMaintenanceMode rejects requests with 503 during maintenance unless they carry the operator bypass token.
+// The token is never forwarded to backends.
+func WithMaintenanceMode(bypassToken string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				token := r.Header.Get(MaintenanceBypassHeader)
+				r.Header.Del(MaintenanceBypassHeader)
+
+				if maintenance.Load() {
+					if bypassToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(bypassToken)) != 1 {
+						w.Header().Set(BalancerStatusHeader, BalancerStatusMaintenance)
+						http.Error(w, "Service under maintenance", http.StatusServiceUnavailable)
+						return
+					}
+					logDebugContext(r.Context(), "Maintenance bypassed", "method", r.Method, "path", r.URL.Path)
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
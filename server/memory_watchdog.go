@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// BalancerStatusMemoryPressure marks requests refused while the heap is near its limit
+	BalancerStatusMemoryPressure = "memory-pressure"
+
+	// defaultMemoryShedRatio is the fraction of the limit at which load is shed when none is configured
+	defaultMemoryShedRatio = 0.9
+	// memoryRecoveryMargin is how far below the shed ratio the heap has to fall before shedding stops, so it does not flap
+	memoryRecoveryMargin = 0.05
+)
+
+// MemoryWatchdogConfig sheds load once the heap reaches ShedRatio of Limit, new clients are refused registration and
+// freed memory is returned to the OS, so the balancer degrades before it is OOM-killed. Limit defaults to GOMEMLIMIT.
+// Zero Interval disables it.
+type MemoryWatchdogConfig struct {
+	Interval     time.Duration
+	Limit        ByteSize
+	ShedRatio    float64 // 0.9 by default
+	AlertWebhook string  // receives a MemoryAlert when shedding starts and stops, empty only logs
+}
+
+// MemoryStats are the heap and garbage collection figures of the process
+type MemoryStats struct {
+	HeapBytes     uint64        `json:"heapBytes"`     // heap occupied by objects, live or not yet collected
+	HeapGoalBytes uint64        `json:"heapGoalBytes"` // heap size the next collection is triggered at
+	LimitBytes    uint64        `json:"limitBytes"`    // of the watchdog, 0 when it is disabled
+	GCCycles      uint64        `json:"gcCycles"`
+	GCPauseTotal  time.Duration `json:"gcPauseTotal"` // approximated from the pause histogram
+	Pressure      bool          `json:"pressure"`
+	Pressures     uint64        `json:"pressures"` // times shedding started since start
+}
+
+// MemoryAlert is posted to the alert webhook when shedding starts or stops
+type MemoryAlert struct {
+	Pressure   bool      `json:"pressure"`
+	HeapBytes  uint64    `json:"heapBytes"`
+	LimitBytes uint64    `json:"limitBytes"`
+	Time       time.Time `json:"time"`
+}
+
+var (
+	memoryPressure  atomic.Bool
+	memoryPressures atomic.Uint64
+	memoryLimit     atomic.Uint64
+)
+
+// MemoryPressure reports whether the heap is near its limit and load is being shed
+func MemoryPressure() bool {
+	return memoryPressure.Load()
+}
+
+// CurrentMemoryStats reads heap and garbage collection figures from the runtime
+func CurrentMemoryStats() MemoryStats {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/gc/heap/goal:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+		{Name: "/gc/pauses:seconds"},
+	}
+	metrics.Read(samples)
+
+	return MemoryStats{
+		HeapBytes:     samples[0].Value.Uint64(),
+		HeapGoalBytes: samples[1].Value.Uint64(),
+		LimitBytes:    memoryLimit.Load(),
+		GCCycles:      samples[2].Value.Uint64(),
+		GCPauseTotal:  histogramTotal(samples[3].Value.Float64Histogram()),
+		Pressure:      MemoryPressure(),
+		Pressures:     memoryPressures.Load(),
+	}
+}
+
+// histogramTotal sums a histogram of seconds taking the lower bound of each bucket
+func histogramTotal(histogram *metrics.Float64Histogram) time.Duration {
+	total := 0.0
+	for i, count := range histogram.Counts {
+		if lower := histogram.Buckets[i]; lower > 0 {
+			total += lower * float64(count)
+		}
+	}
+
+	return time.Duration(total * float64(time.Second))
+}
+
+// WatchMemory checks the heap every interval until ctx is done
+func WatchMemory(ctx context.Context, config MemoryWatchdogConfig) {
+	if config.Interval <= 0 {
+		return
+	}
+	limit := uint64(config.Limit)
+	if limit == 0 {
+		limit = uint64(CurrentRuntimeSettings().MemoryLimit)
+	}
+	if limit == 0 || limit == math.MaxInt64 {
+		slog.Warn("Memory watchdog disabled, neither its limit nor GOMEMLIMIT is set")
+		return
+	}
+	shedRatio := config.ShedRatio
+	if shedRatio <= 0 {
+		shedRatio = defaultMemoryShedRatio
+	}
+	memoryLimit.Store(limit)
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				heap := CurrentMemoryStats().HeapBytes
+				ratio := float64(heap) / float64(limit)
+
+				switch {
+				case !MemoryPressure() && ratio >= shedRatio:
+					memoryPressure.Store(true)
+					memoryPressures.Add(1)
+					slog.Warn("Heap near its limit, shedding load", "heapBytes", heap, "limitBytes", limit)
+					// garbage the next cycle would collect is freed and returned to the OS right away
+					debug.FreeOSMemory()
+				case MemoryPressure() && ratio < shedRatio-memoryRecoveryMargin:
+					memoryPressure.Store(false)
+					slog.Info("Heap recovered, no longer shedding load", "heapBytes", heap, "limitBytes", limit)
+				default:
+					continue
+				}
+
+				if config.AlertWebhook != "" {
+					sendMemoryAlert(ctx, httpClient, config.AlertWebhook, MemoryAlert{Pressure: MemoryPressure(), HeapBytes: heap, LimitBytes: limit, Time: time.Now()})
+				}
+			}
+		}
+	}()
+}
+
+func sendMemoryAlert(ctx context.Context, httpClient *http.Client, webhook string, alert MemoryAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		slog.Error("Failed to encode memory alert", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to create memory alert", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		slog.Error("Failed to send memory alert", "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Warn("Memory alert webhook failed", "status", resp.StatusCode)
+	}
+}
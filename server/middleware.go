@@ -2,12 +2,21 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"mime"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/javor454/balancer/auth"
+	"golang.org/x/net/http/httpguts"
 )
 
 type Middleware func(http.Handler) http.Handler
@@ -23,10 +32,17 @@ func Chain(middlewares ...Middleware) Middleware {
 	}
 }
 
-// WithLogging logs the request and response
-func WithLogging() Middleware {
+// WithLogging logs the request and response, only sampleRate fraction of requests is logged (1 logs everything).
+// With streamRequestBodies the request body is never read so it is streamed to the backend unbuffered.
+func WithLogging(sampleRate float64, streamRequestBodies bool) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// fast path, sampled out requests skip body capture and param extraction entirely
+			if sampleRate < 1 && (sampleRate <= 0 || rand.Float64() >= sampleRate) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			start := time.Now()
 
 			clientIP := r.Header.Get("X-Forwarded-For")
@@ -34,16 +50,20 @@ func WithLogging() Middleware {
 				clientIP = r.RemoteAddr
 			}
 
-			requestBody, err := readBody(r)
-			if err != nil {
-				log.Printf("Error reading request body: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
+			requestBody := "streamed"
+			if !streamRequestBodies {
+				var err error
+				if requestBody, err = readBody(r); err != nil {
+					slog.ErrorContext(r.Context(), "Error reading request body", "error", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
 			}
 
 			wrapped := wrapResponseWriter(w)
+			collected := &requestLogAttrs{}
 
-			next.ServeHTTP(wrapped, r)
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), requestLogAttrsKey{}, collected)))
 
 			duration := time.Since(start)
 
@@ -51,22 +71,28 @@ func WithLogging() Middleware {
 			if clientID := r.PathValue("clientID"); clientID != "" {
 				params["clientID"] = clientID
 			}
+			for experiment, variant := range ExperimentVariants(r.Context()) {
+				params["experiment."+experiment] = variant
+			}
 
 			sanitizedReqBody := sanitizeBody(requestBody)
-			sanitizedResBody := sanitizeBody(wrapped.body.String()) // why string conversion
-
-			log.Printf(
-				"Method: %s | Path: %s | IP: %s | Status: %d | Duration: %s | Params: %v | UserAgent: %s | RequestBody: %s | ResponseBody: %s",
-				r.Method,
-				r.URL.Path,
-				clientIP,
-				wrapped.Status(),
-				duration,
-				params,
-				r.UserAgent(),
-				sanitizedReqBody,
-				sanitizedResBody,
-			)
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("ip", clientIP),
+				slog.Int("status", wrapped.Status()),
+				slog.Duration("duration", duration),
+				slog.Any("params", params),
+				slog.String("userAgent", r.UserAgent()),
+				slog.String("requestBody", sanitizedReqBody),
+				slog.String("responseBody", wrapped.loggedBody()),
+			}
+			collected.mu.Lock()
+			attrs = append(attrs, collected.attrs...)
+			collected.mu.Unlock()
+
+			accessLog.LogAttrs(r.Context(), slog.LevelInfo, "Request served", attrs...)
 		})
 	}
 }
@@ -78,7 +104,11 @@ func WithPanicRecovery() Middleware {
 			func(w http.ResponseWriter, r *http.Request) {
 				defer func() {
 					if err := recover(); err != nil {
-						log.Printf("Panic recovered: %v", err)
+						// the proxy aborts responses it already started this way, the server closes the connection
+						if err == http.ErrAbortHandler {
+							panic(err)
+						}
+						slog.ErrorContext(r.Context(), "Panic recovered", "error", err)
 						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 					}
 				}()
@@ -88,7 +118,7 @@ func WithPanicRecovery() Middleware {
 	}
 }
 
-// WithWhitelistedPaths allows requests only to whitelisted paths
+// WithWhitelistedPaths allows requests only to whitelisted paths, paths ending with /* allow everything below the prefix
 func WithWhitelistedPaths(whitelist []string) Middleware {
 	whitelistedPathsLookup := make(map[string]struct{}, len(whitelist))
 	for _, path := range whitelist {
@@ -98,8 +128,8 @@ func WithWhitelistedPaths(whitelist []string) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
-				if _, allowed := whitelistedPathsLookup[r.URL.Path]; !allowed {
-					log.Printf("Blocked request to non-whitelisted path: %s", r.URL.Path)
+				if _, allowed := matchRoutePattern(whitelistedPathsLookup, r.URL.Path); !allowed {
+					slog.WarnContext(r.Context(), "Blocked request to non-whitelisted path", "path", r.URL.Path)
 					http.Error(w, "Forbidden", http.StatusForbidden)
 					return
 				}
@@ -109,7 +139,87 @@ func WithWhitelistedPaths(whitelist []string) Middleware {
 	}
 }
 
-// WithConditionalAuth checks authorization header only to paths that are not in the blacklist
+// WithAllowedMethods responds 405 to methods not allowed for the path.
+// Patterns ending with /* match any path below the prefix, the most specific matching pattern applies.
+func WithAllowedMethods(routeMethods map[string][]string) Middleware {
+	allowedMethods := make(map[string]map[string]struct{}, len(routeMethods))
+	allowHeaders := make(map[string]string, len(routeMethods))
+	for pattern, methods := range routeMethods {
+		lookup := make(map[string]struct{}, len(methods))
+		for _, method := range methods {
+			lookup[strings.ToUpper(method)] = struct{}{}
+		}
+		allowedMethods[pattern] = lookup
+		allowHeaders[pattern] = strings.ToUpper(strings.Join(methods, ", "))
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(allowedMethods) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				pattern, ok := matchRoutePattern(allowedMethods, r.URL.Path)
+				if ok {
+					if _, allowed := allowedMethods[pattern][r.Method]; !allowed {
+						w.Header().Set("Allow", allowHeaders[pattern])
+						http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// matchRoutePattern returns the exact pattern of the path or else the longest matching prefix pattern ending with /*
+func matchRoutePattern[T any](patterns map[string]T, path string) (string, bool) {
+	if _, ok := patterns[path]; ok {
+		return path, true
+	}
+
+	longest := ""
+	for pattern := range patterns {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if isPrefix && strings.HasPrefix(path, prefix) && len(pattern) > len(longest) {
+			longest = pattern
+		}
+	}
+
+	return longest, longest != ""
+}
+
+type clientKey struct{}
+
+// ClientFromContext returns the registered client the request was authorized as
+func ClientFromContext(ctx context.Context) (auth.Client, bool) {
+	client, ok := ctx.Value(clientKey{}).(auth.Client)
+	return client, ok
+}
+
+// ClientCertificateName returns the common name of the client certificate verified by mutual TLS
+func ClientCertificateName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+	return name, name != ""
+}
+
+// requestClientName returns the name the client identifies itself by, its verified certificate wins over the Authorization header
+func requestClientName(r *http.Request) string {
+	if name, ok := ClientCertificateName(r); ok {
+		return name
+	}
+
+	return r.Header.Get("Authorization")
+}
+
+// WithConditionalAuth checks authorization header only to paths that are not in the blacklist, paths ending with /* exclude everything below the prefix.
+// Paths prefixed with a method, e.g. "GET /clients/*", exclude only requests with that method. A client certificate verified by mutual TLS names the client instead of the header. Authorized clients are stored in the request context
 func WithConditionalAuth(blacklistedPaths []string, authHandler *auth.AuthHandler) Middleware {
 	blacklistedPathsLookup := make(map[string]struct{})
 	for _, path := range blacklistedPaths {
@@ -120,33 +230,180 @@ func WithConditionalAuth(blacklistedPaths []string, authHandler *auth.AuthHandle
 		return http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
 				// Skip auth for excluded paths
-				if _, isExcluded := blacklistedPathsLookup[r.URL.Path]; isExcluded {
+				_, isExcluded := matchRoutePattern(blacklistedPathsLookup, r.Method+" "+r.URL.Path)
+				if !isExcluded {
+					_, isExcluded = matchRoutePattern(blacklistedPathsLookup, r.URL.Path)
+				}
+				if isExcluded {
 					next.ServeHTTP(w, r)
 					return
 				}
 
-				if r.Header.Get("Authorization") == "" {
-					log.Printf("Empty authorization header for path: %s", r.URL.Path)
+				name := requestClientName(r)
+				if name == "" {
+					slog.WarnContext(r.Context(), "Empty authorization header", "path", r.URL.Path)
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
 					return
 				}
 
-				if !authHandler.VerifyRegistered(r.Header.Get("Authorization")) {
-					log.Printf("Unauthorized request to path: %s", r.URL.Path)
+				client, ok := authHandler.GetClient(name)
+				if !ok {
+					slog.WarnContext(r.Context(), "Unauthorized request", "path", r.URL.Path)
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
 					return
 				}
 
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+			},
+		)
+	}
+}
+
+// SessionExpiresInHeader tells a client how many seconds remain until its session expires
+const SessionExpiresInHeader = "X-Session-Expires-In"
+
+// WithSessionExpiryWarning adds X-Session-Expires-In to responses of registered clients whose session expires within threshold
+func WithSessionExpiryWarning(threshold time.Duration, authHandler *auth.AuthHandler) Middleware {
+	return func(next http.Handler) http.Handler {
+		if threshold <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if name := requestClientName(r); name != "" {
+					if expiresIn, ok := authHandler.SessionExpiresIn(name); ok && expiresIn <= threshold {
+						w.Header().Set(SessionExpiresInHeader, strconv.Itoa(int(expiresIn.Seconds())))
+					}
+				}
+
 				next.ServeHTTP(w, r)
 			},
 		)
 	}
 }
 
+// hopByHopHeaders are meaningful only for a single transport-level connection and must not be forwarded
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// spoofableHeaders are set by proxies in front of the balancer and are trusted only if the peer is a trusted proxy,
+// X-Request-ID is not among them as WithRequestID already replaced it unless a trusted proxy set it
+var spoofableHeaders = []string{
+	"X-Real-Ip",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Port",
+	"Forwarded",
+}
+
+// WithSanitizedHeaders strips hop-by-hop headers except TE: trailers, denied headers and spoofable headers from requests not coming from trusted proxies
+func WithSanitizedHeaders(trustedProxies []netip.Prefix, deniedHeaders []string) Middleware {
+	// canonicalize once so the hot path can delete from the header map directly
+	canonicalDeniedHeaders := make([]string, 0, len(deniedHeaders))
+	for _, name := range deniedHeaders {
+		canonicalDeniedHeaders = append(canonicalDeniedHeaders, http.CanonicalHeaderKey(name))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// gRPC needs TE: trailers end to end, it is kept as httputil.ReverseProxy keeps it
+				keepTE := httpguts.HeaderValuesContainsToken(r.Header["Te"], "trailers")
+				// headers listed in Connection are hop-by-hop as well
+				for _, value := range r.Header.Values("Connection") {
+					for _, name := range strings.Split(value, ",") {
+						if name = strings.TrimSpace(name); name != "" {
+							r.Header.Del(name)
+						}
+					}
+				}
+				for _, name := range hopByHopHeaders {
+					delete(r.Header, name)
+				}
+				if keepTE {
+					r.Header.Set("Te", "trailers")
+				}
+
+				for _, name := range canonicalDeniedHeaders {
+					delete(r.Header, name)
+				}
+
+				if !isTrustedProxy(r.RemoteAddr, trustedProxies) {
+					for _, name := range spoofableHeaders {
+						delete(r.Header, name)
+					}
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// ParseTrustedProxies parses CIDR ranges or single addresses of proxies allowed to set forwarding headers
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing trusted proxy %q: %w", v, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing trusted proxy %q: %w", v, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func isTrustedProxy(remoteAddr string, trustedProxies []netip.Prefix) bool {
+	if len(trustedProxies) == 0 {
+		return false
+	}
+
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxCapturedBodySize bounds the response body kept for the access log, sanitizeBody logs less anyway
+const maxCapturedBodySize = 4096
+
+// responseWriter captures the status and the start of the body for the access log. Server-sent event streams are not
+// captured and every event is flushed right away, buffering would hold events back until the stream ends.
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode  int
 	wroteHeader bool
+	streaming   bool
 	body        *bytes.Buffer
 }
 
@@ -157,6 +414,11 @@ func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
 	}
 }
 
+// Unwrap exposes the underlying writer so http.ResponseController can flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) Status() int {
 	return rw.statusCode
 }
@@ -164,11 +426,48 @@ func (rw *responseWriter) Status() int {
 func (rw *responseWriter) WriteHeader(code int) {
 	if !rw.wroteHeader {
 		rw.statusCode = code
+		rw.streaming = isEventStream(rw.Header())
 		rw.ResponseWriter.WriteHeader(code)
 		rw.wroteHeader = true
 	}
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.streaming {
+		n, err := rw.ResponseWriter.Write(b)
+		if err == nil {
+			err = http.NewResponseController(rw.ResponseWriter).Flush()
+			if errors.Is(err, http.ErrNotSupported) {
+				err = nil
+			}
+		}
+		return n, err
+	}
+	if room := maxCapturedBodySize - rw.body.Len(); room > 0 {
+		rw.body.Write(b[:min(len(b), room)])
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+// loggedBody is the captured response body, event streams are logged as streamed
+func (rw *responseWriter) loggedBody() string {
+	if rw.streaming {
+		return "streamed"
+	}
+
+	return sanitizeBody(rw.body.String())
+}
+
+// isEventStream reports whether the response is a stream of server-sent events
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
 func readBody(r *http.Request) (string, error) {
 	if r.Body == nil {
 		return "", nil
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/javor454/balancer/lifecycle"
+)
+
+const (
+	// MirrorHeader marks shadow requests so the shadow backend can tell them from real traffic
+	MirrorHeader = "X-Balancer-Mirror"
+
+	defaultMirrorTimeout     = 10 * time.Second
+	defaultMirrorConcurrency = 100
+	defaultMirrorBodySize    = 1 << 20
+)
+
+// MirrorConfig copies Percent of proxied requests to a shadow backend at URL, its responses are discarded and its failures
+// never affect clients. Mirrors in flight are bounded by MaxConcurrent, further ones are dropped. Empty URL disables it.
+type MirrorConfig struct {
+	URL           string
+	Percent       float64
+	Timeout       time.Duration // of a mirrored request, 10s by default
+	MaxConcurrent int           // 100 by default
+	MaxBodySize   ByteSize      // requests with larger or streamed bodies are not mirrored, 1MB by default
+}
+
+// MirrorStats counts mirrored requests since start
+type MirrorStats struct {
+	Mirrored uint64 `json:"mirrored"`
+	Dropped  uint64 `json:"dropped"` // skipped because too many mirrors were in flight
+	Failed   uint64 `json:"failed"`
+}
+
+var mirrorStats struct {
+	mirrored atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// MirroredRequests returns the mirror statistics
+func MirroredRequests() MirrorStats {
+	return MirrorStats{Mirrored: mirrorStats.mirrored.Load(), Dropped: mirrorStats.dropped.Load(), Failed: mirrorStats.failed.Load()}
+}
+
+// Validate checks the shadow URL and the percentage
+func (c MirrorConfig) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if target, err := url.Parse(c.URL); err != nil || target.Host == "" {
+		return errors.New("mirror requires an absolute shadow backend URL")
+	}
+	if c.Percent <= 0 || c.Percent > 100 {
+		return errors.New("mirror percentage must be above 0 and at most 100")
+	}
+
+	return nil
+}
+
+// shadowRequests tracks mirrored requests in flight so shutdown waits for them and recovers their panics
+type shadowRequests struct {
+	background lifecycle.Group
+	ctx        context.Context // cancels mirrored requests still running once shutdown stops waiting for them
+	cancel     context.CancelFunc
+}
+
+func newShadowRequests() *shadowRequests {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shadowRequests{ctx: ctx, cancel: cancel}
+}
+
+// wait blocks until mirrored requests finish, those still running once ctx is done are cancelled
+func (s *shadowRequests) wait(ctx context.Context) error {
+	defer s.cancel()
+
+	return s.background.Wait(ctx)
+}
+
+// withMirroring sends copies of sampled requests to the shadow backend in the background
+func withMirroring(config MirrorConfig, shadows *shadowRequests, next http.Handler) http.Handler {
+	if config.URL == "" {
+		return next
+	}
+	target, _ := url.Parse(config.URL)
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+	maxBodySize := int64(config.MaxBodySize)
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMirrorBodySize
+	}
+	maxConcurrent := config.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMirrorConcurrency
+	}
+	slots := make(chan struct{}, maxConcurrent)
+	httpClient := &http.Client{Timeout: timeout}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64()*100 >= config.Percent || r.ContentLength < 0 || r.ContentLength > maxBodySize {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.ContentLength > 0 {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				http.Error(w, "Error reading request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		select {
+		case slots <- struct{}{}:
+			mirrorStats.mirrored.Add(1)
+			shadow := newMirrorRequest(r, target, body)
+			shadows.background.Go(func() {
+				defer func() { <-slots }()
+				ctx, cancel := context.WithCancel(shadow.Context())
+				defer cancel()
+				stop := context.AfterFunc(shadows.ctx, cancel)
+				defer stop()
+
+				mirror(httpClient, shadow.WithContext(ctx))
+			})
+		default:
+			mirrorStats.dropped.Add(1)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newMirrorRequest copies the request for the shadow backend, it outlives the client request
+func newMirrorRequest(r *http.Request, target *url.URL, body []byte) *http.Request {
+	shadow := r.Clone(context.WithoutCancel(r.Context()))
+	shadow.RequestURI = ""
+	shadow.URL.Scheme = target.Scheme
+	shadow.URL.Host = target.Host
+	// joining onto a target without a path would leave the path relative
+	shadow.URL.Path = strings.TrimSuffix(target.Path, "/") + r.URL.Path
+	shadow.Host = target.Host
+	shadow.Body = io.NopCloser(bytes.NewReader(body))
+	shadow.ContentLength = int64(len(body))
+	shadow.Header.Set(MirrorHeader, "1")
+	for _, header := range hopByHopHeaders {
+		shadow.Header.Del(header)
+	}
+
+	return shadow
+}
+
+func mirror(httpClient *http.Client, shadow *http.Request) {
+	resp, err := httpClient.Do(shadow)
+	if err != nil {
+		mirrorStats.failed.Add(1)
+		logDebugContext(shadow.Context(), "Mirrored request failed", "path", shadow.URL.Path, "error", err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		mirrorStats.failed.Add(1)
+		logDebugContext(shadow.Context(), "Shadow backend failed", "path", shadow.URL.Path, "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ListenOperationalSignals toggles verbose logging on SIGUSR1 and pauses/resumes health checks of all pools on SIGUSR2 until
+// ctx is done. The first pool is toggled and the others follow it so they stay in step.
+func ListenOperationalSignals(ctx context.Context, pools []ServerPool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				slog.Info("Received operational signal", "signal", sig)
+				switch sig {
+				case syscall.SIGUSR1:
+					ToggleVerboseLogging()
+				case syscall.SIGUSR2:
+					if len(pools) == 0 {
+						continue
+					}
+					paused := pools[0].ToggleHealthChecksPaused()
+					for _, pool := range pools[1:] {
+						pool.SetHealthChecksPaused(paused)
+					}
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+)
+
+// PassiveHealthCheckConfig marks a backend down as soon as Failures of its proxied requests fail within Window,
+// a failure is a 5xx response or a transport error. Active health checks bring it back. Zero Failures disables it.
+type PassiveHealthCheckConfig struct {
+	Failures int
+	Window   time.Duration
+}
+
+// recordPassiveFailure counts a failed request proxied to s and takes s out of rotation once the threshold is reached
+func (p *ProxyServerPool) recordPassiveFailure(s *server, reason string) {
+	if s.passiveFailures == nil {
+		return
+	}
+
+	now := time.Now()
+	s.passiveFailures.add(now)
+	failures := s.passiveFailures.list()
+	if len(failures) < p.passiveHealthCheck.Failures || now.Sub(failures[len(failures)-1]) > p.passiveHealthCheck.Window {
+		return
+	}
+
+	if !s.alive.Swap(false) {
+		return
+	}
+	s.passiveFailures.reset()
+	s.healthHistory.add(HealthCheckResult{Time: now, Healthy: false, Error: "passive: " + reason})
+	slog.Warn("Backend failed too many requests, marking it down", "backend", s.url.String(), "failures", len(failures), "window", p.passiveHealthCheck.Window, "reason", reason)
+
+	p.refreshHealthyServers()
+	p.drain(s)
+}
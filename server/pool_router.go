@@ -0,0 +1,212 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/javor454/balancer/auth"
+)
+
+var ErrUnknownPool = errors.New("unknown backend pool")
+
+// DarkLaunchConfig routes requests of internal testers to a designated pool regardless of normal selection.
+// A request matches if it carries the header (with HeaderValue, any value if empty) or the cookie.
+type DarkLaunchConfig struct {
+	Header      string
+	HeaderValue string
+	Cookie      string
+	Pool        string
+}
+
+// PoolRouter picks the backend pool serving a proxied request
+type PoolRouter struct {
+	defaultPool    ServerPool
+	pools          map[string]ServerPool
+	darkLaunch     DarkLaunchConfig
+	darkLaunchPool ServerPool
+	routeRules     []routeRule
+	contentRoutes  []contentRoute
+	experiments    []experimentRoute
+	trafficSplits  []trafficSplit
+	pinSessions    bool
+	pinsMu         sync.Mutex
+	pins           map[string]sessionPin // keyed by client name
+	lastPinPrune   time.Time
+	switchMu       sync.Mutex
+	redirects      atomic.Pointer[map[ServerPool]ServerPool] // replaced as a whole on switchover, keyed by the pool switched away from
+	lastSwitch     atomic.Pointer[switchover]
+}
+
+// sessionPin keeps a client session on the pool it was first routed to
+type sessionPin struct {
+	pool         ServerPool
+	registeredAt time.Time // a re-registered client starts a new session and may be routed anew
+}
+
+// experimentRoute maps variants of an experiment to pools, variants without a pool use normal selection
+type experimentRoute struct {
+	name         string
+	variantPools map[string]ServerPool
+}
+
+// NewPoolRouter creates a router over named pools, requests not matching any rule go to the default pool.
+// Dark launches take precedence over routing rules, then content routes, experiments and traffic splits follow.
+// With pinSessions a registered client stays on the pool it was first routed to until its session expires,
+// so multi-request workflows are not split across backend versions during a rollout.
+func NewPoolRouter(defaultPool ServerPool, pools map[string]ServerPool, darkLaunch DarkLaunchConfig, routeRules []RouteRuleConfig, contentRoutes []ContentRouteConfig, experiments *Experiments, trafficSplits []TrafficSplitConfig, pinSessions bool) (*PoolRouter, error) {
+	router := &PoolRouter{
+		defaultPool:  defaultPool,
+		pools:        pools,
+		darkLaunch:   darkLaunch,
+		pinSessions:  pinSessions,
+		pins:         make(map[string]sessionPin),
+		lastPinPrune: time.Now(),
+	}
+	router.redirects.Store(&map[ServerPool]ServerPool{})
+
+	if darkLaunch.Pool != "" {
+		pool, ok := pools[darkLaunch.Pool]
+		if !ok {
+			return nil, fmt.Errorf("dark launch: %w: %s", ErrUnknownPool, darkLaunch.Pool)
+		}
+		if darkLaunch.Header == "" && darkLaunch.Cookie == "" {
+			return nil, errors.New("dark launch requires a header or cookie")
+		}
+		router.darkLaunchPool = pool
+	}
+
+	var err error
+	if router.routeRules, err = newRouteRules(routeRules, pools); err != nil {
+		return nil, err
+	}
+	if router.contentRoutes, err = newContentRoutes(contentRoutes, pools); err != nil {
+		return nil, err
+	}
+	if router.trafficSplits, err = newTrafficSplits(trafficSplits, pools); err != nil {
+		return nil, err
+	}
+
+	if experiments != nil {
+		for _, e := range experiments.experiments {
+			route := experimentRoute{name: e.Name, variantPools: make(map[string]ServerPool)}
+			for _, variant := range e.Variants {
+				if variant.Pool == "" {
+					continue
+				}
+				pool, ok := pools[variant.Pool]
+				if !ok {
+					return nil, fmt.Errorf("experiment %s variant %s: %w: %s", e.Name, variant.Name, ErrUnknownPool, variant.Pool)
+				}
+				route.variantPools[variant.Name] = pool
+			}
+			router.experiments = append(router.experiments, route)
+		}
+	}
+
+	return router, nil
+}
+
+// Pools returns the default pool followed by the named pools
+func (rt *PoolRouter) Pools() []ServerPool {
+	pools := make([]ServerPool, 0, len(rt.pools)+1)
+	pools = append(pools, rt.defaultPool)
+	for _, pool := range rt.pools {
+		pools = append(pools, pool)
+	}
+
+	return pools
+}
+
+// Route returns the pool which should serve the request, pools switched away from are replaced by their target
+func (rt *PoolRouter) Route(r *http.Request) ServerPool {
+	return rt.switched(rt.routeSession(r))
+}
+
+func (rt *PoolRouter) routeSession(r *http.Request) ServerPool {
+	if !rt.pinSessions {
+		return rt.route(r)
+	}
+
+	client, ok := ClientFromContext(r.Context())
+	if !ok {
+		return rt.route(r)
+	}
+
+	rt.pinsMu.Lock()
+	defer rt.pinsMu.Unlock()
+
+	if pin, ok := rt.pins[client.Name]; ok && pin.registeredAt.Equal(client.RegisteredAt) {
+		return pin.pool
+	}
+
+	now := time.Now()
+	if now.Sub(rt.lastPinPrune) > auth.SessionTimeout {
+		for name, pin := range rt.pins {
+			if now.Sub(pin.registeredAt) > auth.SessionTimeout {
+				delete(rt.pins, name)
+			}
+		}
+		rt.lastPinPrune = now
+	}
+
+	pool := rt.route(r)
+	rt.pins[client.Name] = sessionPin{pool: pool, registeredAt: client.RegisteredAt}
+
+	return pool
+}
+
+func (rt *PoolRouter) route(r *http.Request) ServerPool {
+	if rt.darkLaunchPool != nil && rt.isDarkLaunch(r) {
+		logDebugContext(r.Context(), "Routing dark launch request", "pool", rt.darkLaunch.Pool)
+		return rt.darkLaunchPool
+	}
+
+	if rule, ok := matchRouteRule(r, rt.routeRules); ok {
+		logDebugContext(r.Context(), "Routing request by rule", "header", rule.config.Header, "pathPrefix", rule.config.PathPrefix, "pool", rule.config.Pool)
+		return rule.pool
+	}
+
+	if len(rt.contentRoutes) > 0 {
+		if route, ok := matchContentRoute(r, rt.contentRoutes); ok {
+			logDebugContext(r.Context(), "Routing request by content", "field", strings.Join(route.path, "."), "pool", route.name)
+			return route.pool
+		}
+	}
+
+	if len(rt.experiments) > 0 {
+		variants := ExperimentVariants(r.Context())
+		for _, route := range rt.experiments {
+			if pool, ok := route.variantPools[variants[route.name]]; ok {
+				return pool
+			}
+		}
+	}
+
+	if split, ok := matchTrafficSplit(r, rt.trafficSplits); ok {
+		logDebugContext(r.Context(), "Routing request by traffic split", "pool", split.name)
+		return split.pool
+	}
+
+	return rt.defaultPool
+}
+
+func (rt *PoolRouter) isDarkLaunch(r *http.Request) bool {
+	if rt.darkLaunch.Header != "" {
+		if value := r.Header.Get(rt.darkLaunch.Header); value != "" && (rt.darkLaunch.HeaderValue == "" || value == rt.darkLaunch.HeaderValue) {
+			return true
+		}
+	}
+
+	if rt.darkLaunch.Cookie != "" {
+		if _, err := r.Cookie(rt.darkLaunch.Cookie); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
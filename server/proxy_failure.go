@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Error classes of failed proxied requests, they tell apart causes the error messages of transports spell differently
+const (
+	ErrorClassCanceled          = "canceled"
+	ErrorClassTimeout           = "timeout"
+	ErrorClassDNS               = "dns"
+	ErrorClassConnectionRefused = "connection-refused"
+	ErrorClassConnectionReset   = "connection-reset"
+	ErrorClassConnect           = "connect"
+	ErrorClassTLS               = "tls"
+	ErrorClassInvalidResponse   = "invalid-response"
+	ErrorClassOther             = "other"
+)
+
+type proxyTraceKey struct{}
+
+// proxyTrace follows a proxied request through backend selection so a failure can be reported with how it came about
+type proxyTrace struct {
+	attempt   int           // 1 for the first attempt
+	queueWait time.Duration // waited for capacity, summed over attempts
+	selection backendSnapshot
+	streaming bool // the backend answered with an event stream, its duration says nothing about backend latency
+}
+
+// backendSnapshot is the state of the backend when it was selected
+type backendSnapshot struct {
+	alive           bool
+	inFlight        int64 // including the request
+	passiveFailures int   // recent failed requests counting towards passive health checks
+}
+
+// withProxyTrace starts tracing the request, attempts are counted by the caller
+func withProxyTrace(r *http.Request) (*http.Request, *proxyTrace) {
+	trace := &proxyTrace{}
+	return r.WithContext(context.WithValue(r.Context(), proxyTraceKey{}, trace)), trace
+}
+
+// proxyTraceFromRequest returns the trace of the request, nil for requests not proxied by the load balancer handler
+func proxyTraceFromRequest(r *http.Request) *proxyTrace {
+	trace, _ := r.Context().Value(proxyTraceKey{}).(*proxyTrace)
+	return trace
+}
+
+func (t *proxyTrace) recordQueueWait(waited time.Duration) {
+	if t != nil {
+		t.queueWait += waited
+	}
+}
+
+func (t *proxyTrace) recordSelection(s *server) {
+	if t == nil {
+		return
+	}
+
+	t.selection = backendSnapshot{alive: s.alive.Load(), inFlight: s.inFlight.Load()}
+	if s.passiveFailures != nil {
+		t.selection.passiveFailures = len(s.passiveFailures.list())
+	}
+}
+
+// logProxyError logs a failed attempt with the state it was made in, the access log gets the error class
+func (p *ProxyServerPool) logProxyError(r *http.Request, proxyError ProxyError) {
+	attrs := []slog.Attr{
+		slog.String("pool", p.name),
+		slog.String("backend", proxyError.Backend),
+		slog.String("errorClass", proxyError.ErrorClass),
+		slog.Bool("retried", proxyError.Retried),
+		slog.String("error", proxyError.Error),
+	}
+	if trace := proxyTraceFromRequest(r); trace != nil {
+		attrs = append(attrs,
+			slog.Int("attempt", trace.attempt),
+			slog.Duration("queueWait", trace.queueWait),
+			slog.Bool("backendAlive", trace.selection.alive),
+			slog.Int64("backendInFlight", trace.selection.inFlight),
+			slog.Int("backendPassiveFailures", trace.selection.passiveFailures),
+		)
+	}
+
+	level := slog.LevelError
+	if proxyError.Retried || proxyError.ErrorClass == ErrorClassCanceled {
+		level = slog.LevelWarn
+	}
+	slog.LogAttrs(r.Context(), level, "Proxy request failed", attrs...)
+	AddRequestLogAttrs(r.Context(), slog.String("errorClass", proxyError.ErrorClass))
+}
+
+// classifyProxyError names the cause of a transport error
+func classifyProxyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, ErrInvalidBackendResponse):
+		return ErrorClassInvalidResponse
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorClassConnectionReset
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &recordErr):
+		return ErrorClassTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrorClassConnect
+	default:
+		return ErrorClassOther
+	}
+}
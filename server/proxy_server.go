@@ -1,118 +1,854 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/javor454/balancer/lifecycle"
 )
 
 var (
-	ErrNoHealthyServers = errors.New("no healthy servers found")
-	ErrNoServers        = errors.New("no servers found")
-	ErrNoCapacity       = errors.New("no capacity available")
+	ErrNoHealthyServers     = errors.New("no healthy servers found")
+	ErrNoServers            = errors.New("no servers found")
+	ErrNoCapacity           = errors.New("no capacity available")
+	ErrQueueFull            = errors.New("capacity queue is full")
+	ErrShuttingDown         = errors.New("balancer is shutting down")
+	ErrUnhealthyBackend     = errors.New("backend failed its health check")
+	ErrStaticBackend        = errors.New("backend is configured statically")
+	ErrPolledBackend        = errors.New("backend is polled and does not push heartbeats")
+	ErrUnknownBackend       = errors.New("unknown backend")
+	ErrMissedHeartbeat      = errors.New("missed heartbeat")
+	ErrUnknownBalancingMode = errors.New("unknown balancing mode")
+	ErrBackendsSaturated    = errors.New("all healthy backends are at their in-flight limit")
 )
 
 // ProxyServerPool manages a pool of backend servers with health checks
 type ProxyServerPool struct {
-	servers                []*server
-	currentServerIndex     int
-	maxCapacity            int
-	capacity               chan struct{}
+	name                   string
+	servers                atomic.Pointer[[]*server] // replaced as a whole when backends register or go away
+	serversMu              sync.Mutex                // serializes changes of servers
+	nextServerID           int
+	healthyServers         atomic.Pointer[[]*server] // snapshot swapped by health checks, read once per request
+	healthyServersMu       sync.Mutex                // serializes snapshot rebuilds so a stale one is never stored last
+	hashRing               atomic.Pointer[hashRing]  // healthy servers in consistent-hash mode, rebuilt with the snapshot
+	balancing              BalancingConfig
+	mode                   atomic.Pointer[string] // of balancing, switchable at runtime
+	healthChecksPaused     atomic.Bool
+	drained                atomic.Bool // set by Drain while the pool receives no traffic, its servers stay draining when they recover
+	background             lifecycle.Group
+	currentServerIndex     atomic.Uint64
+	backendCapacity        BackendCapacityConfig
+	capacity               *fairQueue
+	autoTuner              *autoTuner             // nil unless auto-tuning is configured
+	bandit                 atomic.Pointer[bandit] // nil until balancing in bandit mode, kept when switching away so its estimates survive
+	starvation             *starvationDetector    // nil unless starvation detection is configured
 	acquireCapacityTimeout time.Duration
+	waiting                atomic.Int64 // requests waiting for capacity
+	queueStats             queueStats
+	fairnessStats          fairnessStats
+	requests               atomic.Uint64 // requests which asked for a server since start
+	recentErrors           *ringBuffer[ProxyError]
+	responseValidator      *responseValidator
+	errorBudget            *errorBudget  // nil unless an error budget is configured
+	shuttingDown           chan struct{} // closed by BeginShutdown to release requests waiting for capacity
+	shutdownOnce           sync.Once
+	ctx                    context.Context // lifetime of health checks, also of backends added later
+	healthCheckInterval    time.Duration
+	drainTimeout           time.Duration // requests in flight on a removed or failed server are cancelled after it, 0 never cancels them
+	healthProbe            HealthProbe
+	healthThresholds       HealthCheckThresholds
+	passiveHealthCheck     PassiveHealthCheckConfig
+	backendAuth            *BackendAuth
+	baseTransport          http.RoundTripper // shared by the backends of the pool, used directly for prewarming and health checks
+	transport              http.RoundTripper // carries proxied requests, signs them and counts connection reuse
+	requestSigner          *RequestSigner
+	upstreamTLS            UpstreamTLSConfig // backends with their own settings get their own transports
+	backendProtocol        string
+	affinity               *affinity // nil unless sticky sessions are enabled
+	prewarmConfig          ConnectionPrewarmConfig
+	connectionStats        connectionStats
+}
+
+// BackendStatus is the state of a single backend as seen by the pool, ID is stable for the lifetime of the backend
+type BackendStatus struct {
+	ID             int    `json:"id"`
+	URL            string `json:"url"`
+	Alive          bool   `json:"alive"`
+	Disabled       bool   `json:"disabled"` // out of rotation by an operator regardless of health
+	InFlight       int64  `json:"inFlight"`
+	MaxInFlight    int64  `json:"maxInFlight,omitempty"`
+	Weight         int    `json:"weight"`
+	SelfRegistered bool   `json:"selfRegistered"`
+	PushHeartbeats bool   `json:"pushHeartbeats"`
+}
+
+// ProxyError is a failed proxied request kept for operators
+type ProxyError struct {
+	Time         time.Time     `json:"time"`
+	Backend      string        `json:"backend"`
+	Path         string        `json:"path"`
+	Error        string        `json:"error"`
+	ErrorClass   string        `json:"errorClass"`
+	Attempt      int           `json:"attempt"`      // 1 for the first attempt, 0 if unknown
+	QueueWait    time.Duration `json:"queueWait"`    // waited for capacity, summed over attempts
+	BackendAlive bool          `json:"backendAlive"` // health of the backend when it was selected
+	Retried      bool          `json:"retried"`      // another backend got the request
+}
+
+// HealthCheckResult is a single health check of a backend kept to diagnose flapping
+type HealthCheckResult struct {
+	Time    time.Time     `json:"time"`
+	Latency time.Duration `json:"latency"`
+	Healthy bool          `json:"healthy"`
+	Error   string        `json:"error,omitempty"`
+}
+
+const (
+	// drainPollInterval is how often a draining server is checked for remaining requests
+	drainPollInterval = 100 * time.Millisecond
+	// recentErrorsSize is the number of proxy errors kept for the dashboard
+	recentErrorsSize = 20
+	// healthHistorySize is the number of health check results kept per backend
+	healthHistorySize = 50
+)
+
+// ProxyServerPoolOptions configures a pool created by NewProxyServerPool, zero values disable the optional features
+type ProxyServerPoolOptions struct {
+	Name                   string
+	URLs                   []string
+	PushHeartbeats         map[string]PushHeartbeatConfig // heartbeat settings of backends pushing their health, keyed by URL
+	HealthCheckInterval    time.Duration
+	DrainTimeout           time.Duration
+	HealthProbe            HealthProbe
+	HealthThresholds       HealthCheckThresholds
+	PassiveHealthCheck     PassiveHealthCheckConfig
+	BackendAuth            *BackendAuth
+	RequestSigner          *RequestSigner
+	Prewarm                ConnectionPrewarmConfig
+	ResponseValidation     ResponseValidationConfig
+	ErrorBudget            ErrorBudgetConfig
+	Balancing              BalancingConfig
+	MaxCapacity            int
+	BackendCapacity        BackendCapacityConfig
+	AcquireCapacityTimeout time.Duration
+	MaxQueueDepth          int
+	AutoTune               AutoTuneConfig
+	Starvation             StarvationConfig
+	UpstreamTLS            UpstreamTLSConfig
+	BackendProtocol        string
 }
 
 // NewProxyServerPool creates a new pool of proxy servers with health checking
-func NewProxyServerPool(ctx context.Context, urls []string, healthCheckInterval time.Duration, httpClient *http.Client, maxCapacity int, acquireCapacityTimeout time.Duration) (*ProxyServerPool, error) {
-	servers := make([]*server, 0, len(urls))
-	for _, v := range urls {
-		server, err := newServer(v)
+func NewProxyServerPool(ctx context.Context, options ProxyServerPoolOptions) (*ProxyServerPool, error) {
+	switch options.Balancing.Mode {
+	case "", BalancingRoundRobin, BalancingConsistentHash, BalancingP2C, BalancingBandit:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBalancingMode, options.Balancing.Mode)
+	}
+	switch options.Balancing.HashKey.Source {
+	case "", HashKeyClientIP:
+	case HashKeyHeader, HashKeyCookie:
+		if options.Balancing.HashKey.Name == "" {
+			return nil, fmt.Errorf("hash key %s requires a name", options.Balancing.HashKey.Source)
+		}
+	case HashKeyPath:
+		if options.Balancing.HashKey.Segment < 0 {
+			return nil, errors.New("hash key path segment cannot be negative")
+		}
+	default:
+		return nil, fmt.Errorf("unknown hash key source %s", options.Balancing.HashKey.Source)
+	}
+	switch options.BackendProtocol {
+	case "", BackendProtocolHTTP1, BackendProtocolH2C:
+	default:
+		return nil, fmt.Errorf("unknown backend protocol %s", options.BackendProtocol)
+	}
+	switch options.Balancing.HashFunction {
+	case "", HashFunctionFNV, HashFunctionXXHash, HashFunctionMaglev:
+	default:
+		return nil, fmt.Errorf("unknown hash function %s", options.Balancing.HashFunction)
+	}
+	if options.Balancing.VirtualNodes <= 0 {
+		options.Balancing.VirtualNodes = defaultVirtualNodes
+	}
+
+	p := &ProxyServerPool{
+		name:                   options.Name,
+		errorBudget:            newErrorBudget(options.Name, options.ErrorBudget),
+		balancing:              options.Balancing,
+		backendCapacity:        options.BackendCapacity,
+		capacity:               newFairQueue(options.MaxCapacity, options.MaxQueueDepth),
+		autoTuner:              newAutoTuner(options.AutoTune),
+		starvation:             newStarvationDetector(options.Name, options.Starvation),
+		acquireCapacityTimeout: options.AcquireCapacityTimeout,
+		recentErrors:           newRingBuffer[ProxyError](recentErrorsSize),
+		responseValidator:      newResponseValidator(options.ResponseValidation),
+		shuttingDown:           make(chan struct{}),
+		ctx:                    ctx,
+		healthCheckInterval:    options.HealthCheckInterval,
+		drainTimeout:           options.DrainTimeout,
+		healthThresholds:       options.HealthThresholds,
+		passiveHealthCheck:     options.PassiveHealthCheck,
+		healthProbe:            options.HealthProbe,
+		backendAuth:            options.BackendAuth,
+		baseTransport:          newBaseTransport(options.BackendAuth, options.Prewarm, options.BackendProtocol),
+		prewarmConfig:          options.Prewarm,
+		requestSigner:          options.RequestSigner,
+		upstreamTLS:            options.UpstreamTLS,
+		backendProtocol:        options.BackendProtocol,
+		affinity:               newAffinity(options.Name, options.Balancing.Affinity),
+	}
+	if err := options.UpstreamTLS.applyTo(p.baseTransport); err != nil {
+		return nil, err
+	}
+	mode := cmp.Or(options.Balancing.Mode, BalancingRoundRobin)
+	p.mode.Store(&mode)
+	if mode == BalancingBandit {
+		p.bandit.Store(newBandit(options.Balancing.ExplorationRate))
+	}
+	p.transport = options.RequestSigner.wrap(newTracedTransport(&p.connectionStats, p.baseTransport))
+
+	servers := make([]*server, 0, len(options.URLs))
+	for _, v := range options.URLs {
+		server, err := p.newPoolServer(v, 1)
 		if err != nil {
 			return nil, err
 		}
-		server.startHealthCheck(ctx, healthCheckInterval, httpClient)
+		if heartbeat, ok := options.PushHeartbeats[v]; ok {
+			server.pushHeartbeat = heartbeat
+			server.lastHeartbeat.Store(time.Now().UnixNano())
+		}
 		servers = append(servers, server)
 	}
+	p.servers.Store(&servers)
+	p.refreshHealthyServers()
 
-	return &ProxyServerPool{
-		servers:                servers,
-		currentServerIndex:     0,
-		maxCapacity:            maxCapacity,
-		capacity:               make(chan struct{}, maxCapacity),
-		acquireCapacityTimeout: acquireCapacityTimeout,
-	}, nil
+	for _, server := range servers {
+		p.startHealthCheck(server)
+		p.startPrewarming(server)
+	}
+
+	if p.errorBudget != nil && options.ErrorBudget.AlertBurnRate > 0 {
+		p.background.Go(func() {
+			p.errorBudget.watch(ctx, &http.Client{Timeout: 10 * time.Second})
+		})
+	}
+	if p.autoTuner != nil {
+		p.background.Go(func() { p.autoTune(ctx) })
+	}
+	if p.starvation != nil {
+		p.background.Go(func() {
+			p.starvation.watch(ctx, p.capacity, &http.Client{Timeout: 10 * time.Second})
+		})
+	}
+
+	return p, nil
 }
 
-// NextServer returns the next available server in a round-robin fashion, in case there are no healthy servers, it returns an error
-func (p *ProxyServerPool) NextServer(ctx context.Context) (http.Handler, error) {
-	if err := p.AcquireCapacityWithTimeout(ctx, p.acquireCapacityTimeout); err != nil {
+// newPoolServer creates a backend whose responses are validated and whose errors are recorded by the pool
+func (p *ProxyServerPool) newPoolServer(rawUrl string, weight int) (*server, error) {
+	baseTransport, transport, err := p.backendTransports(rawUrl)
+	if err != nil {
 		return nil, err
 	}
+	server, err := newServer(rawUrl, p.backendAuth, transport)
+	if err != nil {
+		return nil, err
+	}
+	server.baseTransport = baseTransport
+
+	server.id = p.nextServerID
+	p.nextServerID++
+	server.weight.Store(int64(weight))
+	server.maxInFlight = int64(p.backendCapacity.limit(rawUrl))
+	if p.passiveHealthCheck.Failures > 0 {
+		server.passiveFailures = newRingBuffer[time.Time](p.passiveHealthCheck.Failures)
+	}
+
+	server.reverseProxy.ModifyResponse = func(resp *http.Response) error {
+		p.affinity.pin(resp, server)
+		if trace := proxyTraceFromRequest(resp.Request); trace != nil && isEventStream(resp.Header) {
+			trace.streaming = true
+		}
+		if err := p.responseValidator.validate(resp); err != nil {
+			return err // counted by the error handler
+		}
+		p.errorBudget.record(resp.StatusCode >= http.StatusInternalServerError)
+		p.bandit.Load().recordResult(server, resp.StatusCode >= http.StatusInternalServerError)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			p.autoTuner.recordError()
+			p.recordPassiveFailure(server, resp.Status)
+		}
+		return nil
+	}
+
+	errorHandler := server.reverseProxy.ErrorHandler
+	server.reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		p.errorBudget.record(true)
+		p.autoTuner.recordError()
+		p.bandit.Load().recordResult(server, true)
+
+		proxyError := ProxyError{Time: time.Now(), Backend: server.url.String(), Path: r.URL.Path, Error: err.Error(), ErrorClass: classifyProxyError(err)}
+		if trace := proxyTraceFromRequest(r); trace != nil {
+			proxyError.Attempt, proxyError.QueueWait, proxyError.BackendAlive = trace.attempt, trace.queueWait, trace.selection.alive
+		}
+		// neither clients going away nor invalid responses say the backend is unreachable
+		attempt := retryAttemptFromRequest(r)
+		unreachable := !errors.Is(err, context.Canceled) && !errors.Is(err, ErrInvalidBackendResponse)
+		// nothing was written yet, another backend gets the request
+		proxyError.Retried = unreachable && attempt != nil && attempt.remaining > 0
+		p.recentErrors.add(proxyError)
+		p.logProxyError(r, proxyError)
+
+		if unreachable {
+			p.recordPassiveFailure(server, err.Error())
+		}
+		if proxyError.Retried {
+			attempt.err = err
+			return
+		}
+		if errors.Is(err, ErrInvalidBackendResponse) {
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+			return
+		}
+		errorHandler(w, r, err)
+	}
+
+	return server, nil
+}
+
+// backendTransports returns the transports of the pool, or new ones for a backend with its own upstream TLS settings
+func (p *ProxyServerPool) backendTransports(rawUrl string) (base http.RoundTripper, proxied http.RoundTripper, err error) {
+	config, own := p.upstreamTLS.forBackend(rawUrl)
+	if !own {
+		return p.baseTransport, p.transport, nil
+	}
+
+	base = newBaseTransport(p.backendAuth, p.prewarmConfig, p.backendProtocol)
+	if err := config.applyTo(base); err != nil {
+		return nil, nil, fmt.Errorf("backend %s: %w", rawUrl, err)
+	}
 
-	log.Printf("Looking for a healthy server...")
-	sumBackends := len(p.servers)
+	return base, p.requestSigner.wrap(newTracedTransport(&p.connectionStats, base)), nil
+}
+
+// RegisterBackend admits a self-registered backend once it passes a health check, registering an existing backend again counts as a heartbeat.
+// The backend is removed when no heartbeat arrives within heartbeatTTL.
+func (p *ProxyServerPool) RegisterBackend(ctx context.Context, rawUrl string, weight int, heartbeatTTL time.Duration) (BackendStatus, error) {
+	if status, found, err := p.heartbeat(rawUrl, weight); found {
+		return status, err
+	}
+
+	if p.ctx.Err() != nil {
+		return BackendStatus{}, ErrShuttingDown
+	}
 
-	if sumBackends == 0 {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return BackendStatus{}, fmt.Errorf("error parsing url: %w", err)
+	}
+	baseTransport, _, err := p.backendTransports(rawUrl)
+	if err != nil {
+		return BackendStatus{}, err
+	}
+	if err := p.healthProbe.Check(withProbeTransport(ctx, baseTransport), parsedUrl); err != nil {
+		p.healthProbe.Release(parsedUrl)
+		return BackendStatus{}, fmt.Errorf("%w: %w", ErrUnhealthyBackend, err)
+	}
+
+	p.serversMu.Lock()
+	defer p.serversMu.Unlock()
+
+	// another registration of the same backend may have won while probing
+	if status, found, err := p.heartbeatLocked(rawUrl, weight); found {
+		return status, err
+	}
+
+	server, err := p.newPoolServer(rawUrl, weight)
+	if err != nil {
+		return BackendStatus{}, err
+	}
+	server.heartbeatTTL = heartbeatTTL
+	server.lastHeartbeat.Store(time.Now().UnixNano())
+
+	servers := append(slices.Clone(*p.servers.Load()), server)
+	p.servers.Store(&servers)
+	p.refreshHealthyServers()
+	p.startHealthCheck(server)
+	p.startPrewarming(server)
+	slog.Info("Backend registered itself", "backend", rawUrl, "weight", weight)
+
+	return server.status(), nil
+}
+
+// heartbeat refreshes a self-registered backend, found is false if the backend is not in the pool
+func (p *ProxyServerPool) heartbeat(rawUrl string, weight int) (status BackendStatus, found bool, err error) {
+	p.serversMu.Lock()
+	defer p.serversMu.Unlock()
+
+	return p.heartbeatLocked(rawUrl, weight)
+}
+
+func (p *ProxyServerPool) heartbeatLocked(rawUrl string, weight int) (BackendStatus, bool, error) {
+	for _, s := range *p.servers.Load() {
+		if s.url.String() != rawUrl {
+			continue
+		}
+		if s.heartbeatTTL == 0 {
+			return BackendStatus{}, true, ErrStaticBackend
+		}
+
+		s.lastHeartbeat.Store(time.Now().UnixNano())
+		if s.weight.Swap(int64(weight)) != int64(weight) {
+			p.refreshHealthyServers()
+		}
+
+		return s.status(), true, nil
+	}
+
+	return BackendStatus{}, false, nil
+}
+
+// Heartbeat records a pushed heartbeat of a backend, a push backend which was marked down comes back up
+func (p *ProxyServerPool) Heartbeat(rawUrl string) (BackendStatus, error) {
+	for _, s := range *p.servers.Load() {
+		if s.url.String() != rawUrl {
+			continue
+		}
+		if s.pushHeartbeat.Interval == 0 && s.heartbeatTTL == 0 {
+			return BackendStatus{}, ErrPolledBackend
+		}
+
+		s.lastHeartbeat.Store(time.Now().UnixNano())
+		if s.pushHeartbeat.Interval > 0 && !s.alive.Swap(true) {
+			slog.Info("Heartbeat received, marking backend up", "backend", s.url.String())
+			p.refreshHealthyServers()
+			p.undrain(s)
+		}
+
+		return s.status(), nil
+	}
+
+	return BackendStatus{}, ErrUnknownBackend
+}
+
+// removeServer drops a backend from the pool and stops its health checks
+func (p *ProxyServerPool) removeServer(s *server) {
+	p.serversMu.Lock()
+	defer p.serversMu.Unlock()
+
+	servers := slices.DeleteFunc(slices.Clone(*p.servers.Load()), func(candidate *server) bool { return candidate == s })
+	p.servers.Store(&servers)
+	p.refreshHealthyServers()
+	p.bandit.Load().forget(s)
+	p.healthProbe.Release(s.url)
+	p.drain(s)
+}
+
+// drain lets requests in flight on a server no longer receiving new ones finish, those still running after the drain timeout are cancelled.
+// A server already draining keeps its deadline, undrain stops the drain once the server is back in rotation.
+func (p *ProxyServerPool) drain(s *server) {
+	if p.drainTimeout <= 0 || s.inFlight.Load() == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	if !s.stopDrain.CompareAndSwap(nil, &cancel) {
+		cancel()
+		return
+	}
+
+	p.background.Go(func() {
+		defer cancel()
+		defer s.stopDrain.CompareAndSwap(&cancel, nil)
+
+		deadline := time.NewTimer(p.drainTimeout)
+		defer deadline.Stop()
+		poll := time.NewTicker(drainPollInterval)
+		defer poll.Stop()
+
+		slog.Info("Draining requests in flight", "backend", s.url.String(), "inFlight", s.inFlight.Load())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-poll.C:
+				if s.inFlight.Load() == 0 {
+					slog.Info("Drained backend", "backend", s.url.String())
+					return
+				}
+			case <-deadline.C:
+				slog.Warn("Drain timeout exceeded, cancelling requests in flight", "backend", s.url.String(), "inFlight", s.inFlight.Load())
+				// a fresh signal lets the server serve again should it recover
+				s.teardown.Swap(newTeardownSignal()).cancel()
+				return
+			}
+		}
+	})
+}
+
+// undrain stops draining a server back in rotation, requests it accepted since must not be cancelled by the old deadline
+func (p *ProxyServerPool) undrain(s *server) {
+	if !s.alive.Load() || s.disabled.Load() || p.drained.Load() {
+		return
+	}
+	if stop := s.stopDrain.Swap(nil); stop != nil {
+		slog.Info("Backend back in rotation, stopped draining it", "backend", s.url.String())
+		(*stop)()
+	}
+}
+
+// Drain drains every server of the pool
+func (p *ProxyServerPool) Drain() {
+	p.drained.Store(true)
+	for _, s := range *p.servers.Load() {
+		p.drain(s)
+	}
+}
+
+// CancelDrain stops draining the servers of a pool receiving traffic again, those out of rotation keep draining
+func (p *ProxyServerPool) CancelDrain() {
+	p.drained.Store(false)
+	for _, s := range *p.servers.Load() {
+		p.undrain(s)
+	}
+}
+
+// NextServer leases capacity and a healthy server for the request chosen by the balancing mode, in case there are no healthy servers,
+// it returns an error and the capacity is released right away
+func (p *ProxyServerPool) NextServer(r *http.Request) (*Lease, error) {
+	p.requests.Add(1)
+	queuedAt := time.Now()
+	err := p.AcquireCapacityWithTimeout(r.Context(), queueTimeout(r, p.acquireCapacityTimeout))
+	proxyTraceFromRequest(r).recordQueueWait(time.Since(queuedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	logDebugContext(r.Context(), "Looking for a healthy server")
+	if len(*p.servers.Load()) == 0 {
+		p.ReleaseCapacity()
 		return nil, ErrNoServers
 	}
 
-	for range sumBackends * 2 {
-		server := p.servers[p.currentServerIndex]
-		p.currentServerIndex = (p.currentServerIndex + 1) % sumBackends
+	healthyServers := *p.healthyServers.Load()
+	if len(healthyServers) == 0 {
+		p.ReleaseCapacity()
+		return nil, ErrNoHealthyServers
+	}
+
+	mode := p.BalancingMode()
+	server := p.affinity.pinned(r, healthyServers)
+	if server == nil && mode == BalancingConsistentHash {
+		// requests without the key attribute cannot be pinned and fall back to round-robin,
+		// so do all requests right after switching to the mode until the ring is built
+		if key := requestHashKey(r, p.balancing.HashKey); key != "" {
+			if ring := p.hashRing.Load(); ring != nil {
+				server = ring.get(key)
+			}
+		}
+	}
+	if server == nil && mode == BalancingP2C {
+		server = leastLoadedOfTwo(healthyServers)
+	}
+	explored := false
+	bandit := p.bandit.Load()
+	if server == nil && mode == BalancingBandit {
+		server, explored = bandit.pick(healthyServers)
+	}
+	if server == nil {
+		server = healthyServers[(p.currentServerIndex.Add(1)-1)%uint64(len(healthyServers))]
+	}
+	attempt := retryAttemptFromRequest(r)
+	if attempt != nil {
+		server = attempt.untried(healthyServers, server)
+	}
+	if !server.reserve() {
+		// the chosen server is saturated, any other one with room left beats failing the request
+		if server = reserveAnother(healthyServers, attempt); server == nil {
+			p.ReleaseCapacity()
+			return nil, ErrBackendsSaturated
+		}
+	}
+	proxyTraceFromRequest(r).recordSelection(server)
+	logDebugContext(r.Context(), "Using server", "backend", server.url.String())
+	AddRequestLogAttrs(r.Context(), slog.String("backend", server.url.String()))
+	p.fairnessStats.recordBackend(server.url.String(), int(server.weight.Load()))
+
+	// capacity stays leased until the response ends, for event streams as long as the stream lasts
+	leasedAt := time.Now()
+	trace := proxyTraceFromRequest(r)
+	return NewLease(server, func() {
+		server.inFlight.Add(-1)
+		if trace == nil || !trace.streaming {
+			p.autoTuner.recordLatency(time.Since(leasedAt))
+			bandit.recordLatency(server, time.Since(leasedAt), explored)
+		}
+		p.ReleaseCapacity()
+	}), nil
+}
+
+// refreshHealthyServers rebuilds the healthy servers snapshot, called whenever a server changes its health state.
+// A server appears in the snapshot once per unit of weight so round-robin sends it a proportional share.
+func (p *ProxyServerPool) refreshHealthyServers() {
+	p.healthyServersMu.Lock()
+	defer p.healthyServersMu.Unlock()
 
-		if server.IsAlive() {
-			log.Printf("Using server %s", server.url.String())
-			return server.reverseProxy, nil
+	servers := *p.servers.Load()
+	healthyServers := make([]*server, 0, len(servers))
+	uniqueHealthyServers := make([]*server, 0, len(servers))
+	for _, server := range servers {
+		if server.IsAlive() && !server.disabled.Load() {
+			uniqueHealthyServers = append(uniqueHealthyServers, server)
+			for range max(server.weight.Load(), 1) {
+				healthyServers = append(healthyServers, server)
+			}
 		}
 	}
 
-	return nil, ErrNoHealthyServers
+	if p.BalancingMode() == BalancingConsistentHash {
+		p.hashRing.Store(newHashRing(uniqueHealthyServers, p.balancing.VirtualNodes, p.balancing.HashFunction))
+	}
+
+	p.healthyServers.Store(&healthyServers)
 }
 
-// AcquireCapacityWithTimeout attempts to acquire a token from the capacity channel with a timeout
+// AcquireCapacityWithTimeout attempts to acquire capacity with a timeout.
+// Under contention capacity is granted by the weight of the client in ctx, requests without a client have the lowest weight.
 func (p *ProxyServerPool) AcquireCapacityWithTimeout(ctx context.Context, timeout time.Duration) error {
 	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	select {
-	case p.capacity <- struct{}{}: // Try to acquire a token
-		return nil
-	case <-timeoutCtx.Done():
-		return ErrNoCapacity // Timeout without acquiring a token
+	queued := p.waiting.Add(1) - 1
+	defer p.waiting.Add(-1)
+
+	client, _ := ClientFromContext(ctx)
+
+	start := time.Now()
+	err := p.capacity.acquire(timeoutCtx, client.Name, client.Weight, p.shuttingDown)
+	waited := time.Since(start)
+	p.queueStats.record(queued, waited, err == nil)
+	if err == nil {
+		p.fairnessStats.recordAdmission(client.Name, client.Weight, waited)
 	}
+
+	return err
 }
 
 func (p *ProxyServerPool) ReleaseCapacity() {
-	select {
-	case <-p.capacity:
-	default: // prevents panics if ReleaseCapacity is called more times than AcquireCapacity
+	p.capacity.release()
+}
+
+// BeginShutdown tells requests waiting for capacity that the balancer is going down instead of letting them wait for a timeout
+func (p *ProxyServerPool) BeginShutdown() {
+	p.shutdownOnce.Do(func() {
+		slog.Info("Releasing requests waiting for capacity", "waiting", p.GetWaiting())
+		close(p.shuttingDown)
+	})
+}
+
+// Shutdown waits for background health checks to stop and releases what the health probe keeps for the backends,
+// the checks stop once the context passed to NewProxyServerPool is cancelled
+func (p *ProxyServerPool) Shutdown(ctx context.Context) error {
+	if err := p.background.Wait(ctx); err != nil {
+		return fmt.Errorf("proxy server pool shutdown failed: %w", err)
+	}
+	for _, s := range *p.servers.Load() {
+		p.healthProbe.Release(s.url)
+	}
+	slog.Info("Proxy server pool shutdown completed", "pool", p.name)
+
+	return nil
+}
+
+// SetHealthChecksPaused pauses or resumes health checks, servers keep their last known state while paused
+func (p *ProxyServerPool) SetHealthChecksPaused(paused bool) {
+	p.healthChecksPaused.Store(paused)
+	slog.Info("Health checks paused changed", "paused", paused)
+}
+
+// ToggleHealthChecksPaused flips the paused state of health checks and returns the new state
+func (p *ProxyServerPool) ToggleHealthChecksPaused() bool {
+	for {
+		current := p.healthChecksPaused.Load()
+		if p.healthChecksPaused.CompareAndSwap(current, !current) {
+			slog.Info("Health checks paused changed", "paused", !current)
+			return !current
+		}
+	}
+}
+
+// HealthChecksPaused reports whether health checks are paused
+func (p *ProxyServerPool) HealthChecksPaused() bool {
+	return p.healthChecksPaused.Load()
+}
+
+// BalancingMode returns the mode backends are currently picked by
+func (p *ProxyServerPool) BalancingMode() string {
+	return *p.mode.Load()
+}
+
+// SetBalancingMode switches the mode backends are picked by, requests already leased keep their backend.
+// State every mode shares carries over, in-flight counts keep steering p2c and the round-robin position is kept.
+// The consistent-hash ring is rebuilt from the healthy servers and bandit estimates survive switching away and back.
+func (p *ProxyServerPool) SetBalancingMode(mode string) error {
+	switch mode {
+	case BalancingRoundRobin, BalancingConsistentHash, BalancingP2C, BalancingBandit:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownBalancingMode, mode)
+	}
+
+	if mode == BalancingBandit {
+		p.bandit.CompareAndSwap(nil, newBandit(p.balancing.ExplorationRate))
+	}
+	previous := p.mode.Swap(&mode)
+	if mode == BalancingConsistentHash {
+		p.refreshHealthyServers()
+	} else {
+		// a ring left behind would be stale once the mode is switched back
+		p.healthyServersMu.Lock()
+		p.hashRing.Store(nil)
+		p.healthyServersMu.Unlock()
+	}
+	if *previous != mode {
+		slog.Info("Balancing mode changed", "pool", p.name, "from", *previous, "to", mode)
+	}
+
+	return nil
+}
+
+// Backends returns the state of all backends in the pool
+func (p *ProxyServerPool) Backends() []BackendStatus {
+	servers := *p.servers.Load()
+	backends := make([]BackendStatus, 0, len(servers))
+	for _, server := range servers {
+		backends = append(backends, server.status())
+	}
+
+	return backends
+}
+
+// HealthHistory returns recent health check results of the backend from newest to oldest
+func (p *ProxyServerPool) HealthHistory(id int) ([]HealthCheckResult, bool) {
+	for _, server := range *p.servers.Load() {
+		if server.id == id {
+			return server.healthHistory.list(), true
+		}
+	}
+
+	return nil, false
+}
+
+// Name returns the name of the pool
+func (p *ProxyServerPool) Name() string {
+	return p.name
+}
+
+// ErrorBudget returns the error budget of the pool over its rolling window, false if no budget is configured
+func (p *ProxyServerPool) ErrorBudget() (ErrorBudgetStatus, bool) {
+	if p.errorBudget == nil {
+		return ErrorBudgetStatus{}, false
+	}
+
+	return p.errorBudget.status(), true
+}
+
+// Bandit returns how bandit selection scores the backends, false unless the pool balanced in bandit mode at some point
+func (p *ProxyServerPool) Bandit() (BanditStats, bool) {
+	bandit := p.bandit.Load()
+	if bandit == nil {
+		return BanditStats{}, false
 	}
+
+	return bandit.stats(p.name), true
+}
+
+// RecentErrors returns the latest proxy errors, newest first
+func (p *ProxyServerPool) RecentErrors() []ProxyError {
+	return p.recentErrors.list()
+}
+
+// QueueStats returns statistics of the capacity queue over the rolling window
+func (p *ProxyServerPool) QueueStats() QueueStats {
+	stats := p.queueStats.stats(p.GetWaiting())
+	stats.StarvingClients, stats.Starvations = p.starvation.snapshot()
+
+	return stats
+}
+
+// Fairness returns how evenly capacity and backends were shared over the rolling window
+func (p *ProxyServerPool) Fairness() FairnessStats {
+	return p.fairnessStats.stats(p.name, p.BalancingMode())
+}
+
+// ConnectionStats returns connection reuse and TLS session resumption counts of proxied requests
+func (p *ProxyServerPool) ConnectionStats() ConnectionStats {
+	return p.connectionStats.snapshot()
+}
+
+// GetWaiting returns the number of requests waiting for capacity
+func (p *ProxyServerPool) GetWaiting() int {
+	return int(p.waiting.Load())
+}
+
+// GetRequests returns the number of requests which asked the pool for a server since start
+func (p *ProxyServerPool) GetRequests() uint64 {
+	return p.requests.Load()
+}
+
+// GetResponseViolations returns the number of backend responses which failed validation
+func (p *ProxyServerPool) GetResponseViolations() uint64 {
+	return p.responseValidator.violations.Load()
 }
 
-// GetMaxCapacity returns the maximum server capacity
+// GetMaxCapacity returns the maximum server capacity, auto-tuning changes it at runtime
 func (p *ProxyServerPool) GetMaxCapacity() int {
-	return p.maxCapacity
+	return p.capacity.capacity()
 }
 
 // GetAvailableCapacity returns the available server capacity
 func (p *ProxyServerPool) GetAvailableCapacity() int {
-	return p.maxCapacity - len(p.capacity)
+	return p.capacity.available()
 }
 
 // server represents a single backend server with health check status
 type server struct {
-	url          *url.URL
-	alive        *atomic.Bool
-	reverseProxy *httputil.ReverseProxy
+	id              int
+	url             *url.URL
+	alive           *atomic.Bool
+	disabled        atomic.Bool  // taken out of rotation by an operator
+	weight          atomic.Int64 // a change is followed by a snapshot refresh
+	reverseProxy    *httputil.ReverseProxy
+	healthHistory   *ringBuffer[HealthCheckResult]
+	passiveFailures *ringBuffer[time.Time]             // times of recent failed requests, nil unless passive health checks are enabled
+	inFlight        atomic.Int64                       // requests leased to the server
+	baseTransport   http.RoundTripper                  // of the pool unless the server has its own upstream TLS settings
+	maxInFlight     int64                              // 0 for no limit
+	teardown        atomic.Pointer[teardownSignal]     // replaced once a drain times out
+	stopDrain       atomic.Pointer[context.CancelFunc] // set while the server drains
+	heartbeatTTL    time.Duration                      // 0 for configured backends, self-registered ones go away without heartbeats
+	lastHeartbeat   atomic.Int64                       // unix nanoseconds
+	pushHeartbeat   PushHeartbeatConfig                // set for backends pushing heartbeats instead of being polled
+}
+
+// PushHeartbeatConfig makes a backend push heartbeats instead of being polled, it is marked down once a heartbeat is later than Interval plus Jitter
+type PushHeartbeatConfig struct {
+	Interval time.Duration
+	Jitter   time.Duration
 }
 
-// newServer creates a new backend server instance
-func newServer(rawUrl string) (*server, error) {
+// newServer creates a new backend server instance, proxied requests carry the backend credentials and signature if configured
+func newServer(rawUrl string, backendAuth *BackendAuth, transport http.RoundTripper) (*server, error) {
 	parsedUrl, err := url.Parse(rawUrl)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing url: %w", err)
@@ -122,40 +858,152 @@ func newServer(rawUrl string) (*server, error) {
 	alive.Store(true)
 
 	reverseProxy := httputil.NewSingleHostReverseProxy(parsedUrl)
+	director := reverseProxy.Director
+	reverseProxy.Director = func(r *http.Request) {
+		director(r)
+		backendAuth.apply(r)
+	}
+	reverseProxy.Transport = transport
+	// failures are logged with the context of the request by the pool
 	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Proxy error: %v", err)
 		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
 	}
 
-	return &server{url: parsedUrl, alive: alive, reverseProxy: reverseProxy}, nil
-}
+	s := &server{url: parsedUrl, alive: alive, reverseProxy: reverseProxy, healthHistory: newRingBuffer[HealthCheckResult](healthHistorySize)}
+	s.teardown.Store(newTeardownSignal())
 
-// startHealthCheck begins periodic health checking of the server
-func (s *server) startHealthCheck(ctx context.Context, healthCheckInterval time.Duration, httpClient *http.Client) {
-	url := fmt.Sprintf("%s/health", s.url.String())
+	return s, nil
+}
 
-	go func() {
-		log.Printf("Starting health check for %s", s.url.String())
-		ticker := time.NewTicker(healthCheckInterval)
+// startHealthCheck begins periodic health checking of the server, the healthy snapshot is rebuilt when the server flips between alive and dead
+// after the configured number of consecutive failed or passed checks.
+// Self-registered servers without a recent heartbeat are removed from the pool.
+func (p *ProxyServerPool) startHealthCheck(s *server) {
+	p.background.Go(func() {
+		slog.Info("Starting health check", "backend", s.url.String())
+		interval := p.healthCheckInterval
+		if s.pushHeartbeat.Interval > 0 {
+			interval = s.pushHeartbeat.Interval
+		}
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
+		// consecutive check results, the server flips once a streak reaches its threshold
+		successes, failures, wasAlive := 0, 0, s.alive.Load()
+
 		for {
 			select {
-			case <-ctx.Done():
-				log.Printf("Health check for %s stopped", s.url.String())
+			case <-p.ctx.Done():
+				slog.Info("Health check stopped", "backend", s.url.String())
 				return
 			case <-ticker.C:
-				resp, err := httpClient.Get(url)
-				if err != nil || resp.StatusCode != http.StatusOK {
-					log.Printf("Health check failed for %s", url)
-					s.alive.Store(false)
+				if s.heartbeatTTL > 0 && time.Since(time.Unix(0, s.lastHeartbeat.Load())) > s.heartbeatTTL {
+					slog.Warn("Backend stopped sending heartbeats, removing it", "backend", s.url.String())
+					p.removeServer(s)
+					return
+				}
+
+				if p.healthChecksPaused.Load() {
+					continue
+				}
+
+				start := time.Now()
+				var err error
+				if s.pushHeartbeat.Interval > 0 {
+					err = s.checkPushedHeartbeat(start)
 				} else {
-					log.Printf("Health check passed for %s", url)
+					err = p.healthProbe.Check(withProbeTransport(p.ctx, s.baseTransport), s.url)
+				}
+				result := HealthCheckResult{Time: start, Latency: time.Since(start), Healthy: err == nil}
+				if err != nil {
+					result.Error = err.Error()
+				}
+				s.healthHistory.add(result)
+
+				if err != nil {
+					slog.Warn("Health check failed", "backend", s.url.String(), "error", err)
+				} else {
+					logDebug("Health check passed", "backend", s.url.String())
+				}
+
+				// passive checks and heartbeats flip the server too, streaks only count towards the state it is not in
+				alive := s.alive.Load()
+				if alive != wasAlive {
+					successes, failures = 0, 0
+				}
+				if err == nil {
+					successes, failures = successes+1, 0
+				} else {
+					successes, failures = 0, failures+1
+				}
+
+				switch {
+				case alive && failures >= p.healthThresholds.fall():
+					s.alive.Store(false)
+					p.refreshHealthyServers()
+					p.drain(s)
+				case !alive && successes >= p.healthThresholds.rise():
 					s.alive.Store(true)
+					p.refreshHealthyServers()
+					p.undrain(s)
+					p.prewarm(s)
 				}
+				wasAlive = s.alive.Load()
 			}
 		}
-	}()
+	})
+}
+
+// teardownSignal cancels the requests in flight on a server which did not drain in time
+type teardownSignal struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newTeardownSignal() *teardownSignal {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &teardownSignal{ctx: ctx, cancel: cancel}
+}
+
+// reserve counts a request leased to the server, it fails once the server has its limit of requests in flight
+func (s *server) reserve() bool {
+	if s.maxInFlight <= 0 {
+		s.inFlight.Add(1)
+		return true
+	}
+
+	for {
+		inFlight := s.inFlight.Load()
+		if inFlight >= s.maxInFlight {
+			return false
+		}
+		if s.inFlight.CompareAndSwap(inFlight, inFlight+1) {
+			return true
+		}
+	}
+}
+
+// ServeHTTP proxies the request to the server, requests in flight are counted by the lease
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.teardown.Load().ctx, cancel)
+	defer stop()
+
+	s.reverseProxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// checkPushedHeartbeat fails if the last pushed heartbeat is overdue
+func (s *server) checkPushedHeartbeat(now time.Time) error {
+	if late := now.Sub(time.Unix(0, s.lastHeartbeat.Load())); late > s.pushHeartbeat.Interval+s.pushHeartbeat.Jitter {
+		return fmt.Errorf("%w: last one %s ago", ErrMissedHeartbeat, late.Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+func (s *server) status() BackendStatus {
+	return BackendStatus{ID: s.id, URL: s.url.String(), Alive: s.IsAlive(), Disabled: s.disabled.Load(), InFlight: s.inFlight.Load(), MaxInFlight: s.maxInFlight, Weight: int(max(s.weight.Load(), 1)), SelfRegistered: s.heartbeatTTL > 0, PushHeartbeats: s.pushHeartbeat.Interval > 0}
 }
 
 // IsAlive returns whether the server is currently considered healthy
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// QueueTimeoutHeader lets a client wait for capacity shorter than the configured timeout, e.g. "2s"
+	QueueTimeoutHeader         = "X-Queue-Timeout"
+	BalancerStatusQueueFull    = "queue-full"
+	BalancerStatusQueueTimeout = "queue-timeout"
+
+	// queueStatsBuckets of queueStatsBucketWidth make up the rolling window of queue statistics
+	queueStatsBuckets     = 60
+	queueStatsBucketWidth = time.Second
+)
+
+// QueueStats describes the capacity queue of a pool over the rolling window, clients may use it to decide whether to queue at all
+type QueueStats struct {
+	Window             string           `json:"window"`
+	Waiting            int              `json:"waiting"`                   // requests waiting right now
+	AverageQueueLength float64          `json:"averageQueueLength"`        // requests found waiting by arriving requests
+	AverageWaitMs      float64          `json:"averageWaitMs"`             // time admitted requests waited for capacity
+	AdmissionRate      float64          `json:"admissionRate"`             // requests admitted per second
+	AdmittedRatio      float64          `json:"admittedRatio"`             // fraction of requests admitted rather than timed out or refused
+	StarvingClients    []StarvingClient `json:"starvingClients,omitempty"` // clients waiting beyond the starvation threshold at the last check
+	Starvations        uint64           `json:"starvations"`               // clients which started starving since start, 0 unless detection is enabled
+}
+
+// queueStats counts capacity requests in a rolling window of buckets
+type queueStats struct {
+	mu      sync.Mutex
+	buckets [queueStatsBuckets]queueStatsBucket
+}
+
+type queueStatsBucket struct {
+	epoch    int64 // bucket index since the unix epoch, stale buckets are reset on use
+	arrivals uint64
+	queued   uint64 // sum of requests found waiting on arrival
+	admitted uint64
+	waited   time.Duration
+}
+
+// record counts a request which found queued requests waiting and waited for capacity, admitted tells whether it got it
+func (s *queueStats) record(queued int64, waited time.Duration, admitted bool) {
+	epoch := time.Now().UnixNano() / int64(queueStatsBucketWidth)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := &s.buckets[epoch%queueStatsBuckets]
+	if bucket.epoch != epoch {
+		*bucket = queueStatsBucket{epoch: epoch}
+	}
+	bucket.arrivals++
+	bucket.queued += uint64(max(queued, 0))
+	if admitted {
+		bucket.admitted++
+		bucket.waited += waited
+	}
+}
+
+func (s *queueStats) stats(waiting int) QueueStats {
+	epoch := time.Now().UnixNano() / int64(queueStatsBucketWidth)
+	window := queueStatsBuckets * queueStatsBucketWidth
+	stats := QueueStats{Window: window.String(), Waiting: waiting, AdmittedRatio: 1}
+
+	var total queueStatsBucket
+	s.mu.Lock()
+	for _, bucket := range s.buckets {
+		if epoch-bucket.epoch < queueStatsBuckets {
+			total.arrivals += bucket.arrivals
+			total.queued += bucket.queued
+			total.admitted += bucket.admitted
+			total.waited += bucket.waited
+		}
+	}
+	s.mu.Unlock()
+
+	if total.arrivals > 0 {
+		stats.AverageQueueLength = float64(total.queued) / float64(total.arrivals)
+		stats.AdmittedRatio = float64(total.admitted) / float64(total.arrivals)
+	}
+	if total.admitted > 0 {
+		stats.AverageWaitMs = float64(total.waited.Microseconds()) / float64(total.admitted) / 1000
+	}
+	stats.AdmissionRate = float64(total.admitted) / window.Seconds()
+
+	return stats
+}
+
+// queueStatsHandler reports the capacity queue of the default pool, the stats change once per bucket so responses may be cached that long
+func queueStatsHandler(proxyServerPool ServerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(queueStatsBucketWidth.Seconds())))
+		writeJSON(w, http.StatusOK, proxyServerPool.QueueStats())
+	}
+}
+
+// queueTimeout returns how long the request may wait for capacity, the client may only shorten the configured timeout
+func queueTimeout(r *http.Request, configured time.Duration) time.Duration {
+	requested, err := time.ParseDuration(r.Header.Get(QueueTimeoutHeader))
+	if err != nil || requested < 0 {
+		return configured
+	}
+
+	return min(requested, configured)
+}
+
+// retryAfterSeconds suggests when a refused request should come back, the average wait of admitted requests but at least a second
+func retryAfterSeconds(stats QueueStats) string {
+	return strconv.Itoa(max(int(math.Ceil(stats.AverageWaitMs/1000)), 1))
+}
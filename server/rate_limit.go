@@ -0,0 +1,75 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BalancerStatusRateLimited marks requests refused by WithRateLimiting
+const BalancerStatusRateLimited = "rate-limited"
+
+// RateLimitConfig admits RequestsPerSecond on average with bursts of up to Burst requests, zero RequestsPerSecond is unlimited.
+// Burst defaults to a second worth of requests.
+type RateLimitConfig struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+func newRateLimitBucket(limit RateLimitConfig) *tokenBucket {
+	if limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = max(math.Ceil(limit.RequestsPerSecond), 1)
+	}
+
+	return &tokenBucket{rate: limit.RequestsPerSecond, burst: burst, tokens: burst, lastFill: time.Now()}
+}
+
+// WithRateLimiting refuses requests beyond the global limit or the limit of the longest matching path prefix with 429,
+// Retry-After tells when a request would be admitted
+func WithRateLimiting(globalLimit RateLimitConfig, routeLimits map[string]RateLimitConfig) Middleware {
+	global := newRateLimitBucket(globalLimit)
+	routeBuckets := make(map[string]*tokenBucket, len(routeLimits))
+	for prefix, limit := range routeLimits {
+		if bucket := newRateLimitBucket(limit); bucket != nil {
+			routeBuckets[prefix] = bucket
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		if global == nil && len(routeBuckets) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				longestPrefix := ""
+				for prefix := range routeBuckets {
+					if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(longestPrefix) {
+						longestPrefix = prefix
+					}
+				}
+
+				for _, bucket := range []*tokenBucket{routeBuckets[longestPrefix], global} {
+					if bucket == nil {
+						continue
+					}
+					if wait, ok := bucket.take(); !ok {
+						logDebugContext(r.Context(), "Rate limited request", "method", r.Method, "path", r.URL.Path, "retryAfter", wait)
+						w.Header().Set(BalancerStatusHeader, BalancerStatusRateLimited)
+						w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+						http.Error(w, "Too many requests", http.StatusTooManyRequests)
+						return
+					}
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// BalancerStatusReadOnly marks requests to mutating endpoints refused in read-only mode
+	BalancerStatusReadOnly = "read-only"
+
+	readOnlyPath = "/admin/read-only"
+)
+
+// readOnly freezes registrations and admin changes during incidents and audits, status reads and proxying continue
+var readOnly atomic.Bool
+
+type readOnlyRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SetReadOnly enables or disables read-only mode
+func SetReadOnly(enabled bool) {
+	readOnly.Store(enabled)
+	slog.Info("Read-only mode changed", "enabled", enabled)
+}
+
+// ReadOnly reports whether read-only mode is enabled
+func ReadOnly() bool {
+	return readOnly.Load()
+}
+
+// mutating refuses requests with 503 while read-only mode is enabled, it wraps every endpoint changing state
+// except backend heartbeats which keep already registered backends in rotation. The read-only switch itself passes
+// so the mode can be left again.
+func mutating(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly.Load() && r.URL.Path != readOnlyPath {
+			w.Header().Set(BalancerStatusHeader, BalancerStatusReadOnly)
+			http.Error(w, "Balancer is in read-only mode", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// readOnlyHandler enables or disables read-only mode, it is the only admin change accepted in read-only mode
+func readOnlyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req readOnlyRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		SetReadOnly(req.Enabled)
+
+		writeJSON(w, http.StatusOK, readOnlyRequest{Enabled: ReadOnly()})
+	}
+}
@@ -14,11 +14,15 @@ type RegisterRequest struct {
 
 type RegisterHandler struct {
 	authHandler *auth.AuthHandler
+	admission   *Admission
+	scopes      ScopeConfig
 }
 
-func NewRegisterHandler(authHandler *auth.AuthHandler) *RegisterHandler {
+func NewRegisterHandler(authHandler *auth.AuthHandler, admission *Admission, scopes ScopeConfig) *RegisterHandler {
 	return &RegisterHandler{
 		authHandler: authHandler,
+		admission:   admission,
+		scopes:      scopes,
 	}
 }
 
@@ -30,9 +34,7 @@ func (h *RegisterHandler) ListRegisteredClientsHandler(w http.ResponseWriter, r
 
 	registeredServers := h.authHandler.ListRegisteredClients()
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(registeredServers)
+	writeJSON(w, http.StatusOK, registeredServers)
 }
 
 func (h *RegisterHandler) RegisterClientHandler(w http.ResponseWriter, r *http.Request) {
@@ -68,8 +70,56 @@ func (h *RegisterHandler) RegisterClientHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	h.authHandler.RegisterClient(req.Name, req.Weight)
+	if MemoryPressure() {
+		w.Header().Set(BalancerStatusHeader, BalancerStatusMemoryPressure)
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Balancer low on memory, registration refused", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch h.admission.Decide(r) {
+	case AdmissionDeny:
+		http.Error(w, "Registration denied", http.StatusForbidden)
+		return
+	case AdmissionDeprioritize:
+		req.Weight = 1
+	}
+
+	h.authHandler.RegisterClient(req.Name, req.Weight, h.scopes.grantedScopes(req.Name))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 }
+
+// GetClientHandler returns a registered client, or 410 with the eviction reason of a recently removed one
+func (h *RegisterHandler) GetClientHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if client, ok := h.authHandler.GetClient(name); ok {
+		writeJSON(w, http.StatusOK, client)
+		return
+	}
+
+	h.writeTombstone(w, name)
+}
+
+// DeregisterClientHandler removes a client, deleting it again or after the session timed out returns 410 with the eviction reason
+func (h *RegisterHandler) DeregisterClientHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if tombstone, ok := h.authHandler.DeregisterClient(name); ok {
+		writeJSON(w, http.StatusOK, tombstone)
+		return
+	}
+
+	h.writeTombstone(w, name)
+}
+
+// writeTombstone responds 410 with the tombstone of a removed client, 404 once it expired or if the client never existed
+func (h *RegisterHandler) writeTombstone(w http.ResponseWriter, name string) {
+	tombstone, ok := h.authHandler.GetTombstone(name)
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusGone, tombstone)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/netip"
+)
+
+const (
+	// RequestIDHeader carries the request ID from clients to backends and back on every response
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength keeps clients from stuffing arbitrary data into log lines
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request set by WithRequestID
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// WithRequestID honors the X-Request-ID set by a trusted proxy or generates one, IDs of other peers are replaced so
+// clients cannot pick the ID their requests are logged under. It is stored in the request context, forwarded to backends
+// and set on the response before any handler runs so error responses carry it as well.
+func WithRequestID(trustedProxies []netip.Prefix) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				id := r.Header.Get(RequestIDHeader)
+				if !validRequestID(id) || !isTrustedProxy(r.RemoteAddr, trustedProxies) {
+					id = newRequestID()
+					r.Header.Set(RequestIDHeader, id)
+				}
+				w.Header().Set(RequestIDHeader, id)
+
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+			},
+		)
+	}
+}
+
+// validRequestID accepts non-empty printable ASCII IDs of reasonable length
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDHandler adds the request ID of the context to every record logged with one
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := RequestIDFromContext(ctx); ok {
+		record.AddAttrs(slog.String("requestId", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader          = "X-Balancer-Signature"
+	SignatureKeyIDHeader     = "X-Balancer-Key-Id"
+	SignatureTimestampHeader = "X-Balancer-Timestamp"
+	SignatureNonceHeader     = "X-Balancer-Nonce"
+	// PreviousSignatureHeader and PreviousSignatureKeyIDHeader carry a second signature with the previous key during
+	// rotation, backends not knowing the active key yet verify that one
+	PreviousSignatureHeader      = "X-Balancer-Previous-Signature"
+	PreviousSignatureKeyIDHeader = "X-Balancer-Previous-Key-Id"
+)
+
+var (
+	ErrMissingSignature  = errors.New("missing request signature")
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+	ErrSignatureExpired  = errors.New("request signature timestamp outside allowed skew")
+	ErrInvalidSignature  = errors.New("invalid request signature")
+	ErrReplayedSignature = errors.New("request signature nonce already seen")
+)
+
+// SigningKey is a shared secret identified by ID so backends can accept several keys during rotation
+type SigningKey struct {
+	ID     string
+	Secret string
+}
+
+// RequestSigningConfig configures HMAC signing of forwarded requests, signing is disabled when no keys are set.
+// Keys are rotated without downtime by making the new key active and the old one previous until every backend knows
+// the new key.
+type RequestSigningConfig struct {
+	ActiveKeyID   string // the first key by default
+	PreviousKeyID string // also signs requests while set
+	Keys          []SigningKey
+}
+
+// RequestSigner signs forwarded requests with the active key and during rotation with the previous key as well
+type RequestSigner struct {
+	key      SigningKey
+	previous *SigningKey
+}
+
+// NewRequestSigner returns nil if signing is not configured
+func NewRequestSigner(config RequestSigningConfig) (*RequestSigner, error) {
+	if len(config.Keys) == 0 {
+		return nil, nil
+	}
+
+	signer := &RequestSigner{key: config.Keys[0]}
+	if config.ActiveKeyID != "" {
+		key, ok := findSigningKey(config.Keys, config.ActiveKeyID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSigningKey, config.ActiveKeyID)
+		}
+		signer.key = key
+	}
+	if config.PreviousKeyID != "" {
+		key, ok := findSigningKey(config.Keys, config.PreviousKeyID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSigningKey, config.PreviousKeyID)
+		}
+		if key.ID == signer.key.ID {
+			return nil, fmt.Errorf("previous signing key %s is the active key", key.ID)
+		}
+		signer.previous = &key
+	}
+
+	return signer, nil
+}
+
+func findSigningKey(keys []SigningKey, id string) (SigningKey, bool) {
+	for _, key := range keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return SigningKey{}, false
+}
+
+// wrap returns a round tripper signing every request before passing it to next
+func (s *RequestSigner) wrap(next http.RoundTripper) http.RoundTripper {
+	if s == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &signingTransport{signer: s, next: next}
+}
+
+type signingTransport struct {
+	signer *RequestSigner
+	next   http.RoundTripper
+}
+
+// RoundTrip signs a clone of the request, a RoundTripper must not modify the request it was given
+func (t *signingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	signed := r.Clone(r.Context())
+	bodyHash, err := hashBody(signed)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing request body: %w", err)
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return nil, fmt.Errorf("error generating signature nonce: %w", err)
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signed.Header.Set(SignatureTimestampHeader, timestamp)
+	signed.Header.Set(SignatureNonceHeader, nonce)
+	signed.Header.Set(SignatureKeyIDHeader, t.signer.key.ID)
+	signed.Header.Set(SignatureHeader, sign(t.signer.key.Secret, timestamp, nonce, r.Method, r.URL.RequestURI(), bodyHash))
+	if previous := t.signer.previous; previous != nil {
+		signed.Header.Set(PreviousSignatureKeyIDHeader, previous.ID)
+		signed.Header.Set(PreviousSignatureHeader, sign(previous.Secret, timestamp, nonce, r.Method, r.URL.RequestURI(), bodyHash))
+	}
+
+	return t.next.RoundTrip(signed)
+}
+
+// SignatureVerifier checks requests signed by the balancer, it is meant to be used by backends written in Go.
+// Nonces are remembered for as long as their timestamp is accepted, so a signed request is accepted only once.
+type SignatureVerifier struct {
+	keys      []SigningKey
+	maxSkew   time.Duration
+	mu        sync.Mutex
+	nonces    map[string]time.Time // nonce to when it stops being accepted anyway
+	nextPrune time.Time
+}
+
+func NewSignatureVerifier(keys []SigningKey, maxSkew time.Duration) *SignatureVerifier {
+	return &SignatureVerifier{keys: keys, maxSkew: maxSkew, nonces: make(map[string]time.Time)}
+}
+
+// Verify accepts the signature of any known key, the previous key signature is checked if the active key is unknown
+func (v *SignatureVerifier) Verify(r *http.Request) error {
+	timestamp := r.Header.Get(SignatureTimestampHeader)
+	nonce := r.Header.Get(SignatureNonceHeader)
+	if r.Header.Get(SignatureHeader) == "" || timestamp == "" || nonce == "" {
+		return ErrMissingSignature
+	}
+
+	signature, keyID := r.Header.Get(SignatureHeader), r.Header.Get(SignatureKeyIDHeader)
+	key, ok := findSigningKey(v.keys, keyID)
+	if !ok && r.Header.Get(PreviousSignatureHeader) != "" {
+		signature, keyID = r.Header.Get(PreviousSignatureHeader), r.Header.Get(PreviousSignatureKeyIDHeader)
+		key, ok = findSigningKey(v.keys, keyID)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSigningKey, keyID)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	signedAt := time.Unix(unix, 0)
+	if skew := time.Since(signedAt); skew > v.maxSkew || skew < -v.maxSkew {
+		return ErrSignatureExpired
+	}
+
+	bodyHash, err := hashBody(r)
+	if err != nil {
+		return err
+	}
+
+	expected := sign(key.Secret, timestamp, nonce, r.Method, r.URL.RequestURI(), bodyHash)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return v.useNonce(nonce, signedAt.Add(v.maxSkew))
+}
+
+// useNonce records a nonce of a valid signature, expired nonces are dropped at most once per skew
+func (v *SignatureVerifier) useNonce(nonce string, expires time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if now.After(v.nextPrune) {
+		for seen, seenExpires := range v.nonces {
+			if now.After(seenExpires) {
+				delete(v.nonces, seen)
+			}
+		}
+		v.nextPrune = now.Add(v.maxSkew)
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplayedSignature
+	}
+	v.nonces[nonce] = expires
+
+	return nil
+}
+
+// sign computes hex(hmac-sha256(timestamp \n nonce \n method \n request uri \n body hash))
+func sign(secret, timestamp, nonce, method, requestURI, bodyHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", timestamp, nonce, method, requestURI, bodyHash)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashBody returns hex encoded sha256 of the body and restores it for further reading, also by GetBody
+func hashBody(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
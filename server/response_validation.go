@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrInvalidBackendResponse = errors.New("invalid backend response")
+
+// ResponseValidationConfig describes checks applied to responses of a pool, zero value disables validation
+type ResponseValidationConfig struct {
+	ContentTypes       []string // allowed media types, empty allows any
+	MaxBodySize        ByteSize // 0 is unlimited
+	RequireJSON        bool     // body must be valid JSON
+	RequiredJSONFields []string // top-level fields the JSON body must contain, implies RequireJSON
+	Enforce            bool     // turn violations into 502, otherwise they are only logged and counted
+}
+
+func (c ResponseValidationConfig) enabled() bool {
+	return len(c.ContentTypes) > 0 || c.MaxBodySize > 0 || c.RequireJSON || len(c.RequiredJSONFields) > 0
+}
+
+// responseValidator checks proxied responses, it is used as ReverseProxy.ModifyResponse
+type responseValidator struct {
+	config     ResponseValidationConfig
+	violations atomic.Uint64
+}
+
+func newResponseValidator(config ResponseValidationConfig) *responseValidator {
+	return &responseValidator{config: config}
+}
+
+func (v *responseValidator) validate(resp *http.Response) error {
+	if !v.config.enabled() {
+		return nil
+	}
+
+	if err := v.check(resp); err != nil {
+		v.recordViolation(resp, err)
+
+		if v.config.Enforce {
+			return fmt.Errorf("%w: %v", ErrInvalidBackendResponse, err)
+		}
+	}
+
+	return nil
+}
+
+func (v *responseValidator) recordViolation(resp *http.Response, err error) {
+	v.violations.Add(1)
+	slog.WarnContext(resp.Request.Context(), "Backend response validation failed", "url", resp.Request.URL.String(), "error", err)
+}
+
+func (v *responseValidator) check(resp *http.Response) error {
+	if len(v.config.ContentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || !slices.Contains(v.config.ContentTypes, strings.ToLower(mediaType)) {
+			return fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+		}
+	}
+
+	if v.config.MaxBodySize > 0 && resp.ContentLength > int64(v.config.MaxBodySize) {
+		return fmt.Errorf("body size %d exceeds %d", resp.ContentLength, v.config.MaxBodySize)
+	}
+
+	// event streams are no JSON documents, their events are checked for size only
+	requireJSON := (v.config.RequireJSON || len(v.config.RequiredJSONFields) > 0) && !isEventStream(resp.Header)
+	unknownLength := resp.ContentLength < 0
+	if unknownLength && (requireJSON || v.config.MaxBodySize > 0) {
+		// the proxy flushes responses of unknown length as they arrive, buffering them would hold back streams,
+		// so they are checked while passing through and a violation found late can only abort the response
+		resp.Body = &validatingBody{ReadCloser: resp.Body, validator: v, resp: resp, requireJSON: requireJSON}
+		return nil
+	}
+	if !requireJSON {
+		return nil
+	}
+
+	// the body has to be buffered, it is put back so the client still receives it
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error reading body: %w", err)
+	}
+
+	return v.checkJSON(body)
+}
+
+func (v *responseValidator) checkJSON(body []byte) error {
+	var fields map[string]json.RawMessage
+	if len(v.config.RequiredJSONFields) == 0 {
+		if !json.Valid(body) {
+			return errors.New("body is not valid JSON")
+		}
+	} else if err := json.Unmarshal(body, &fields); err != nil {
+		return fmt.Errorf("body is not a JSON object: %w", err)
+	}
+
+	for _, field := range v.config.RequiredJSONFields {
+		if _, ok := fields[field]; !ok {
+			return fmt.Errorf("body is missing field %q", field)
+		}
+	}
+
+	return nil
+}
+
+// validatingBody counts the bytes of a response of unknown length as the client reads them and keeps a copy for the
+// JSON checks at the end. Violations are recorded once, when enforcing the response is aborted with the violation.
+type validatingBody struct {
+	io.ReadCloser
+	validator   *responseValidator
+	resp        *http.Response
+	requireJSON bool
+	read        int64
+	body        bytes.Buffer // copy for the JSON checks
+	violation   error
+}
+
+func (b *validatingBody) Read(p []byte) (int, error) {
+	if b.violation != nil && b.validator.config.Enforce {
+		return 0, b.violation
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	maxBodySize := int64(b.validator.config.MaxBodySize)
+	if b.violation == nil && maxBodySize > 0 && b.read > maxBodySize {
+		b.fail(fmt.Errorf("body size exceeds %d", maxBodySize))
+	}
+	// an oversized body fails anyway, keeping more of it is pointless
+	if b.requireJSON && b.violation == nil {
+		b.body.Write(p[:n])
+	}
+	if b.requireJSON && b.violation == nil && errors.Is(err, io.EOF) {
+		if jsonErr := b.validator.checkJSON(b.body.Bytes()); jsonErr != nil {
+			b.fail(jsonErr)
+		}
+	}
+
+	if b.violation != nil && b.validator.config.Enforce {
+		return n, b.violation
+	}
+
+	return n, err
+}
+
+func (b *validatingBody) fail(err error) {
+	b.violation = fmt.Errorf("%w: %v", ErrInvalidBackendResponse, err)
+	b.validator.recordViolation(b.resp, err)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// RetryConfig retries proxied requests against another backend when an attempt fails before the backend sent any response.
+// Without buffering only idempotent requests without a body are retried, with it any request whose body fits MaxBufferedBodySize.
+type RetryConfig struct {
+	Attempts            int // additional attempts after the first one, 0 disables retries
+	BufferRequestBodies bool
+	MaxBufferedBodySize ByteSize // larger bodies are streamed and their requests are not retried
+}
+
+// idempotentMethods may be repeated without changing the outcome
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+type retryAttemptKey struct{}
+
+// retryAttempt tells the error handler of a backend to leave the response to a further attempt
+type retryAttempt struct {
+	remaining int
+	err       error          // set by the error handler when the attempt failed before a response was written
+	tried     []http.Handler // backends which failed already
+}
+
+func retryAttemptFromRequest(r *http.Request) *retryAttempt {
+	attempt, _ := r.Context().Value(retryAttemptKey{}).(*retryAttempt)
+	return attempt
+}
+
+// prepareRetries returns the number of attempts the request may get after the first one, its body is buffered to be replayed if needed
+func prepareRetries(r *http.Request, config RetryConfig) (int, error) {
+	if config.Attempts <= 0 || (!idempotentMethods[r.Method] && !config.BufferRequestBodies) {
+		return 0, nil
+	}
+	if r.ContentLength == 0 || r.Body == nil || r.Body == http.NoBody {
+		return config.Attempts, nil
+	}
+	if !config.BufferRequestBodies {
+		return 0, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(config.MaxBufferedBodySize)+1))
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(body)) > int64(config.MaxBufferedBodySize) {
+		// too large to keep, the part read already goes first
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return 0, nil
+	}
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+
+	return config.Attempts, nil
+}
+
+// failed reports whether an earlier attempt failed on s
+func (a *retryAttempt) failed(s *server) bool {
+	for _, handler := range a.tried {
+		if handler == http.Handler(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// untried returns picked unless it failed already in which case another healthy server which did not fail is preferred
+func (a *retryAttempt) untried(servers []*server, picked *server) *server {
+	if !a.failed(picked) {
+		return picked
+	}
+	for _, s := range servers {
+		if !a.failed(s) {
+			return s
+		}
+	}
+
+	return picked
+}
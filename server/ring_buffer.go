@@ -0,0 +1,54 @@
+package server
+
+import "sync"
+
+// ringBuffer is a fixed size buffer keeping only the latest entries
+type ringBuffer[T any] struct {
+	mu      sync.Mutex
+	entries []T
+	next    int
+	full    bool
+}
+
+func newRingBuffer[T any](size int) *ringBuffer[T] {
+	return &ringBuffer[T]{entries: make([]T, size)}
+}
+
+func (b *ringBuffer[T]) add(entry T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// reset drops all entries
+func (b *ringBuffer[T]) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.entries)
+	b.next = 0
+	b.full = false
+}
+
+// list returns the entries from newest to oldest
+func (b *ringBuffer[T]) list() []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := b.next
+	if b.full {
+		count = len(b.entries)
+	}
+
+	list := make([]T, 0, count)
+	for i := 1; i <= count; i++ {
+		list = append(list, b.entries[(b.next-i+len(b.entries))%len(b.entries)])
+	}
+
+	return list
+}
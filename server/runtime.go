@@ -0,0 +1,62 @@
+package server
+
+import (
+	"log"
+	"log/slog"
+	"runtime/debug"
+	"runtime/metrics"
+
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// RuntimeConfig holds optional Go runtime tuning applied at startup, zero values keep the runtime defaults
+type RuntimeConfig struct {
+	AutoMaxProcs bool     // set GOMAXPROCS from the container CPU quota
+	GCPercent    int      // GOGC, 0 keeps the default, negative disables GC
+	MemoryLimit  ByteSize // GOMEMLIMIT, 0 keeps the default
+}
+
+// RuntimeSettings are the effective runtime values
+type RuntimeSettings struct {
+	GoMaxProcs  int   `json:"goMaxProcs"`
+	GCPercent   int   `json:"gcPercent"`
+	MemoryLimit int64 `json:"memoryLimit"`
+}
+
+// ApplyRuntimeConfig tunes the runtime according to the config and logs the effective values
+func ApplyRuntimeConfig(config RuntimeConfig) error {
+	if config.AutoMaxProcs {
+		if _, err := maxprocs.Set(maxprocs.Logger(log.Printf)); err != nil {
+			return err
+		}
+	}
+
+	if config.GCPercent != 0 {
+		debug.SetGCPercent(config.GCPercent)
+	}
+
+	if config.MemoryLimit > 0 {
+		debug.SetMemoryLimit(int64(config.MemoryLimit))
+	}
+
+	settings := CurrentRuntimeSettings()
+	slog.Info("Runtime settings", "gomaxprocs", settings.GoMaxProcs, "gogc", settings.GCPercent, "gomemlimit", settings.MemoryLimit)
+
+	return nil
+}
+
+// CurrentRuntimeSettings reads the effective runtime values without modifying them
+func CurrentRuntimeSettings() RuntimeSettings {
+	samples := []metrics.Sample{
+		{Name: "/sched/gomaxprocs:threads"},
+		{Name: "/gc/gogc:percent"},
+		{Name: "/gc/gomemlimit:bytes"},
+	}
+	metrics.Read(samples)
+
+	return RuntimeSettings{
+		GoMaxProcs:  int(samples[0].Value.Uint64()),
+		GCPercent:   int(samples[1].Value.Uint64()),
+		MemoryLimit: int64(samples[2].Value.Uint64()),
+	}
+}
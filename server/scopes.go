@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// ScopeConfig restricts registered clients to route groups. Routes maps path patterns to the scope they require, a pattern
+// may start with a method such as "GET /clients/*" to tell reading from writing, it wins over the bare path pattern.
+// Clients grants scopes by client name, Default those of other clients. Clients without any granted scopes access every route.
+type ScopeConfig struct {
+	Routes  map[string]string   // e.g. "/admin/*": "admin", "POST /jobs": "jobs:submit"
+	Clients map[string][]string // e.g. "reporting": {"jobs:read"}
+	Default []string
+}
+
+// Validate checks no route or client has an empty scope
+func (c ScopeConfig) Validate() error {
+	for route, scope := range c.Routes {
+		if scope == "" {
+			return fmt.Errorf("route %s requires an empty scope", route)
+		}
+	}
+	for name, scopes := range c.Clients {
+		for _, scope := range scopes {
+			if scope == "" {
+				return fmt.Errorf("client %s is granted an empty scope", name)
+			}
+		}
+	}
+	for _, scope := range c.Default {
+		if scope == "" {
+			return errors.New("default scopes contain an empty scope")
+		}
+	}
+
+	return nil
+}
+
+// grantedScopes returns the scopes a client registers with, nil when it is not restricted
+func (c ScopeConfig) grantedScopes(name string) []string {
+	if scopes, ok := c.Clients[name]; ok {
+		return scopes
+	}
+
+	return c.Default
+}
+
+// ScopeError details a request refused for lacking a scope
+type ScopeError struct {
+	Error         string   `json:"error"`
+	RequiredScope string   `json:"requiredScope"`
+	GrantedScopes []string `json:"grantedScopes"`
+}
+
+// WithScopes refuses requests of authorized clients lacking the scope of the route with 403, it has to follow
+// WithConditionalAuth, requests to paths excluded from auth carry no client and pass
+func WithScopes(routes map[string]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(routes) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				client, ok := ClientFromContext(r.Context())
+				if !ok {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				pattern, ok := matchRoutePattern(routes, r.Method+" "+r.URL.Path)
+				if !ok {
+					pattern, ok = matchRoutePattern(routes, r.URL.Path)
+				}
+				if ok && !client.HasScope(routes[pattern]) {
+					slog.WarnContext(r.Context(), "Client lacks scope", "client", client.Name, "path", r.URL.Path, "scope", routes[pattern])
+					writeJSON(w, http.StatusForbidden, ScopeError{Error: "Forbidden", RequiredScope: routes[pattern], GrantedScopes: client.Scopes})
+					return
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// CapacityManager limits the number of requests a pool proxies concurrently
+type CapacityManager interface {
+	AcquireCapacityWithTimeout(ctx context.Context, timeout time.Duration) error
+	ReleaseCapacity()
+	GetMaxCapacity() int
+	GetAvailableCapacity() int
+	GetWaiting() int
+	QueueStats() QueueStats
+}
+
+// ServerPool is a group of backends requests are balanced across, handlers and the router depend on it instead of
+// ProxyServerPool so tests can substitute deterministic fakes, see the servertest package
+type ServerPool interface {
+	CapacityManager
+
+	// NextServer acquires capacity and returns it leased together with the backend chosen for the request
+	NextServer(r *http.Request) (*Lease, error)
+	Name() string
+
+	Backends() []BackendStatus
+	HealthHistory(id int) ([]HealthCheckResult, bool)
+	RegisterBackend(ctx context.Context, rawUrl string, weight int, heartbeatTTL time.Duration) (BackendStatus, error)
+	Heartbeat(rawUrl string) (BackendStatus, error)
+	SetBackendEnabled(id int, enabled bool) (BackendStatus, error)
+
+	BalancingMode() string
+	SetBalancingMode(mode string) error
+
+	SetHealthChecksPaused(paused bool)
+	ToggleHealthChecksPaused() bool
+	HealthChecksPaused() bool
+
+	GetRequests() uint64
+	GetResponseViolations() uint64
+	RecentErrors() []ProxyError
+	ErrorBudget() (ErrorBudgetStatus, bool)
+	Bandit() (BanditStats, bool)
+	Fairness() FairnessStats
+	ConnectionStats() ConnectionStats
+
+	// Drain lets requests in flight finish once the pool no longer receives traffic, those outlasting the drain timeout are cancelled
+	Drain()
+	// CancelDrain stops a drain once the pool receives traffic again, so its deadline does not cancel requests accepted since
+	CancelDrain()
+	BeginShutdown()
+	Shutdown(ctx context.Context) error
+}
+
+var _ ServerPool = (*ProxyServerPool)(nil)
+
+// Lease is capacity granted to a request together with the backend serving it. The capacity is returned exactly once,
+// when ServeHTTP returns, panics included, or when Release is called on a lease which is not going to be served.
+type Lease struct {
+	backend  http.Handler
+	release  func()
+	released atomic.Bool
+}
+
+// NewLease leases capacity returned by release to a request served by backend
+func NewLease(backend http.Handler, release func()) *Lease {
+	return &Lease{backend: backend, release: release}
+}
+
+// ServeHTTP serves the request with the leased backend and returns the capacity afterwards
+func (l *Lease) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer l.Release()
+
+	l.backend.ServeHTTP(w, r)
+}
+
+// Release returns the capacity, calls after the first one do nothing
+func (l *Lease) Release() {
+	if l.released.CompareAndSwap(false, true) {
+		l.release()
+	}
+}
@@ -0,0 +1,191 @@
+// Package servertest provides deterministic fakes of server interfaces for tests, they start no goroutines and never sleep
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/javor454/balancer/server"
+)
+
+// FakeServerPool serves every request with Handler. Capacity is granted immediately or refused with
+// server.ErrNoCapacity, there is no waiting.
+type FakeServerPool struct {
+	PoolName      string
+	Handler       http.Handler
+	NextServerErr error // returned by NextServer instead of a handler when set
+	MaxCapacity   int
+	BackendList   []server.BackendStatus
+
+	mu                 sync.Mutex
+	inUse              int
+	requests           uint64
+	healthChecksPaused bool
+	shuttingDown       bool
+}
+
+var _ server.ServerPool = (*FakeServerPool)(nil)
+
+// NewFakeServerPool creates a fake pool with the given capacity serving requests with handler
+func NewFakeServerPool(handler http.Handler, maxCapacity int) *FakeServerPool {
+	return &FakeServerPool{PoolName: "fake", Handler: handler, MaxCapacity: maxCapacity}
+}
+
+func (p *FakeServerPool) AcquireCapacityWithTimeout(ctx context.Context, timeout time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shuttingDown {
+		return server.ErrShuttingDown
+	}
+	if p.inUse >= p.MaxCapacity {
+		return server.ErrNoCapacity
+	}
+	p.inUse++
+
+	return nil
+}
+
+func (p *FakeServerPool) ReleaseCapacity() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inUse = max(p.inUse-1, 0)
+}
+
+func (p *FakeServerPool) GetMaxCapacity() int {
+	return p.MaxCapacity
+}
+
+func (p *FakeServerPool) GetAvailableCapacity() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.MaxCapacity - p.inUse
+}
+
+func (p *FakeServerPool) GetWaiting() int {
+	return 0
+}
+
+func (p *FakeServerPool) QueueStats() server.QueueStats {
+	return server.QueueStats{AdmittedRatio: 1}
+}
+
+func (p *FakeServerPool) NextServer(r *http.Request) (*server.Lease, error) {
+	p.mu.Lock()
+	p.requests++
+	p.mu.Unlock()
+
+	if err := p.AcquireCapacityWithTimeout(r.Context(), 0); err != nil {
+		return nil, err
+	}
+	if p.NextServerErr != nil {
+		p.ReleaseCapacity()
+		return nil, p.NextServerErr
+	}
+
+	return server.NewLease(p.Handler, p.ReleaseCapacity), nil
+}
+
+func (p *FakeServerPool) Name() string {
+	return p.PoolName
+}
+
+func (p *FakeServerPool) Backends() []server.BackendStatus {
+	return p.BackendList
+}
+
+func (p *FakeServerPool) HealthHistory(id int) ([]server.HealthCheckResult, bool) {
+	return nil, false
+}
+
+func (p *FakeServerPool) RegisterBackend(ctx context.Context, rawUrl string, weight int, heartbeatTTL time.Duration) (server.BackendStatus, error) {
+	return server.BackendStatus{}, server.ErrStaticBackend
+}
+
+func (p *FakeServerPool) Heartbeat(rawUrl string) (server.BackendStatus, error) {
+	return server.BackendStatus{}, server.ErrUnknownBackend
+}
+
+func (p *FakeServerPool) SetBackendEnabled(id int, enabled bool) (server.BackendStatus, error) {
+	return server.BackendStatus{}, server.ErrUnknownBackend
+}
+
+func (p *FakeServerPool) BalancingMode() string {
+	return server.BalancingRoundRobin
+}
+
+func (p *FakeServerPool) SetBalancingMode(mode string) error {
+	return server.ErrUnknownBalancingMode
+}
+
+func (p *FakeServerPool) SetHealthChecksPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.healthChecksPaused = paused
+}
+
+func (p *FakeServerPool) ToggleHealthChecksPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.healthChecksPaused = !p.healthChecksPaused
+	return p.healthChecksPaused
+}
+
+func (p *FakeServerPool) HealthChecksPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.healthChecksPaused
+}
+
+func (p *FakeServerPool) Drain() {}
+
+func (p *FakeServerPool) CancelDrain() {}
+
+func (p *FakeServerPool) GetRequests() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.requests
+}
+
+func (p *FakeServerPool) GetResponseViolations() uint64 {
+	return 0
+}
+
+func (p *FakeServerPool) RecentErrors() []server.ProxyError {
+	return nil
+}
+
+func (p *FakeServerPool) ErrorBudget() (server.ErrorBudgetStatus, bool) {
+	return server.ErrorBudgetStatus{}, false
+}
+
+func (p *FakeServerPool) Bandit() (server.BanditStats, bool) {
+	return server.BanditStats{}, false
+}
+
+func (p *FakeServerPool) Fairness() server.FairnessStats {
+	return server.FairnessStats{Pool: p.PoolName}
+}
+
+func (p *FakeServerPool) ConnectionStats() server.ConnectionStats {
+	return server.ConnectionStats{}
+}
+
+func (p *FakeServerPool) BeginShutdown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.shuttingDown = true
+}
+
+func (p *FakeServerPool) Shutdown(ctx context.Context) error {
+	return nil
+}
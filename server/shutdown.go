@@ -2,7 +2,7 @@ package server
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"sync"
@@ -27,11 +27,11 @@ func NewShutdownHandler() *ShutdownHandler {
 
 // CreateRootCtxWithShutdown Creates a context which is cancelled on SIGINT or SIGTERM.
 func (s *ShutdownHandler) CreateRootCtxWithShutdown() context.Context {
-	log.Print("Setting up shutdown handler...")
+	slog.Info("Setting up shutdown handler")
 	signal.Notify(s.shutdownChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-s.shutdownChan
-		log.Printf("Received shutdown signal: %v", sig)
+		slog.Info("Received shutdown signal", "signal", sig)
 		s.triggerShutdown()
 	}()
 
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ShutdownReport makes a graceful shutdown auditable, it is logged and optionally written to a file as JSON
+type ShutdownReport struct {
+	Reason            string          `json:"reason"`
+	StartedAt         time.Time       `json:"startedAt"`
+	DurationMs        float64         `json:"durationMs"`
+	InFlightAtStart   int64           `json:"inFlightAtStart"`   // requests leased to backends when shutdown began
+	InFlightCompleted int64           `json:"inFlightCompleted"` // of those, finished before the shutdown timeout
+	InFlightAborted   int64           `json:"inFlightAborted"`   // of those, still running when the process exits
+	QueuedReleased    int             `json:"queuedReleased"`    // requests waiting for capacity told to go elsewhere
+	Phases            []ShutdownPhase `json:"phases"`
+	Error             string          `json:"error,omitempty"`
+}
+
+// ShutdownPhase is a step of the shutdown and how long it took
+type ShutdownPhase struct {
+	Name       string  `json:"name"`
+	DurationMs float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// NewShutdownReport starts a report, called before anything is shut down so the pools still show what is in flight and queued
+func NewShutdownReport(reason string, pools []ServerPool) *ShutdownReport {
+	r := &ShutdownReport{Reason: reason, StartedAt: time.Now()}
+	for _, pool := range pools {
+		r.InFlightAtStart += inFlight(pool)
+		r.QueuedReleased += pool.GetWaiting()
+	}
+
+	return r
+}
+
+// Phase runs a step of the shutdown and records its duration and error
+func (r *ShutdownReport) Phase(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+
+	phase := ShutdownPhase{Name: name, DurationMs: durationMs(time.Since(start))}
+	if err != nil {
+		phase.Error = err.Error()
+	}
+	r.Phases = append(r.Phases, phase)
+
+	return err
+}
+
+// Finish counts requests still in flight as aborted, logs the report and writes it to path unless it is empty
+func (r *ShutdownReport) Finish(pools []ServerPool, shutdownErr error, path string) error {
+	for _, pool := range pools {
+		r.InFlightAborted += inFlight(pool)
+	}
+	r.InFlightAborted = min(r.InFlightAborted, r.InFlightAtStart)
+	r.InFlightCompleted = r.InFlightAtStart - r.InFlightAborted
+	r.DurationMs = durationMs(time.Since(r.StartedAt))
+	if shutdownErr != nil {
+		r.Error = shutdownErr.Error()
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding shutdown report: %w", err)
+	}
+	slog.Info("Shutdown report", "report", r)
+
+	if path == "" {
+		return nil
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing shutdown report: %w", err)
+	}
+
+	return nil
+}
+
+func inFlight(pool ServerPool) int64 {
+	var total int64
+	for _, backend := range pool.Backends() {
+		total += backend.InFlight
+	}
+
+	return total
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
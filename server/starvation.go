@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StarvationConfig warns about registered clients whose requests wait for capacity longer than Threshold, zero Threshold disables it.
+// Starving clients are checked every Interval, a tenth of Threshold by default, and alerted once until they stop starving.
+type StarvationConfig struct {
+	Threshold    time.Duration
+	Interval     time.Duration
+	AlertWebhook string // URL receiving a JSON StarvationAlert for newly starving clients
+}
+
+// StarvingClient is a registered client with requests waiting for capacity longer than the threshold
+type StarvingClient struct {
+	Client        string  `json:"client"`
+	Waiting       int     `json:"waiting"` // requests of the client waiting longer than the threshold
+	LongestWaitMs float64 `json:"longestWaitMs"`
+}
+
+// StarvationAlert is sent to the alert webhook when clients start starving
+type StarvationAlert struct {
+	Pool    string           `json:"pool"`
+	Time    time.Time        `json:"time"`
+	Clients []StarvingClient `json:"clients"`
+}
+
+// starvationDetector remembers starving clients of a pool so each is alerted once per starvation
+type starvationDetector struct {
+	pool     string
+	config   StarvationConfig
+	mu       sync.Mutex
+	starving []StarvingClient
+	alerted  map[string]struct{}
+	events   atomic.Uint64 // clients which started starving since start
+}
+
+func newStarvationDetector(pool string, config StarvationConfig) *starvationDetector {
+	if config.Threshold <= 0 {
+		return nil
+	}
+	if config.Interval <= 0 {
+		config.Interval = max(config.Threshold/10, 100*time.Millisecond)
+	}
+
+	return &starvationDetector{pool: pool, config: config, alerted: make(map[string]struct{})}
+}
+
+// watch checks the waiters of the queue every interval until ctx is cancelled
+func (d *starvationDetector) watch(ctx context.Context, queue *fairQueue, httpClient *http.Client) {
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			starving := queue.starving(d.config.Threshold)
+
+			var started []StarvingClient
+			d.mu.Lock()
+			for client := range d.alerted {
+				if !slices.ContainsFunc(starving, func(s StarvingClient) bool { return s.Client == client }) {
+					delete(d.alerted, client)
+				}
+			}
+			for _, s := range starving {
+				if _, ok := d.alerted[s.Client]; !ok {
+					d.alerted[s.Client] = struct{}{}
+					started = append(started, s)
+				}
+			}
+			d.starving = starving
+			d.mu.Unlock()
+
+			if len(started) == 0 {
+				continue
+			}
+			d.events.Add(uint64(len(started)))
+			for _, s := range started {
+				slog.Warn("Client starving for capacity", "pool", d.pool, "client", s.Client, "waiting", s.Waiting, "longestWaitMs", s.LongestWaitMs)
+			}
+			if d.config.AlertWebhook != "" {
+				d.sendAlert(ctx, httpClient, StarvationAlert{Pool: d.pool, Time: time.Now(), Clients: started})
+			}
+		}
+	}
+}
+
+// snapshot returns the clients starving at the last check and the number of starvations since start
+func (d *starvationDetector) snapshot() ([]StarvingClient, uint64) {
+	if d == nil {
+		return nil, 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.starving), d.events.Load()
+}
+
+func (d *starvationDetector) sendAlert(ctx context.Context, httpClient *http.Client, alert StarvationAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		slog.Error("Failed to encode starvation alert", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.AlertWebhook, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to create starvation alert", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		slog.Error("Failed to send starvation alert", "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Warn("Starvation alert webhook failed", "status", resp.StatusCode)
+	}
+}
+
+// starving returns registered clients with requests waiting longer than threshold, requests without a client are not reported
+func (q *fairQueue) starving(threshold time.Duration) []StarvingClient {
+	now := time.Now()
+	clients := make(map[string]*StarvingClient)
+
+	q.mu.Lock()
+	for _, waiter := range q.waiters {
+		waited := now.Sub(waiter.since)
+		if waiter.client == "" || waited < threshold {
+			continue
+		}
+		s, ok := clients[waiter.client]
+		if !ok {
+			s = &StarvingClient{Client: waiter.client}
+			clients[waiter.client] = s
+		}
+		s.Waiting++
+		s.LongestWaitMs = max(s.LongestWaitMs, float64(waited.Microseconds())/1000)
+	}
+	q.mu.Unlock()
+
+	starving := make([]StarvingClient, 0, len(clients))
+	for _, name := range slices.Sorted(maps.Keys(clients)) {
+		starving = append(starving, *clients[name])
+	}
+
+	return starving
+}
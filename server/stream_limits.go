@@ -0,0 +1,107 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// BalancerStatusStreamLimited marks event streams refused by WithStreamLimits
+	BalancerStatusStreamLimited = "stream-limited"
+
+	// streamLimitRetryAfter is the Retry-After of refused streams in seconds, streams end far less predictably than requests
+	streamLimitRetryAfter = 5
+)
+
+// StreamLimitConfig bounds concurrent server-sent event streams, the dashboard's as well as proxied ones. Requests accepting
+// text/event-stream count as streams. Zero limits are unlimited.
+type StreamLimitConfig struct {
+	PerClient int // streams of one client, unauthenticated clients are told apart by address
+	Total     int // streams of all clients
+}
+
+// streamLimiter counts open streams per client and in total
+type streamLimiter struct {
+	config  StreamLimitConfig
+	mu      sync.Mutex
+	total   int
+	streams map[string]int
+}
+
+func (l *streamLimiter) acquire(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.Total > 0 && l.total >= l.config.Total || l.config.PerClient > 0 && l.streams[client] >= l.config.PerClient {
+		return false
+	}
+	l.total++
+	l.streams[client]++
+
+	return true
+}
+
+func (l *streamLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.streams[client]--; l.streams[client] == 0 {
+		delete(l.streams, client)
+	}
+}
+
+// WithStreamLimits refuses event streams beyond the limits with 429 so a misbehaving dashboard cannot exhaust file
+// descriptors. It has to follow WithConditionalAuth to tell clients apart by name, listeners sharing the returned
+// middleware share the limits.
+func WithStreamLimits(config StreamLimitConfig) Middleware {
+	limiter := &streamLimiter{config: config, streams: make(map[string]int)}
+
+	return func(next http.Handler) http.Handler {
+		if config.PerClient <= 0 && config.Total <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if !acceptsEventStream(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				client := "addr:" + clientAddr(r).String()
+				if c, ok := ClientFromContext(r.Context()); ok {
+					client = "client:" + c.Name
+				}
+
+				if !limiter.acquire(client) {
+					slog.WarnContext(r.Context(), "Event stream limit reached", "client", client, "path", r.URL.Path)
+					w.Header().Set(BalancerStatusHeader, BalancerStatusStreamLimited)
+					w.Header().Set("Retry-After", strconv.Itoa(streamLimitRetryAfter))
+					http.Error(w, "Too many event streams", http.StatusTooManyRequests)
+					return
+				}
+				defer limiter.release(client)
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// acceptsEventStream reports whether the request asks for server-sent events as EventSource does
+func acceptsEventStream(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(value, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var ErrSamePool = errors.New("cannot switch a pool to itself")
+
+// switchover moves all traffic of one pool to another, e.g. from blue to green during a deployment
+type switchover struct {
+	from       ServerPool
+	to         ServerPool
+	switchedAt time.Time
+}
+
+// SwitchoverStatus is the latest switchover, InFlight counts requests still draining on the old pool
+type SwitchoverStatus struct {
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to,omitempty"`
+	SwitchedAt time.Time `json:"switchedAt"`
+	InFlight   int64     `json:"inFlight"`
+}
+
+type switchoverRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Switch atomically sends all traffic routed to the from pool to the other one instead, the from pool then drains.
+// Earlier switchovers to the from pool follow to the new target and a switchover away from the target is undone,
+// so switching back and forth between two pools works as expected.
+func (rt *PoolRouter) Switch(from string, to string) (SwitchoverStatus, error) {
+	fromPool, ok := rt.pool(from)
+	if !ok {
+		return SwitchoverStatus{}, fmt.Errorf("%w: %s", ErrUnknownPool, from)
+	}
+	toPool, ok := rt.pool(to)
+	if !ok {
+		return SwitchoverStatus{}, fmt.Errorf("%w: %s", ErrUnknownPool, to)
+	}
+	if fromPool == toPool {
+		return SwitchoverStatus{}, ErrSamePool
+	}
+
+	rt.switchMu.Lock()
+	defer rt.switchMu.Unlock()
+
+	redirects := make(map[ServerPool]ServerPool, len(*rt.redirects.Load())+1)
+	for source, target := range *rt.redirects.Load() {
+		if target == fromPool {
+			target = toPool
+		}
+		redirects[source] = target
+	}
+	delete(redirects, toPool)
+	redirects[fromPool] = toPool
+	rt.redirects.Store(&redirects)
+
+	last := &switchover{from: fromPool, to: toPool, switchedAt: time.Now()}
+	rt.lastSwitch.Store(last)
+
+	slog.Info("Switched traffic between pools", "from", from, "to", to)
+	// switching back to a pool still draining must not let the earlier deadline cancel its new requests
+	toPool.CancelDrain()
+	fromPool.Drain()
+
+	return last.status(), nil
+}
+
+// Switchover returns the latest switchover, empty if there was none
+func (rt *PoolRouter) Switchover() SwitchoverStatus {
+	last := rt.lastSwitch.Load()
+	if last == nil {
+		return SwitchoverStatus{}
+	}
+
+	return last.status()
+}
+
+// switched returns the pool serving traffic routed to pool
+func (rt *PoolRouter) switched(pool ServerPool) ServerPool {
+	if target, ok := (*rt.redirects.Load())[pool]; ok {
+		return target
+	}
+
+	return pool
+}
+
+// pool finds the default or a named pool by name
+func (rt *PoolRouter) pool(name string) (ServerPool, bool) {
+	if rt.defaultPool.Name() == name {
+		return rt.defaultPool, true
+	}
+	pool, ok := rt.pools[name]
+
+	return pool, ok
+}
+
+func (s *switchover) status() SwitchoverStatus {
+	status := SwitchoverStatus{From: s.from.Name(), To: s.to.Name(), SwitchedAt: s.switchedAt}
+	for _, backend := range s.from.Backends() {
+		status.InFlight += backend.InFlight
+	}
+
+	return status
+}
+
+// switchoverHandler shows the latest switchover on GET and switches traffic between pools on PUT
+func switchoverHandler(poolRouter *PoolRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, poolRouter.Switchover())
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+
+		var req switchoverRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+			return
+		}
+
+		status, err := poolRouter.Switch(req.From, req.To)
+		if errors.Is(err, ErrUnknownPool) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+	}
+}
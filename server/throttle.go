@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthLimitConfig limits proxied traffic in bytes per second, 0 is unlimited
+type BandwidthLimitConfig struct {
+	UploadBytesPerSecond   ByteSize
+	DownloadBytesPerSecond ByteSize
+}
+
+// idleBucketTTL is how long an unused per-client bucket is kept, a refilled bucket behaves like a new one so dropping it is lossless
+const idleBucketTTL = time.Minute
+
+// tokenBucket hands out bytes at rate per second with bursts of up to one second worth of bytes
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastFill time.Time
+}
+
+func newTokenBucket(bytesPerSecond int64) *tokenBucket {
+	return &tokenBucket{
+		rate:     float64(bytesPerSecond),
+		burst:    float64(bytesPerSecond),
+		tokens:   float64(bytesPerSecond),
+		lastFill: time.Now(),
+	}
+}
+
+// chunk returns the largest amount of bytes which can be waited for at once
+func (b *tokenBucket) chunk() int {
+	return max(int(b.burst), 1)
+}
+
+// wait blocks until n bytes are available, n must not exceed chunk()
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.lastFill).Seconds()*b.rate)
+	b.lastFill = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / b.rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take consumes a single token without waiting, otherwise it returns how long until one is available
+func (b *tokenBucket) take() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.lastFill).Seconds()*b.rate)
+	b.lastFill = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+	}
+	b.tokens--
+
+	return 0, true
+}
+
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return now.Sub(b.lastFill) > idleBucketTTL
+}
+
+// throttledReader limits the rate at which a request body is read
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	for _, bucket := range r.buckets {
+		p = p[:min(len(p), bucket.chunk())]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	for _, bucket := range r.buckets {
+		if waitErr := bucket.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// throttledResponseWriter limits the rate at which a response is written
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		for _, bucket := range w.buckets {
+			n = min(n, bucket.chunk())
+		}
+		for _, bucket := range w.buckets {
+			if err := bucket.wait(w.ctx, n); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Unwrap exposes the underlying writer so streamed responses can still be flushed
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bandwidthBuckets holds upload and download buckets of a single client or route
+type bandwidthBuckets struct {
+	upload   *tokenBucket
+	download *tokenBucket
+}
+
+func newBandwidthBuckets(limit BandwidthLimitConfig) *bandwidthBuckets {
+	b := &bandwidthBuckets{}
+	if limit.UploadBytesPerSecond > 0 {
+		b.upload = newTokenBucket(int64(limit.UploadBytesPerSecond))
+	}
+	if limit.DownloadBytesPerSecond > 0 {
+		b.download = newTokenBucket(int64(limit.DownloadBytesPerSecond))
+	}
+
+	return b
+}
+
+// WithBandwidthThrottling limits upload and download rates per client (by Authorization header) and per route (longest path prefix)
+func WithBandwidthThrottling(clientLimit BandwidthLimitConfig, routeLimits map[string]BandwidthLimitConfig) Middleware {
+	routeBuckets := make(map[string]*bandwidthBuckets, len(routeLimits))
+	for prefix, limit := range routeLimits {
+		routeBuckets[prefix] = newBandwidthBuckets(limit)
+	}
+
+	var (
+		clientBucketsMu sync.Mutex
+		clientBuckets   = make(map[string]*bandwidthBuckets)
+		lastPrune       = time.Now()
+	)
+	bucketsOfClient := func(name string) *bandwidthBuckets {
+		clientBucketsMu.Lock()
+		defer clientBucketsMu.Unlock()
+
+		now := time.Now()
+		if now.Sub(lastPrune) > idleBucketTTL {
+			for client, buckets := range clientBuckets {
+				if (buckets.upload == nil || buckets.upload.idle(now)) && (buckets.download == nil || buckets.download.idle(now)) {
+					delete(clientBuckets, client)
+				}
+			}
+			lastPrune = now
+		}
+
+		buckets, ok := clientBuckets[name]
+		if !ok {
+			buckets = newBandwidthBuckets(clientLimit)
+			clientBuckets[name] = buckets
+		}
+
+		return buckets
+	}
+
+	clientLimited := clientLimit.UploadBytesPerSecond > 0 || clientLimit.DownloadBytesPerSecond > 0
+
+	return func(next http.Handler) http.Handler {
+		if !clientLimited && len(routeBuckets) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				var applied []*bandwidthBuckets
+
+				if name := requestClientName(r); clientLimited && name != "" {
+					applied = append(applied, bucketsOfClient(name))
+				}
+
+				longestPrefix := ""
+				for prefix := range routeBuckets {
+					if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(longestPrefix) {
+						longestPrefix = prefix
+					}
+				}
+				if longestPrefix != "" {
+					applied = append(applied, routeBuckets[longestPrefix])
+				}
+
+				var uploadBuckets, downloadBuckets []*tokenBucket
+				for _, buckets := range applied {
+					if buckets.upload != nil {
+						uploadBuckets = append(uploadBuckets, buckets.upload)
+					}
+					if buckets.download != nil {
+						downloadBuckets = append(downloadBuckets, buckets.download)
+					}
+				}
+
+				if len(uploadBuckets) > 0 && r.Body != nil && r.Body != http.NoBody {
+					r.Body = &throttledReader{ReadCloser: r.Body, ctx: r.Context(), buckets: uploadBuckets}
+				}
+				if len(downloadBuckets) > 0 {
+					w = &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), buckets: downloadBuckets}
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
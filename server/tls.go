@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TLSConfig terminates TLS on the main listener, the admin listener stays plain. Setting CertFile and KeyFile enables it.
+// CipherSuites are names as in crypto/tls, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", they only apply up to TLS 1.2
+// because TLS 1.3 suites are not configurable. Empty CipherSuites use the secure defaults of crypto/tls.
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ReloadInterval time.Duration // how often the files are checked for changes, e.g. after a renewal, 0 never reloads
+	MinVersion     string        // "1.2" (default) or "1.3"
+	CipherSuites   []string
+	ClientCAFile   string // PEM bundle of CAs issuing client certificates, enables mutual TLS
+	ClientAuth     string // require (default) or request, the common name of a verified certificate names the client
+}
+
+const (
+	// ClientAuthRequire rejects connections without a client certificate issued by a CA of ClientCAFile
+	ClientAuthRequire = "require"
+	// ClientAuthRequest verifies client certificates when presented, clients without one authorize by their header
+	ClientAuthRequest = "request"
+)
+
+// Enabled reports whether TLS is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate checks the certificate pair is complete and the version and cipher suites are known, the files are read when serving
+func (c TLSConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("tls requires both a certificate and a key file")
+	}
+	if _, err := parseTLSVersion(c.MinVersion); err != nil {
+		return err
+	}
+	if _, err := parseCipherSuites(c.CipherSuites); err != nil {
+		return err
+	}
+	if _, err := parseClientAuth(c.ClientAuth); err != nil {
+		return err
+	}
+	if c.ClientAuth != "" && c.ClientCAFile == "" {
+		return errors.New("tls client authentication requires a client CA file")
+	}
+
+	return nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum tls version %q, expected 1.2 or 1.3", version)
+	}
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+
+	return suites, nil
+}
+
+func parseClientAuth(clientAuth string) (tls.ClientAuthType, error) {
+	switch clientAuth {
+	case "", ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	case ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return 0, fmt.Errorf("unknown tls client authentication %q, expected require or request", clientAuth)
+	}
+}
+
+// certReloader serves the certificate pair loaded last, a pair failing to load keeps the previous one in use
+type certReloader struct {
+	certFile    string
+	keyFile     string
+	certificate atomic.Pointer[tls.Certificate]
+	modTime     time.Time // latest modification of either file when it was loaded
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %w", err)
+	}
+	r.certificate.Store(&certificate)
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error reading tls certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// watch reloads the pair every interval once either file changed until stop is closed
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTime, err := r.latestModTime()
+			if err != nil || !modTime.After(r.modTime) {
+				continue
+			}
+			if err := r.load(); err != nil {
+				slog.Error("Failed to reload tls certificate, keeping the previous one", "error", err)
+				continue
+			}
+			slog.Info("Reloaded tls certificate", "certFile", r.certFile)
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate.Load(), nil
+}
+
+// setupTLS makes srv terminate TLS and keeps reloading the certificate until srv shuts down
+func setupTLS(srv *http.Server, config TLSConfig) error {
+	tlsConfig, reloader, err := newServerTLSConfig(config)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+
+	if config.ReloadInterval > 0 {
+		stop := make(chan struct{})
+		srv.RegisterOnShutdown(func() { close(stop) })
+		go reloader.watch(config.ReloadInterval, stop)
+	}
+
+	return nil
+}
+
+// newServerTLSConfig loads the certificate pair and returns the tls config of a listener serving it
+func newServerTLSConfig(config TLSConfig) (*tls.Config, *certReloader, error) {
+	minVersion, err := parseTLSVersion(config.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites, err := parseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := newCertReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: reloader.getCertificate,
+	}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading tls client CA file: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("tls client CA file %s contains no certificates", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth, _ = parseClientAuth(config.ClientAuth)
+	}
+
+	return tlsConfig, reloader, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+)
+
+// trafficSplitBuckets is the resolution of traffic splits, a hundredth of a percent
+const trafficSplitBuckets = 10000
+
+// TrafficSplitConfig sends Percent of the traffic otherwise served by the default pool to Pool, e.g. 5 to a canary pool.
+// A client always lands on the same side of a split, it is identified by its name or else by its IP address.
+type TrafficSplitConfig struct {
+	Pool    string
+	Percent float64
+}
+
+// trafficSplit is a split resolved to its pool, clients hashing below upTo take it
+type trafficSplit struct {
+	pool ServerPool
+	name string
+	upTo uint32
+}
+
+func newTrafficSplits(configs []TrafficSplitConfig, pools map[string]ServerPool) ([]trafficSplit, error) {
+	splits := make([]trafficSplit, 0, len(configs))
+	var total uint32
+	for _, config := range configs {
+		width := uint32(config.Percent * trafficSplitBuckets / 100)
+		if config.Percent <= 0 || width == 0 {
+			return nil, fmt.Errorf("traffic split to %s requires a percentage of at least 0.01", config.Pool)
+		}
+		pool, ok := pools[config.Pool]
+		if !ok {
+			return nil, fmt.Errorf("traffic split: %w: %s", ErrUnknownPool, config.Pool)
+		}
+		total += width
+		splits = append(splits, trafficSplit{pool: pool, name: config.Pool, upTo: total})
+	}
+	if total > trafficSplitBuckets {
+		return nil, errors.New("traffic splits exceed 100 percent")
+	}
+
+	return splits, nil
+}
+
+// matchTrafficSplit returns the split the client of the request falls into
+func matchTrafficSplit(r *http.Request, splits []trafficSplit) (trafficSplit, bool) {
+	if len(splits) == 0 {
+		return trafficSplit{}, false
+	}
+
+	key := requestClientName(r)
+	if client, ok := ClientFromContext(r.Context()); ok {
+		key = client.Name
+	}
+	if key == "" {
+		addr := clientAddr(r)
+		if !addr.IsValid() {
+			return trafficSplit{}, false
+		}
+		key = addr.String()
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte("split\x00"))
+	h.Write([]byte(key))
+	bucket := h.Sum32() % trafficSplitBuckets
+
+	for _, split := range splits {
+		if bucket < split.upTo {
+			return split, true
+		}
+	}
+
+	return trafficSplit{}, false
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// UpstreamTLSConfig verifies https:// backends and authenticates the balancer to them, empty settings keep the system defaults.
+// PerBackend replaces the settings of backends keyed by URL, e.g. one backend with a self-signed certificate.
+type UpstreamTLSConfig struct {
+	CAFile             string // PEM bundle of CAs trusted instead of the system roots
+	CertFile           string // client certificate for mTLS to the backend, requires KeyFile
+	KeyFile            string
+	ServerName         string // verified instead of the backend host name
+	InsecureSkipVerify bool   // accept any backend certificate, only for testing
+	PerBackend         map[string]UpstreamTLSConfig
+}
+
+func (c UpstreamTLSConfig) isZero() bool {
+	return c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && c.ServerName == "" && !c.InsecureSkipVerify
+}
+
+// Validate checks client certificates are complete, the files are read when the pool is created
+func (c UpstreamTLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("upstream tls client certificate requires both a certificate and a key file")
+	}
+	for rawUrl, config := range c.PerBackend {
+		if len(config.PerBackend) > 0 {
+			return fmt.Errorf("upstream tls of backend %s cannot have backends of its own", rawUrl)
+		}
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("backend %s: %w", rawUrl, err)
+		}
+	}
+
+	return nil
+}
+
+// forBackend returns the settings applying to the backend
+func (c UpstreamTLSConfig) forBackend(rawUrl string) (UpstreamTLSConfig, bool) {
+	if config, ok := c.PerBackend[rawUrl]; ok {
+		return config, true
+	}
+
+	return c, false
+}
+
+// applyTo sets the settings on the TLS client config of transport, a backend auth client certificate is kept unless replaced
+func (c UpstreamTLSConfig) applyTo(transport http.RoundTripper) error {
+	if c.isZero() {
+		return nil
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return errors.New("upstream tls requires an http transport")
+	}
+
+	tlsConfig := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig.Clone()
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("error reading upstream CA bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("upstream CA bundle %s contains no certificates", c.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error loading upstream client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.ServerName != "" {
+		tlsConfig.ServerName = c.ServerName
+	}
+	tlsConfig.InsecureSkipVerify = c.InsecureSkipVerify
+
+	t.TLSClientConfig = tlsConfig
+
+	return nil
+}